@@ -0,0 +1,207 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/proxy"
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/utils"
+	"github.com/sachamorard/swapper/yaml"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ProxyContainer runs haproxy and nginx in the swapper-proxy container
+	ProxyContainer = "container"
+	// ProxyBuiltin forwards the tcp ports in the node process, without proxy image
+	ProxyBuiltin = "builtin"
+	// ProxyListenersEnv lists the addresses of the listeners a detached node inherits from the node which started it
+	ProxyListenersEnv = "SWAPPER_PROXY_LISTENERS"
+)
+
+var (
+	// nodeProxy is the proxy of the node, set by --proxy
+	nodeProxy = ProxyContainer
+	// builtinProxy listens on the ports of the services when the node runs the builtin proxy
+	builtinProxy *proxy.Proxy
+)
+
+// applyBuiltinProxy swaps the backends of the builtin proxy to the containers of the configuration, the swapper-proxy
+// container of a previous start of the node is removed to free its ports
+func applyBuiltinProxy(yamlConf yaml.YamlConf) error {
+	frontends, err := builtinFrontends(yamlConf, containerIp)
+	if err != nil {
+		return err
+	}
+
+	Id, _ := utils.Command("docker ps --format {{.ID}} --filter name=swapper-proxy")
+	if Id != "" {
+		fmt.Println("[CAREFULL] builtin proxy replaces swapper-proxy, short interruption!!!")
+		_, err = utils.Command("docker rm -f swapper-proxy")
+		if err != nil {
+			return errors.New(response.ErrorMessages["proxy_stop_failed"])
+		}
+	}
+
+	if builtinProxy == nil {
+		builtinProxy = proxy.New()
+	}
+	err = builtinProxy.Apply(frontends)
+	if err != nil {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["builtin_proxy_failed"], err.Error()))
+	}
+	return nil
+}
+
+// handOverBuiltinProxy passes the listeners of the builtin proxy to the detached node cmd, after stdin, stdout and
+// stderr, so that its ports keep accepting connections while it starts
+func handOverBuiltinProxy(cmd *exec.Cmd) (files []*os.File, err error) {
+	if builtinProxy == nil {
+		return files, nil
+	}
+	addresses, files, err := builtinProxy.Files()
+	if err != nil {
+		return files, errors.New(fmt.Sprintf(response.ErrorMessages["builtin_proxy_failed"], err.Error()))
+	}
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), ProxyListenersEnv+"="+strings.Join(addresses, ","))
+	return files, nil
+}
+
+// inheritBuiltinProxy takes the listeners handed over by the node which started this one with --detach
+func inheritBuiltinProxy() error {
+	value := os.Getenv(ProxyListenersEnv)
+	if value == "" {
+		return nil
+	}
+	_ = os.Unsetenv(ProxyListenersEnv)
+	addresses := strings.Split(value, ",")
+	var files []*os.File
+	for i, address := range addresses {
+		files = append(files, os.NewFile(uintptr(3+i), address))
+	}
+	builtinProxy = proxy.New()
+	err := builtinProxy.Inherit(addresses, files)
+	if err != nil {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["builtin_proxy_failed"], err.Error()))
+	}
+	return nil
+}
+
+// builtinFrontends converts the frontends of a configuration for the builtin proxy, which only forwards tcp
+// connections. Each port of a range is a frontend, offset to the ports of the containers.
+func builtinFrontends(yamlConf yaml.YamlConf, ip func(hash string, serviceName string, index int) (string, error)) (frontends []proxy.Frontend, err error) {
+	if err := checkBuiltinProxy(yamlConf); err != nil {
+		return frontends, err
+	}
+	for _, frontend := range yamlConf.Frontends {
+		options := frontend.Proxy
+		address := frontend.Address
+		if address == "" {
+			address = "0.0.0.0"
+		}
+		var ips []string
+		for _, container := range frontend.Containers {
+			backendIp, err := ip(yamlConf.Hash, frontend.ServiceName, container.Index)
+			if err != nil {
+				return frontends, err
+			}
+			ips = append(ips, backendIp)
+		}
+
+		for listen := frontend.Listen; listen <= frontend.ListenEnd; listen++ {
+			name := frontend.Name
+			if frontend.ListenEnd != frontend.Listen {
+				name = name + "_" + strconv.Itoa(listen)
+			}
+			builtinFrontend := proxy.Frontend{
+				Name:           name,
+				Address:        net.JoinHostPort(address, strconv.Itoa(listen)),
+				Balance:        options.Balance,
+				MaxConn:        options.MaxConn,
+				ConnectTimeout: duration(options.ConnectTimeout),
+				ClientTimeout:  duration(options.ClientTimeout),
+				ServerTimeout:  duration(options.ServerTimeout),
+				CheckInterval:  duration(options.CheckInterval),
+				CheckRise:      options.CheckRise,
+				CheckFall:      options.CheckFall,
+				SendProxy:      options.SendProxy,
+				Allow:          networks(options.Allow),
+				Deny:           networks(options.Deny),
+			}
+			bind := frontend.Bind + listen - frontend.Listen
+			for i, container := range frontend.Containers {
+				builtinFrontend.Backends = append(builtinFrontend.Backends, proxy.Backend{
+					Name:    "container_" + strconv.Itoa(container.Index),
+					Address: net.JoinHostPort(ips[i], strconv.Itoa(bind)),
+					Weight:  container.Weight,
+				})
+			}
+			frontends = append(frontends, builtinFrontend)
+		}
+	}
+	return frontends, nil
+}
+
+// checkBuiltinProxy returns an error for the first option of the configuration that needs swapper-proxy
+func checkBuiltinProxy(yamlConf yaml.YamlConf) error {
+	unsupported := func(option string) error {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["builtin_proxy_unsupported"], option))
+	}
+	if yamlConf.ProxyStats.Port != 0 {
+		return unsupported("proxy-stats")
+	}
+	if yamlConf.ProxyLogs.Destination != "" {
+		return unsupported("proxy-logs")
+	}
+	for _, service := range yamlConf.Services {
+		options := []struct {
+			name string
+			used bool
+		}{
+			{"http mode, balance uri and rate-limit.requests", service.Proxy.HttpMode()},
+			{"proxy-health", service.Proxy.Health.Path != ""},
+			{"sticky", service.Proxy.Sticky.Type != ""},
+			{"rate-limit", service.Proxy.RateLimit != (yaml.RateLimit{})},
+		}
+		for _, option := range options {
+			if option.used {
+				return unsupported(option.name + " of service " + service.Name)
+			}
+		}
+	}
+	for _, frontend := range yamlConf.Frontends {
+		if frontend.Protocol != yaml.ProtocolTcp {
+			return unsupported(frontend.Protocol + " ports of service " + frontend.ServiceName)
+		}
+	}
+	return nil
+}
+
+// duration converts a duration of the proxy options, 0 keeps the default of the builtin proxy
+func duration(value string) time.Duration {
+	d, _ := time.ParseDuration(value)
+	return d
+}
+
+// networks converts the ip addresses and cidr ranges of allow and deny, checked by the yaml package
+func networks(sources []string) (ipNets []*net.IPNet) {
+	for _, source := range sources {
+		if ip := net.ParseIP(source); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			source = ip.String() + "/" + strconv.Itoa(bits)
+		}
+		if _, ipNet, err := net.ParseCIDR(source); err == nil {
+			ipNets = append(ipNets, ipNet)
+		}
+	}
+	return ipNets
+}
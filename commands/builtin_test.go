@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/proxy"
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/yaml"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBuiltinFrontends(t *testing.T) {
+	yamlConf, err := yaml.ParseSwapperYaml(`
+version: '1'
+hash: abcdef
+services:
+  api:
+    ports:
+      - 80:8080
+      - 127.0.0.1:9000-9001:7000-7001
+    proxy:
+      balance: leastconn
+      timeouts:
+        connect: 1s
+      send-proxy: true
+    allow:
+      - 10.0.0.0/8
+      - 192.168.1.4
+    containers:
+      - image: nginx
+        tag: 1.17.0
+        weight: 80
+      - image: nginx
+        tag: 1.17.0
+        weight: 20
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := func(hash string, serviceName string, index int) (string, error) {
+		return "172.17.0." + strconv.Itoa(index+2), nil
+	}
+	frontends, err := builtinFrontends(yamlConf, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frontends) != 3 {
+		t.Fatal(frontends)
+	}
+	api := frontends[0]
+	if api.Address != "0.0.0.0:80" || api.Balance != "leastconn" || api.ConnectTimeout != time.Second || api.ServerTimeout != 0 || api.SendProxy == false {
+		t.Error(api)
+	}
+	if len(api.Allow) != 2 || api.Allow[0].String() != "10.0.0.0/8" || api.Allow[1].String() != "192.168.1.4/32" || api.Deny != nil {
+		t.Error(api.Allow, api.Deny)
+	}
+	if len(api.Backends) != 2 || api.Backends[0].Address != "172.17.0.2:8080" || api.Backends[0].Weight != 80 || api.Backends[1].Name != "container_1" {
+		t.Error(api.Backends)
+	}
+	// each port of a range is offset to the ports of the containers
+	if frontends[2].Address != "127.0.0.1:9001" || frontends[2].Name != "frontend_127.0.0.1_9000-9001_9001" || frontends[2].Backends[0].Address != "172.17.0.2:7001" {
+		t.Error(frontends[1], frontends[2])
+	}
+}
+
+func TestCheckBuiltinProxy(t *testing.T) {
+	service := "services:\n  api:\n    ports:\n      - 80:80\n    containers:\n      - image: nginx\n        tag: 1.17.0\n"
+	unsupported := map[string]string{
+		"udp ports of service dns":                                      "services:\n  dns:\n    ports:\n      - 53:53/udp\n    containers:\n      - image: coredns/coredns\n        tag: 1.6.9\n",
+		"http mode, balance uri and rate-limit.requests of service api": service + "    proxy:\n      mode: http\n",
+		"proxy-health of service api":                                   service + "    proxy-health:\n      path: /status\n",
+		"sticky of service api":                                         service + "    sticky:\n      type: source\n",
+		"rate-limit of service api":                                     service + "    rate-limit:\n      concurrent: 5\n",
+		"proxy-logs":                                                    "proxy-logs:\n  destination: stdout\n" + service,
+		"proxy-stats":                                                   "proxy-stats:\n  port: 8404\n  username: admin\n  password: secret\n" + service,
+	}
+	for option, conf := range unsupported {
+		yamlConf, err := yaml.ParseSwapperYaml("version: '1'\n" + conf)
+		if err != nil {
+			t.Fatal(option, err)
+		}
+		err = checkBuiltinProxy(yamlConf)
+		if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["builtin_proxy_unsupported"], option) {
+			t.Error(option, err)
+		}
+	}
+
+	yamlConf, err := yaml.ParseSwapperYaml("version: '1'\n" + service + "    proxy:\n      balance: source\n      maxconn: 100\n    deny:\n      - 10.1.2.3\n")
+	if err != nil || checkBuiltinProxy(yamlConf) != nil {
+		t.Error(err, checkBuiltinProxy(yamlConf))
+	}
+}
+
+func TestHandOverBuiltinProxy(t *testing.T) {
+	defer func() { builtinProxy = nil }()
+	builtinProxy = nil
+	cmd := exec.Command("swapper")
+	if files, err := handOverBuiltinProxy(cmd); files != nil || err != nil || cmd.Env != nil {
+		t.Error(files, err, cmd.Env)
+	}
+
+	builtinProxy = proxy.New()
+	defer builtinProxy.Close()
+	err := builtinProxy.Apply([]proxy.Frontend{{Name: "api_80", Address: "127.0.0.1:0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := handOverBuiltinProxy(cmd)
+	if err != nil || len(files) != 1 || len(cmd.ExtraFiles) != 1 {
+		t.Fatal(files, err)
+	}
+	defer files[0].Close()
+	if cmd.Env[len(cmd.Env)-1] != ProxyListenersEnv+"=127.0.0.1:0" {
+		t.Error(cmd.Env[len(cmd.Env)-1])
+	}
+}
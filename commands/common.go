@@ -3,6 +3,7 @@ package commands
 import (
 	"context"
 	"crypto/md5"
+	"crypto/rsa"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -14,6 +15,7 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"sort"
@@ -22,12 +24,15 @@ import (
 	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/docopt/docopt-go"
 )
 
 var (
+	// haproxyBaseConf takes the global maxconn, which must not be lower than the sum of the maxconn of the frontends
 	haproxyBaseConf = `
 global
-    log 127.0.0.1 local5 debug
+    maxconn %d
+    stats socket /var/run/haproxy.sock mode 600 level user
 
 defaults
     log     global
@@ -35,8 +40,27 @@ defaults
     timeout connect 5000
     timeout client  50000
     timeout server  50000
+`
+	// haproxyPeersConf lets a reloaded haproxy get the stick tables of the old process, whose local peer is the
+	// hostname of swapper-proxy
+	haproxyPeersConf = `peers swapper
+    peer swapper-proxy 127.0.0.1:10000
+`
+	udpLogFormat = `    log_format udp "$remote_addr [$time_local] $protocol $server_port $upstream_addr $status $bytes_sent $bytes_received $session_time";`
+	udpProxyBaseConf = `pid /var/run/nginx.pid;
+load_module /usr/lib/nginx/modules/ngx_stream_module.so;
+error_log /proc/1/fd/2;
+
+events {
+    worker_connections 1024;
+}
 `
 	currentHash = ""
+	appliedYamlConf yaml.YamlConf
+	nodePrivateKey *rsa.PrivateKey
+	// drainStop ends the drain of the last swap of the node, drainDone is closed once its old containers are removed
+	drainStop chan struct{}
+	drainDone chan struct{}
 	baseYaml = `
 version: "1"
 
@@ -49,47 +73,182 @@ services:
         tag: latest`
 )
 
+var (
+	deployerRegexp = regexp.MustCompile(`(?m)^deployer: .*\n?`)
+	hashRegexp     = regexp.MustCompile(`^[a-f0-9]+$`)
+
+	// exposedPortRegexp finds the ports of docker inspect {{ .Config.ExposedPorts }}, like map[80/tcp:{}]
+	exposedPortRegexp = regexp.MustCompile(`[0-9]+(-[0-9]+)?/(tcp|udp)`)
+)
+
 const (
 	PidDirectory = "/tmp/swapper-pid"
 	YamlDirectory = "/tmp/swapper-yaml"
+	// FilesDirectory is a tmpfs, the files of containers never touch the disk of the node
+	FilesDirectory = "/dev/shm/swapper-files"
+	// ProxyImage runs haproxy for tcp frontends, and nginx for udp ones
+	ProxyImage = "gcr.io/docker-swapper/swapper-proxy:1.2.0"
+	// ProxyLogDirectory is where swapper-proxy writes its access logs, the log directory of the node is mounted there
+	ProxyLogDirectory = "/var/log/swapper-proxy"
+	// ProxyPortsLabel is the label of swapper-proxy listing the ports it publishes, to know when to recreate it
+	ProxyPortsLabel = "swapper.ports"
 )
 
 func CreateHaproxyConf(yamlConf yaml.YamlConf) (conf string, err error) {
+	return CreateDrainingHaproxyConf(yamlConf, yaml.YamlConf{})
+}
+
+func frontendMaxConn(frontend yaml.Frontend) int {
+	if frontend.Proxy.MaxConn == 0 {
+		return yaml.DefaultMaxConn
+	}
+	return frontend.Proxy.MaxConn
+}
+
+// haproxyMaxConn returns the global maxconn of haproxy, the sum of the maxconn of the tcp frontends, so that the
+// global limit never cuts a frontend below its own
+func haproxyMaxConn(frontends []yaml.Frontend) (maxConn int) {
+	for _, frontend := range frontends {
+		if frontend.Protocol == yaml.ProtocolTcp {
+			maxConn += frontendMaxConn(frontend)
+		}
+	}
+	if maxConn < yaml.DefaultMaxConn {
+		maxConn = yaml.DefaultMaxConn
+	}
+	return maxConn
+}
+
+// CreateDrainingHaproxyConf creates the haproxy conf of a swap: the containers of the sticky services of the previous
+// configuration are kept with a weight of 0, they only serve their sticky clients until they are drained.
+func CreateDrainingHaproxyConf(yamlConf yaml.YamlConf, previousYamlConf yaml.YamlConf) (conf string, err error) {
 
 	var haproxyConf []string
-	// create frontend haproxy conf
-	haproxyConf = append(haproxyConf, haproxyBaseConf)
+	// create frontend haproxy conf, udp frontends are served by nginx
+	haproxyConf = append(haproxyConf, fmt.Sprintf(haproxyBaseConf, haproxyMaxConn(yamlConf.Frontends)))
+	if target := haproxyLogTarget(yamlConf.ProxyLogs); target != "" {
+		// a second global section adds the log target to the base conf
+		haproxyConf = append(haproxyConf, "global\n    log "+target+"\n")
+	}
+	for _, frontend := range yamlConf.Frontends {
+		if frontend.Protocol == yaml.ProtocolTcp && frontend.Proxy.Sticky.Type == "source" {
+			haproxyConf = append(haproxyConf, haproxyPeersConf)
+			break
+		}
+	}
+	if stats := yamlConf.ProxyStats; stats.Port != 0 {
+		address := stats.Address
+		if address == "" {
+			address = "0.0.0.0"
+		}
+		haproxyConf = append(haproxyConf, "listen stats")
+		haproxyConf = append(haproxyConf, "    mode http")
+		haproxyConf = append(haproxyConf, "    bind "+address+":"+strconv.Itoa(stats.Port))
+		haproxyConf = append(haproxyConf, "    stats enable")
+		haproxyConf = append(haproxyConf, "    stats uri /")
+		haproxyConf = append(haproxyConf, "    stats refresh 10s")
+		haproxyConf = append(haproxyConf, "    stats auth "+stats.Username+":"+stats.Password)
+		haproxyConf = append(haproxyConf, "")
+	}
 	for _, frontend := range yamlConf.Frontends  {
+		if frontend.Protocol != yaml.ProtocolTcp {
+			continue
+		}
+		address := frontend.Address
+		if address == "" {
+			address = "0.0.0.0"
+		}
+		// uri balancing and sticky cookies read the http requests
+		mode := "tcp"
+		if frontend.Proxy.HttpMode() {
+			mode = "http"
+		}
 		haproxyConf = append(haproxyConf, "frontend "+frontend.Name)
 		haproxyConf = append(haproxyConf, "    option forwardfor")
-		haproxyConf = append(haproxyConf, "    mode tcp")
-		haproxyConf = append(haproxyConf, "    option tcplog")
-		haproxyConf = append(haproxyConf, "    maxconn 800")
-		haproxyConf = append(haproxyConf, "    bind 0.0.0.0:"+strconv.Itoa(frontend.Listen))
+		haproxyConf = append(haproxyConf, "    mode "+mode)
+		haproxyConf = append(haproxyConf, "    option "+mode+"log")
+		haproxyConf = append(haproxyConf, "    maxconn "+strconv.Itoa(frontendMaxConn(frontend)))
+		if frontend.Proxy.ClientTimeout != "" {
+			haproxyConf = append(haproxyConf, "    timeout client "+yaml.Milliseconds(frontend.Proxy.ClientTimeout))
+		}
+		haproxyConf = append(haproxyConf, sourcesConf(frontend.Proxy.Allow, frontend.Proxy.Deny)...)
+		haproxyConf = append(haproxyConf, rateLimitConf(frontend.Proxy.RateLimit)...)
+		haproxyConf = append(haproxyConf, "    bind "+address+":"+frontend.Range())
 		haproxyConf = append(haproxyConf, "    default_backend "+frontend.BackendName)
 		haproxyConf = append(haproxyConf, "")
 	}
 
 
 	for _, frontend := range yamlConf.Frontends {
+		if frontend.Protocol != yaml.ProtocolTcp {
+			continue
+		}
+		balance := frontend.Proxy.Balance
+		if balance == "" {
+			balance = yaml.DefaultBalance
+		}
 		haproxyConf = append(haproxyConf, "backend "+frontend.BackendName)
-		haproxyConf = append(haproxyConf, "    balance roundrobin")
+		if frontend.Proxy.HttpMode() {
+			haproxyConf = append(haproxyConf, "    mode http")
+		}
+		haproxyConf = append(haproxyConf, "    balance "+balance)
+		if frontend.Proxy.ConnectTimeout != "" {
+			haproxyConf = append(haproxyConf, "    timeout connect "+yaml.Milliseconds(frontend.Proxy.ConnectTimeout))
+		}
+		if frontend.Proxy.ServerTimeout != "" {
+			haproxyConf = append(haproxyConf, "    timeout server "+yaml.Milliseconds(frontend.Proxy.ServerTimeout))
+		}
+		haproxyConf = append(haproxyConf, httpCheckConf(frontend.Proxy.Health)...)
+		haproxyConf = append(haproxyConf, stickyConf(frontend.Proxy.Sticky)...)
 
-		for _, container := range frontend.Containers {
-			containerName := "swapper-container." + yamlConf.Hash + "." + frontend.ServiceName + "." + strconv.Itoa(container.Index)
-			Id, err := utils.Command("docker ps --format {{.ID}} --filter name=" + containerName)
-			if err != nil || Id == "" {
-				return conf, errors.New(fmt.Sprintf(response.ErrorMessages["container_failed"], containerName))
-			}
+		var serverOptions string
+		if frontend.Proxy.CheckInterval != "" {
+			serverOptions += " inter "+yaml.Milliseconds(frontend.Proxy.CheckInterval)
+		}
+		if frontend.Proxy.Health.Interval != "" {
+			serverOptions += " inter "+yaml.Milliseconds(frontend.Proxy.Health.Interval)
+		}
+		if frontend.Proxy.CheckRise != 0 {
+			serverOptions += " rise "+strconv.Itoa(frontend.Proxy.CheckRise)
+		}
+		if frontend.Proxy.CheckFall != 0 {
+			serverOptions += " fall "+strconv.Itoa(frontend.Proxy.CheckFall)
+		}
+		if frontend.Proxy.SendProxy {
+			serverOptions += " send-proxy"
+		}
 
-			ipCommand := "docker inspect -f {{.NetworkSettings.IPAddress}} " + containerName
-			outIp, err := utils.Command(ipCommand)
+		for _, container := range frontend.Containers {
+			ip, err := containerIp(yamlConf.Hash, frontend.ServiceName, container.Index)
 			if err != nil {
-				return conf, errors.New(fmt.Sprintf(response.ErrorMessages["container_ip_failed"], containerName))
+				return conf, err
+			}
+			options := serverOptions
+			if frontend.Proxy.Sticky.Type == "cookie" {
+				options += " cookie "+stickyCookie(yamlConf.Hash, container.Index)
 			}
-			ip := strings.TrimSpace(outIp)
+			haproxyConf = append(haproxyConf, haproxyServer(frontend, "container_"+strconv.Itoa(container.Index), ip, options, container.Weight))
+		}
 
-			haproxyConf = append(haproxyConf, "    server container_"+strconv.Itoa(container.Index)+" "+ip+":"+strconv.Itoa(frontend.Bind)+" check observe layer4 weight "+strconv.Itoa(container.Weight))
+		// the old containers are already stopped when nothing needs to be drained
+		if frontend.Proxy.DrainDuration() == 0 || previousYamlConf.Hash == "" || previousYamlConf.Hash == yamlConf.Hash {
+			continue
+		}
+		for _, previousFrontend := range previousYamlConf.Frontends {
+			if previousFrontend.BackendName != frontend.BackendName || previousFrontend.ServiceName != frontend.ServiceName {
+				continue
+			}
+			for _, container := range previousFrontend.Containers {
+				ip, err := containerIp(previousYamlConf.Hash, frontend.ServiceName, container.Index)
+				if err != nil {
+					continue
+				}
+				options := serverOptions
+				if frontend.Proxy.Sticky.Type == "cookie" {
+					options += " cookie "+stickyCookie(previousYamlConf.Hash, container.Index)
+				}
+				haproxyConf = append(haproxyConf, haproxyServer(frontend, "drain_"+strconv.Itoa(container.Index), ip, options, 0))
+			}
 		}
 	}
 
@@ -100,7 +259,285 @@ func CreateHaproxyConf(yamlConf yaml.YamlConf) (conf string, err error) {
 	return strings.Join(haproxyConf, "\n"), err
 }
 
-func getYamlConfFromMasters(filename string, masters []string) (yamlConf yaml.YamlConf, err error) {
+// haproxyLogTarget returns the target of the access logs of haproxy, empty when they are disabled. Haproxy runs in
+// the foreground of swapper-proxy, its stdout goes to docker logs or to the log directory of the node.
+func haproxyLogTarget(logs yaml.ProxyLogs) string {
+	facility := logs.Facility
+	if facility == "" {
+		facility = yaml.DefaultLogFacility
+	}
+	switch logs.Destination {
+	case "stdout", "file":
+		return "stdout format raw "+facility
+	case "syslog":
+		return logs.Address+" "+facility
+	}
+	return ""
+}
+
+// udpAccessLog returns the access_log directive of nginx, empty when the access logs are disabled
+func udpAccessLog(logs yaml.ProxyLogs) string {
+	facility := logs.Facility
+	if facility == "" {
+		facility = yaml.DefaultLogFacility
+	}
+	switch logs.Destination {
+	case "stdout":
+		return "    access_log /proc/1/fd/1 udp;"
+	case "file":
+		return "    access_log "+ProxyLogDirectory+"/udp.log udp;"
+	case "syslog":
+		return "    access_log syslog:server="+logs.Address+",facility="+facility+",tag=swapper_proxy udp;"
+	}
+	return ""
+}
+
+// haproxyServer returns the server line of a container, a weight of 0 only serves the sticky clients of the container
+func haproxyServer(frontend yaml.Frontend, name string, ip string, options string, weight int) string {
+	// a range keeps the port of connections (offset to the container ports), and is checked on its first port
+	server := ip+":"+strconv.Itoa(frontend.Bind)+" check"
+	if offset := frontend.Bind-frontend.Listen; frontend.ListenEnd != frontend.Listen && offset == 0 {
+		server = ip+" check port "+strconv.Itoa(frontend.Bind)
+	} else if frontend.ListenEnd != frontend.Listen {
+		server = ip+fmt.Sprintf(":%+d", offset)+" check port "+strconv.Itoa(frontend.Bind)
+	}
+	return "    server "+name+" "+server+options+" observe layer4 weight "+strconv.Itoa(weight)
+}
+
+// stickyConf returns the backend lines of the sticky sessions of a service. Clients of a stopped container are
+// redispatched to another one.
+func stickyConf(sticky yaml.Sticky) []string {
+	switch sticky.Type {
+	case "source":
+		expire := sticky.Expire
+		if expire == "" {
+			expire = yaml.DefaultStickyExpire
+		}
+		// the peers of the stick table pass it to the new haproxy process on reloads
+		return []string{"    stick-table type ip size 200k expire "+yaml.Milliseconds(expire)+" peers swapper", "    stick on src", "    option redispatch"}
+	case "cookie":
+		cookie := sticky.Cookie
+		if cookie == "" {
+			cookie = yaml.DefaultStickyCookie
+		}
+		return []string{"    cookie "+cookie+" insert indirect nocache", "    option redispatch"}
+	}
+	return nil
+}
+
+// sourcesConf returns the frontend lines rejecting the connections of the clients that are not allowed, or denied
+func sourcesConf(allow []string, deny []string) (conf []string) {
+	if len(allow) != 0 {
+		conf = append(conf, "    acl allowed_source src "+strings.Join(allow, " "), "    tcp-request connection reject if !allowed_source")
+	}
+	if len(deny) != 0 {
+		conf = append(conf, "    acl denied_source src "+strings.Join(deny, " "), "    tcp-request connection reject if denied_source")
+	}
+	return conf
+}
+
+// rateLimitConf returns the frontend lines limiting each client ip, its counters are kept in a stick table of the
+// frontend. Connections beyond the limits are closed, requests are answered 429.
+func rateLimitConf(rateLimit yaml.RateLimit) []string {
+	if rateLimit == (yaml.RateLimit{}) {
+		return nil
+	}
+	period := rateLimit.Period
+	if period == "" {
+		period = yaml.DefaultRateLimitPeriod
+	}
+	period = yaml.Milliseconds(period)
+
+	var counters, rules []string
+	if rateLimit.Concurrent != 0 {
+		counters = append(counters, "conn_cur")
+		rules = append(rules, "    tcp-request connection reject if { sc0_conn_cur gt "+strconv.Itoa(rateLimit.Concurrent)+" }")
+	}
+	if rateLimit.Connections != 0 {
+		counters = append(counters, "conn_rate("+period+")")
+		rules = append(rules, "    tcp-request connection reject if { sc0_conn_rate gt "+strconv.Itoa(rateLimit.Connections)+" }")
+	}
+	if rateLimit.Requests != 0 {
+		counters = append(counters, "http_req_rate("+period+")")
+		rules = append(rules, "    http-request deny deny_status 429 if { sc0_http_req_rate gt "+strconv.Itoa(rateLimit.Requests)+" }")
+	}
+	conf := []string{"    stick-table type ip size 200k expire "+period+" store "+strings.Join(counters, ","), "    tcp-request connection track-sc0 src"}
+	return append(conf, rules...)
+}
+
+// udpSourcesConf returns the server lines of nginx rejecting the clients that are not allowed, or denied. Nginx stops
+// at the first matching rule.
+func udpSourcesConf(allow []string, deny []string) (conf []string) {
+	for _, source := range deny {
+		conf = append(conf, "        deny "+source+";")
+	}
+	for _, source := range allow {
+		conf = append(conf, "        allow "+source+";")
+	}
+	if len(allow) != 0 {
+		conf = append(conf, "        deny all;")
+	}
+	return conf
+}
+
+// stickyCookie returns the cookie value of a container, which differs between deployments so that the clients of
+// drained containers are not sent to the new containers of the same index
+func stickyCookie(hash string, index int) string {
+	if len(hash) > 8 {
+		hash = hash[:8]
+	}
+	if hash == "" {
+		return "container_"+strconv.Itoa(index)
+	}
+	return hash+"_"+strconv.Itoa(index)
+}
+
+// httpCheckConf returns the backend lines of the http health check of a service, none when it only has layer 4 checks
+func httpCheckConf(health yaml.ProxyHealth) []string {
+	if health.Path == "" {
+		return nil
+	}
+	method, expect := health.Method, health.Expect
+	if method == "" {
+		method = yaml.DefaultHealthMethod
+	}
+	if expect == 0 {
+		expect = yaml.DefaultHealthExpect
+	}
+	check := "    option httpchk "+method+" "+health.Path
+	if health.Host != "" {
+		check += ` HTTP/1.1\r\nHost:\ `+health.Host
+	}
+	return []string{check, "    http-check expect status "+strconv.Itoa(expect)}
+}
+
+// CreateUdpProxyConf creates the nginx conf of the udp frontends, empty when there are none. Nginx reloads it
+// gracefully: the sessions in progress finish with the old containers.
+func CreateUdpProxyConf(yamlConf yaml.YamlConf) (conf string, err error) {
+	var upstreams, servers []string
+	for _, frontend := range yamlConf.Frontends {
+		if frontend.Protocol != yaml.ProtocolUdp {
+			continue
+		}
+		address := frontend.Address
+		if address == "" {
+			address = "0.0.0.0"
+		}
+		var ips []string
+		for _, container := range frontend.Containers {
+			ip, err := containerIp(yamlConf.Hash, frontend.ServiceName, container.Index)
+			if err != nil {
+				return conf, err
+			}
+			ips = append(ips, ip)
+		}
+
+		// nginx cannot offset the ports of a range, each port has its upstream
+		for listen := frontend.Listen; listen <= frontend.ListenEnd; listen++ {
+			bind := frontend.Bind + listen - frontend.Listen
+			backendName := frontend.BackendName
+			if frontend.ListenEnd != frontend.Listen {
+				backendName = backendName + "_" + strconv.Itoa(listen)
+			}
+			upstreams = append(upstreams, "    upstream "+backendName+" {")
+			switch {
+			case frontend.Proxy.Balance == "source" || frontend.Proxy.Sticky.Type == "source":
+				upstreams = append(upstreams, "        hash $remote_addr consistent;")
+			case frontend.Proxy.Balance == "leastconn":
+				upstreams = append(upstreams, "        least_conn;")
+			}
+			for i, container := range frontend.Containers {
+				upstreams = append(upstreams, "        server "+ips[i]+":"+strconv.Itoa(bind)+" weight="+strconv.Itoa(container.Weight)+";")
+			}
+			upstreams = append(upstreams, "    }")
+			servers = append(servers, "    server {", "        listen "+address+":"+strconv.Itoa(listen)+" udp;")
+			servers = append(servers, udpSourcesConf(frontend.Proxy.Allow, frontend.Proxy.Deny)...)
+			if frontend.Proxy.ServerTimeout != "" {
+				servers = append(servers, "        proxy_timeout "+yaml.Milliseconds(frontend.Proxy.ServerTimeout)+"ms;")
+			}
+			servers = append(servers, "        proxy_pass "+backendName+";", "    }")
+		}
+	}
+	if len(servers) == 0 {
+		return "", nil
+	}
+	if accessLog := udpAccessLog(yamlConf.ProxyLogs); accessLog != "" {
+		upstreams = append([]string{udpLogFormat, accessLog}, upstreams...)
+	}
+	return udpProxyBaseConf + "\nstream {\n" + strings.Join(append(upstreams, servers...), "\n") + "\n}\n", nil
+}
+
+// containerIp returns the ip of a container of a service in the docker network
+func containerIp(hash string, serviceName string, index int) (ip string, err error) {
+	containerName := "swapper-container." + hash + "." + serviceName + "." + strconv.Itoa(index)
+	Id, err := utils.Command("docker ps --format {{.ID}} --filter name=" + containerName)
+	if err != nil || Id == "" {
+		return ip, errors.New(fmt.Sprintf(response.ErrorMessages["container_failed"], containerName))
+	}
+
+	ipCommand := "docker inspect -f {{.NetworkSettings.IPAddress}} " + containerName
+	outIp, err := utils.Command(ipCommand)
+	if err != nil {
+		return ip, errors.New(fmt.Sprintf(response.ErrorMessages["container_ip_failed"], containerName))
+	}
+	return strings.TrimSpace(outIp), nil
+}
+
+// getVars returns the variables of a command, from the environment (--env-vars), the variables files (--var-file) and --var
+func getVars(arguments docopt.Opts) ([]string, error) {
+	return yaml.LoadVars(utils.InterfaceToArray(arguments["--var"]), utils.InterfaceToArray(arguments["--var-file"]), arguments["--env-vars"] == true)
+}
+
+// encryptSecrets encrypts the secret: values of the yaml with the public key, so that masters never see them in plaintext
+func encryptSecrets(cleanYaml string, publicKeyFile interface{}) (string, error) {
+	var publicKey *rsa.PublicKey
+	return yaml.EncryptSecrets(cleanYaml, func(plaintext string) (string, error) {
+		if publicKeyFile == nil {
+			return "", errors.New(response.ErrorMessages["secret_public_key_needed"])
+		}
+		if publicKey == nil {
+			key, err := utils.ReadPublicKey(publicKeyFile.(string))
+			if err != nil {
+				return "", err
+			}
+			publicKey = key
+		}
+		return utils.EncryptSecret(publicKey, plaintext)
+	})
+}
+
+// MasterAddr replaces localhost by the real hostname, and adds the default port if missing. GCS buckets are returned as is
+func MasterAddr(hostname string) string {
+	if strings.HasPrefix(hostname, "gs://") {
+		return hostname
+	}
+	if hostname == "localhost" || hostname == "127.0.0.1" {
+		hostname, _ = utils.GetHostname()
+	}
+	if strings.Index(hostname, ":") == -1 {
+		hostname = hostname + ":1207"
+	}
+	return hostname
+}
+
+// GetDeployedYaml returns the yaml currently deployed on the master (or GCS bucket) of the yaml configuration
+func GetDeployedYaml(filename string, yamlConf yaml.YamlConf, masterHostname string) (deployedYaml string, err error) {
+	if yamlConf.Master.Driver == "gcp" {
+		if yamlConf.Master.CredentialsFile != "" {
+			_ = os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", yamlConf.Master.CredentialsFile)
+		}
+		return GetYamlFromGCS(filename, "gs://swapper-master-"+yamlConf.Master.ProjectId), nil
+	}
+
+	masterHostname = MasterAddr(masterHostname)
+	conf := GetMastersConf(masterHostname)
+	if len(conf.Yamls) == 0 {
+		return "", errors.New(fmt.Sprintf(response.ErrorMessages["bad_master_addr"], masterHostname))
+	}
+	return GetYaml(filename, masterHostname), nil
+}
+
+func getYamlConfFromMasters(filename string, masters []string) (yamlConf yaml.YamlConf, swapperYaml string, err error) {
 
 	// shuffle masters array
 	rand.Seed(time.Now().UnixNano())
@@ -108,13 +545,13 @@ func getYamlConfFromMasters(filename string, masters []string) (yamlConf yaml.Ya
 
 	// Get yaml file from master(s)
 	for _, master := range masters {
-		swapperYaml := GetYaml(filename, master)
+		swapperYaml = GetYaml(filename, master)
 		if swapperYaml != "" {
 			yamlConf, err := yaml.ParseSwapperYaml(swapperYaml)
-			return yamlConf, err
+			return yamlConf, swapperYaml, err
 		}
 	}
-	return yamlConf, errors.New(response.ErrorMessages["cannot_contact_master"])
+	return yamlConf, swapperYaml, errors.New(response.ErrorMessages["cannot_contact_master"])
 }
 
 func GetYaml(filename string, hostname string) string {
@@ -137,6 +574,25 @@ func GetYaml(filename string, hostname string) string {
 	}
 }
 
+// GetYamlRevision returns a past deployed revision of a yaml file, kept by masters (or in the GCS bucket) by hash
+func GetYamlRevision(filename string, hostname string, hash string) string {
+	if strings.Contains(hostname, "gs://") {
+		return GetYamlFromGCS(filename+"@"+hash, hostname)
+	}
+
+	resp, err := http.Get("http://" + hostname + "/" + filename + "?hash=" + url.QueryEscape(hash))
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.Status != "200 OK" {
+		return ""
+	}
+	return string(body)
+}
+
 func GetYamlFromGCS(filename string, hostname string) string {
 
 	ctx := context.Background()
@@ -196,15 +652,28 @@ func unique(stringSlice []string) []string {
 	return list
 }
 
+// yamlHash is the md5 of a configuration, without what doesn't change it: the deployer, and the random ciphertext of
+// the encrypted values, only their fingerprint is hashed
+func yamlHash(swapperYaml string) string {
+	swapperYaml = deployerRegexp.ReplaceAllString(swapperYaml, "")
+	swapperYaml = yaml.EncryptedRegexp.ReplaceAllStringFunc(swapperYaml, func(value string) string {
+		if fingerprint, _ := yaml.SplitEncrypted(value); fingerprint != "" {
+			return yaml.EncryptedPrefix + fingerprint
+		}
+		return value
+	})
+	hasher := md5.New()
+	hasher.Write([]byte(swapperYaml))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
 func WriteSwapperYaml(fileName string, swapperYaml string, currentPort string, masters []string, forceTime int64) error {
 	newYamlConf, err := yaml.ParseSwapperYaml(swapperYaml)
 	if err != nil {
 		return err
 	}
 
-	hasher := md5.New()
-	hasher.Write([]byte(swapperYaml))
-	newYamlConf.Hash = hex.EncodeToString(hasher.Sum(nil))
+	newYamlConf.Hash = yamlHash(swapperYaml)
 	if forceTime == int64(0) {
 		newYamlConf.Time = time.Now().UnixNano()
 	} else {
@@ -243,7 +712,8 @@ func WriteSwapperYaml(fileName string, swapperYaml string, currentPort string, m
 		return err
 	}
 
-	return nil
+	// keep every revision, to be able to diff past deploys
+	return ioutil.WriteFile(sourceFile+"@"+newYamlConf.Hash, []byte(swapperYaml), 0644)
 }
 
 func AddMaster(masters []string, currentPort string) bool {
@@ -423,6 +893,14 @@ func masterRequestHandler(ctx *fasthttp.RequestCtx) {
 		var valid = regexp.MustCompile(`\.yml$`)
 		if valid.MatchString(string(ctx.Path())) {
 			sourceFile := YamlDirectory+string(ctx.Path())+"_"+masterPort
+			if hash := string(ctx.QueryArgs().Peek("hash")); hash != "" {
+				if hashRegexp.MatchString(hash) == false {
+					ctx.Response.Reset()
+					ctx.SetStatusCode(404)
+					return
+				}
+				sourceFile = sourceFile + "@" + hash
+			}
 			yaml, ioErr := ioutil.ReadFile(sourceFile)
 			if ioErr != nil {
 				ctx.Response.Reset()
@@ -64,7 +64,8 @@ func TestCreateHaproxyConf(t *testing.T) {
 
 	compare1 := `
 global
-    log 127.0.0.1 local5 debug
+    maxconn 3200
+    stats socket /var/run/haproxy.sock mode 600 level user
 
 defaults
     log     global
@@ -122,7 +123,8 @@ backend backend_200_80
 
 	compare2 := `
 global
-    log 127.0.0.1 local5 debug
+    maxconn 3200
+    stats socket /var/run/haproxy.sock mode 600 level user
 
 defaults
     log     global
@@ -202,8 +204,101 @@ backend backend_443_443
 	}
 }
 
+func TestHttpCheckConf(t *testing.T) {
+	if len(httpCheckConf(yaml.ProxyHealth{})) != 0 {
+		t.Fail()
+	}
+	conf := strings.Join(httpCheckConf(yaml.ProxyHealth{Path: "/status"}), "\n")
+	if conf != "    option httpchk GET /status\n    http-check expect status 200" {
+		t.Error(conf)
+	}
+	conf = strings.Join(httpCheckConf(yaml.ProxyHealth{Method: "HEAD", Path: "/", Expect: 204, Host: "api.example.com"}), "\n")
+	if conf != `    option httpchk HEAD / HTTP/1.1\r\nHost:\ api.example.com`+"\n    http-check expect status 204" {
+		t.Error(conf)
+	}
+}
+
+func TestStickyConf(t *testing.T) {
+	if len(stickyConf(yaml.Sticky{})) != 0 {
+		t.Fail()
+	}
+	conf := strings.Join(stickyConf(yaml.Sticky{Type: "source", Expire: "1h"}), "\n")
+	if conf != "    stick-table type ip size 200k expire 3600000 peers swapper\n    stick on src\n    option redispatch" {
+		t.Error(conf)
+	}
+	conf = strings.Join(stickyConf(yaml.Sticky{Type: "cookie"}), "\n")
+	if conf != "    cookie SWAPPERID insert indirect nocache\n    option redispatch" {
+		t.Error(conf)
+	}
+	if stickyCookie("", 1) != "container_1" || stickyCookie("0123456789abcdef", 0) != "01234567_0" {
+		t.Fail()
+	}
+
+	frontend := yaml.Frontend{Port: yaml.Port{Listen: 8000, ListenEnd: 8010, Bind: 9000}}
+	if server := haproxyServer(frontend, "drain_0", "172.17.0.2", " cookie a_0", 0); server != "    server drain_0 172.17.0.2:+1000 check port 9000 cookie a_0 observe layer4 weight 0" {
+		t.Error(server)
+	}
+}
+
+func TestProxyLogs(t *testing.T) {
+	if haproxyLogTarget(yaml.ProxyLogs{}) != "" || udpAccessLog(yaml.ProxyLogs{}) != "" {
+		t.Fail()
+	}
+	logs := yaml.ProxyLogs{Destination: "syslog", Address: "10.0.0.5:514", Facility: "local3"}
+	if haproxyLogTarget(logs) != "10.0.0.5:514 local3" || udpAccessLog(logs) != "    access_log syslog:server=10.0.0.5:514,facility=local3,tag=swapper_proxy udp;" {
+		t.Error(haproxyLogTarget(logs), udpAccessLog(logs))
+	}
+	logs = yaml.ProxyLogs{Destination: "file", Path: "/var/log/swapper"}
+	if haproxyLogTarget(logs) != "stdout format raw local0" || udpAccessLog(logs) != "    access_log /var/log/swapper-proxy/udp.log udp;" {
+		t.Error(haproxyLogTarget(logs), udpAccessLog(logs))
+	}
+}
+
+func TestHaproxyMaxConn(t *testing.T) {
+	yamlConf, err := yaml.ParseSwapperYaml("version: '1'\nservices:\n  api:\n    ports:\n      - 80:80\n      - 443:443\n      - 53:53/udp\n    proxy:\n      maxconn: 5000\n    containers:\n      - image: nginx\n        tag: 1.17.0\n  web:\n    ports:\n      - 8080:80\n    containers:\n      - image: nginx\n        tag: 1.17.0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxConn := haproxyMaxConn(yamlConf.Frontends); maxConn != 2*5000+yaml.DefaultMaxConn {
+		t.Error(maxConn)
+	}
+	if maxConn := haproxyMaxConn(nil); maxConn != yaml.DefaultMaxConn {
+		t.Error(maxConn)
+	}
+}
+
+func TestRateLimitConf(t *testing.T) {
+	if rateLimitConf(yaml.RateLimit{}) != nil || sourcesConf(nil, nil) != nil || udpSourcesConf(nil, nil) != nil {
+		t.Fail()
+	}
+	conf := strings.Join(rateLimitConf(yaml.RateLimit{Connections: 20, Requests: 100, Concurrent: 5}), "\n")
+	expected := `    stick-table type ip size 200k expire 10000 store conn_cur,conn_rate(10000),http_req_rate(10000)
+    tcp-request connection track-sc0 src
+    tcp-request connection reject if { sc0_conn_cur gt 5 }
+    tcp-request connection reject if { sc0_conn_rate gt 20 }
+    http-request deny deny_status 429 if { sc0_http_req_rate gt 100 }`
+	if conf != expected {
+		t.Error(conf)
+	}
+	conf = strings.Join(sourcesConf([]string{"10.0.0.0/8", "192.168.1.4"}, []string{"10.1.2.3"}), "\n")
+	expected = `    acl allowed_source src 10.0.0.0/8 192.168.1.4
+    tcp-request connection reject if !allowed_source
+    acl denied_source src 10.1.2.3
+    tcp-request connection reject if denied_source`
+	if conf != expected {
+		t.Error(conf)
+	}
+	conf = strings.Join(udpSourcesConf([]string{"10.0.0.0/8"}, []string{"10.1.2.3"}), "\n")
+	expected = `        deny 10.1.2.3;
+        allow 10.0.0.0/8;
+        deny all;`
+	if conf != expected {
+		t.Error(conf)
+	}
+}
+
 func TestWriteSwapperYaml(t *testing.T) {
-	err := WriteSwapperYaml("default.yml","jklfd fdsf: fds", "1207", []string{"ok", "c", "a", "c"}, 0)
+	err := WriteSwapperYaml("default.yml", "jklfd fdsf: fds", "1207", []string{"ok", "c", "a", "c"}, 0)
 	if err != nil && err.Error() != response.ErrorMessages["yaml_version"] {
 		t.Fail()
 	}
@@ -212,6 +307,12 @@ func TestWriteSwapperYaml(t *testing.T) {
 	if err != nil {
 		t.Fail()
 	}
+
+	swapperYaml, _ := ioutil.ReadFile(YamlDirectory + "/default.yml_1207")
+	yamlConf, _ := yaml.ParseSwapperYaml(string(swapperYaml))
+	if _, err := os.Stat(YamlDirectory + "/default.yml_1207@" + yamlConf.Hash); err != nil {
+		t.Fail()
+	}
 }
 
 func TestGetQuorum(t *testing.T) {
@@ -254,7 +355,7 @@ func TestGetQuorum(t *testing.T) {
 func TestRefreshMaster(t *testing.T) {
 
 	port := "1111"
-	sourceFile := YamlDirectory+"/default.yml_"+port
+	sourceFile := YamlDirectory + "/default.yml_" + port
 	swapperYaml := `
 version: "1"
 
@@ -284,7 +385,7 @@ masters:
 
 func TestPingMasters(t *testing.T) {
 	port := "1111"
-	sourceFile := YamlDirectory+"/default.yml_"+port
+	sourceFile := YamlDirectory + "/default.yml_" + port
 	swapperYaml := `
 version: "1"
 
@@ -314,7 +415,7 @@ masters:
 
 func TestAddMaster(t *testing.T) {
 	port := "1111"
-	sourceFile := YamlDirectory+"/default.yml_"+port
+	sourceFile := YamlDirectory + "/default.yml_" + port
 	swapperYaml := `
 version: "1"
 
@@ -0,0 +1,54 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/yaml"
+	"io/ioutil"
+	"strings"
+
+	"github.com/docopt/docopt-go"
+)
+
+var (
+	convertUsage = `
+swapper convert [OPTIONS].
+
+Convert a docker-compose file into a swapper yaml file. What has no swapper equivalent is listed in comments, at the top of the converted file.
+
+Usage:
+ swapper convert <compose-file>
+ swapper convert (-h|--help)
+
+Options:
+ -h --help                 Show this screen.
+
+Examples:
+ $ swapper convert docker-compose.yml > myapp.yml
+`
+)
+
+func ConvertArgs(argv []string) docopt.Opts {
+	arguments, _ := docopt.ParseArgs(convertUsage, argv, "")
+	return arguments
+}
+
+func Convert(argv []string) response.Response {
+	arguments := ConvertArgs(argv)
+	file := arguments["<compose-file>"].(string)
+
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		return response.Fail(fmt.Sprintf(response.ErrorMessages["file_not_exist"], file))
+	}
+	output, unsupported, err := yaml.ConvertCompose(string(input))
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+
+	header := "# Converted from " + file + " by swapper convert\n"
+	if len(unsupported) > 0 {
+		header = header + "# Not converted, or converted differently:\n#  - " + strings.Join(unsupported, "\n#  - ") + "\n"
+	}
+	return response.Success(header + "\n" + strings.TrimSuffix(output, "\n"))
+}
@@ -0,0 +1,23 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"strings"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	resp := Convert([]string{"convert", "../yaml/tests/compose/docker-compose.yml"})
+	if resp.Code != 0 || strings.HasPrefix(resp.Message, "# Converted from ../yaml/tests/compose/docker-compose.yml by swapper convert\n") == false {
+		t.Error(resp.Message)
+	}
+	if strings.Contains(resp.Message, "#  - services.web.depends_on\n") == false || strings.Contains(resp.Message, "\nversion: \"1\"\n") == false {
+		t.Error(resp.Message)
+	}
+
+	resp = Convert([]string{"convert", "unknown.yml"})
+	if resp.Code != 1 || resp.Message != fmt.Sprintf(response.ErrorMessages["file_not_exist"], "unknown.yml") {
+		t.Fail()
+	}
+}
@@ -3,8 +3,6 @@ package commands
 import (
 	"bytes"
 	"context"
-	"crypto/md5"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/sachamorard/swapper/response"
@@ -29,13 +27,16 @@ swapper deploy [OPTIONS].
 Deploy new swapper configuration and start swapping containers.
 
 Usage:
- swapper deploy [-f <file>] [--var <variable>...] [--master <hostname>]
+ swapper deploy [-f <file>] [--var <variable>...] [--var-file <vars>...] [--env-vars] [--public-key <file>] [--master <hostname>]
  swapper deploy (-h|--help)
 
 Options:
  -h --help                 Show this screen.
  -f NAME --file=NAME       Swapper yml config file [default: default.yml]
  --var VAR=VALUE           To inject variable into yaml file
+ --var-file=FILE           Read variables from a .env or .yml file
+ --env-vars                Read variables from the environment
+ --public-key=FILE         Public key encrypting the secret: values
  --master=HOSTNAME         Master's hostname [default: {{hostname}}]
 
 Examples:
@@ -44,6 +45,9 @@ Examples:
 
  To deploy new dynamic swapper configuration and start swapping containers, create a new version of your yaml file with variables in it, then:
  $ swapper deploy --file my.yml --var ENV=prod --var TAG=1.0.2
+
+ To encrypt the secret: values of your yaml file, with the public key of your nodes:
+ $ swapper deploy --file my.yml --public-key swapper.pub
 `
 )
 
@@ -57,13 +61,20 @@ func DeployArgs(argv []string) docopt.Opts {
 
 func Deploy(argv []string) response.Response {
 	arguments := DeployArgs(argv)
-	vars := utils.InterfaceToArray(arguments["--var"])
+	vars, err := getVars(arguments)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
 	file := arguments["--file"].(string)
 	masterHostname := arguments["--master"].(string)
 	cleanYaml, err := yaml.PrepareSwapperYaml(file, vars)
 	if err != nil {
 		return response.Fail(err.Error())
 	}
+	cleanYaml, err = encryptSecrets(cleanYaml, arguments["--public-key"])
+	if err != nil {
+		return response.Fail(err.Error())
+	}
 
 	yamlConf, err := yaml.ParseSwapperYaml(cleanYaml)
 	if err != nil {
@@ -81,6 +92,12 @@ func Deploy(argv []string) response.Response {
 		return response.Fail(response.ErrorMessages["no_hash_field"])
 	}
 
+	if yamlConf.Deployer != "" {
+		return response.Fail(response.ErrorMessages["no_deployer_field"])
+	}
+	yamlConf.Deployer = utils.GetDeployer()
+	deployerLine := "deployer: " + yamlConf.Deployer + "\n"
+
 	fileInfo, _ := os.Stat(file)
 	var valid = regexp.MustCompile(`\.yml$`)
 	if valid.MatchString(fileInfo.Name()) == false {
@@ -88,17 +105,7 @@ func Deploy(argv []string) response.Response {
 	}
 
 	if yamlConf.Master.Driver == "local" {
-		if masterHostname == "localhost" {
-			masterHostname, _ = utils.GetHostname()
-		}
-		if masterHostname == "127.0.0.1" {
-			masterHostname, _ = utils.GetHostname()
-		}
-
-		i := strings.Index(masterHostname, ":")
-		if i == -1 {
-			masterHostname = masterHostname + ":1207"
-		}
+		masterHostname = MasterAddr(masterHostname)
 
 		conf := GetMastersConf(masterHostname)
 		if len(conf.Yamls) == 0 {
@@ -108,13 +115,12 @@ func Deploy(argv []string) response.Response {
 		masterSplit := strings.Split(masterHostname, ":")
 		port := masterSplit[1]
 
-		return DeployFile(fileInfo.Name(), cleanYaml, port, yamlConf)
+		return DeployFile(fileInfo.Name(), cleanYaml+deployerLine, port, yamlConf)
 	}
 
-	hasher := md5.New()
-	hasher.Write([]byte(cleanYaml))
-	hash := hex.EncodeToString(hasher.Sum(nil))
-	cleanYaml = cleanYaml+"hash: "+hash
+	hash := yamlHash(cleanYaml)
+	cleanYaml = cleanYaml+deployerLine+"hash: "+hash
+	yamlConf.Hash = hash
 
 	if yamlConf.Master.Driver == "gcp" {
 		ctx := context.Background()
@@ -139,7 +145,7 @@ func Deploy(argv []string) response.Response {
 			// Creates the new bucket.
 			if err := bucket.Create(ctx, yamlConf.Master.ProjectId, nil); err != nil {
 				msg := fmt.Sprintf("Deployment failed\nFailed to create bucket: %v", err)
-				_ = utils.SlackSendError(msg, yamlConf)
+				_ = utils.Notify(utils.Event{Name: utils.EventDeploy, File: fileInfo.Name(), Message: msg}, yamlConf)
 				return response.Fail(msg)
 			}
 			fmt.Printf("Bucket %v created.\n", bucketName)
@@ -147,11 +153,16 @@ func Deploy(argv []string) response.Response {
 
 		if err := gcsWrite(cleanYaml, client, bucketName, fileInfo.Name()); err != nil {
 			msg := fmt.Sprintf("Deployment failed\nCannot write object: %v", err)
-			_ = utils.SlackSendError(msg, yamlConf)
+			_ = utils.Notify(utils.Event{Name: utils.EventDeploy, File: fileInfo.Name(), Message: msg}, yamlConf)
 			return response.Fail(msg)
 		}
 
-		_ = utils.SlackSendSuccess("Deployment succeed", yamlConf)
+		// keep every revision, to be able to diff past deploys
+		if err := gcsWrite(cleanYaml, client, bucketName, fileInfo.Name()+"@"+hash); err != nil {
+			fmt.Printf("Cannot write revision %s: %v\n", hash, err)
+		}
+
+		_ = utils.Notify(utils.Event{Name: utils.EventDeploy, File: fileInfo.Name(), Success: true, Message: fileInfo.Name()+" deployment succeed"}, yamlConf)
 		nodeInstruction := "To start a node, execute:\nswapper node start --join gs://"+bucketName+" --apply "+fileInfo.Name()
 		return response.Success("\n>> Deployment succeed\n"+nodeInstruction+"\n")
 	}
@@ -162,10 +173,10 @@ func Deploy(argv []string) response.Response {
 func DeployFile(filename string, cleanYaml string, port string, yamlConf yaml.YamlConf) response.Response {
 	err := DeployReq(filename, cleanYaml, port)
 	if err != nil {
-		_ = utils.SlackSendError("Deployment failed\n"+err.Error(), yamlConf)
+		_ = utils.Notify(utils.Event{Name: utils.EventDeploy, File: filename, Message: "Deployment failed\n"+err.Error()}, yamlConf)
 		return response.Fail(err.Error())
 	}
-	_ = utils.SlackSendSuccess(filename+" deployment succeed", yamlConf)
+	_ = utils.Notify(utils.Event{Name: utils.EventDeploy, File: filename, Success: true, Message: filename+" deployment succeed"}, yamlConf)
 	return response.Success("\n>> "+filename+" deployment succeed\n")
 }
 
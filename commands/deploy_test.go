@@ -100,9 +100,12 @@ func TestDeployArgs(t *testing.T) {
 	hostname, _ := utils.GetHostname()
 	args := docopt.Opts{
 		"--var":  []string{},
+		"--var-file": []string{},
+		"--env-vars": false,
 		"--file": "default.yml",
 		"--help": false,
 		"--master": hostname,
+		"--public-key": nil,
 		"deploy": true,
 	}
 	eq := reflect.DeepEqual(arguments, args)
@@ -114,9 +117,12 @@ func TestDeployArgs(t *testing.T) {
 	arguments = DeployArgs(argv)
 	args = docopt.Opts{
 		"--var":  []string{},
+		"--var-file": []string{},
+		"--env-vars": false,
 		"--file": "ok.yml",
 		"--help": false,
 		"--master": hostname,
+		"--public-key": nil,
 		"deploy": true,
 	}
 	eq = reflect.DeepEqual(arguments, args)
@@ -128,9 +134,12 @@ func TestDeployArgs(t *testing.T) {
 	arguments = DeployArgs(argv)
 	args = docopt.Opts{
 		"--var":  []string{},
+		"--var-file": []string{},
+		"--env-vars": false,
 		"--file": "ok.yml",
 		"--help": false,
 		"--master": hostname,
+		"--public-key": nil,
 		"deploy": true,
 	}
 	eq = reflect.DeepEqual(arguments, args)
@@ -142,9 +151,12 @@ func TestDeployArgs(t *testing.T) {
 	arguments = DeployArgs(argv)
 	args = docopt.Opts{
 		"--var":  []string{"ENV=prod", "KEY=mykey"},
+		"--var-file": []string{},
+		"--env-vars": false,
 		"--file": "ok.yml",
 		"--help": false,
 		"--master": hostname,
+		"--public-key": nil,
 		"deploy": true,
 	}
 	eq = reflect.DeepEqual(arguments, args)
@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/utils"
+	"github.com/sachamorard/swapper/yaml"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/docopt/docopt-go"
+)
+
+const (
+	RevisionLatest = "latest"
+	RevisionNode   = "node"
+)
+
+var (
+	diffUsage = `
+swapper diff <file> [<revision-a>] [<revision-b>] [OPTIONS].
+
+Show the differences between two revisions of a yaml configuration file, service by service.
+A revision can be:
+ latest      the configuration deployed on the master
+ node        the configuration applied by the node running on this machine
+ <hash>      a past deploy, kept by the master
+ <path>      a local yaml file
+
+Without revisions, compare what this node runs with the master.
+
+Usage:
+ swapper diff <file> [<revision-a>] [<revision-b>] [--var <variable>...] [--var-file <vars>...] [--env-vars] [--master <hostname>]
+ swapper diff (-h|--help)
+
+Options:
+ -h --help                 Show this screen.
+ --var VAR=VALUE           To inject variable into local yaml files
+ --var-file=FILE           Read variables from a .env or .yml file
+ --env-vars                Read variables from the environment
+ --master=HOSTNAME         Master's hostname, or gs://<bucket> [default: {{hostname}}]
+
+Examples:
+ $ swapper diff myapp.yml
+ $ swapper diff myapp.yml 3c5d1bbf47b3bd5e2d1e6b6d1a6e4fc8 latest
+ $ swapper diff myapp.yml latest myapp.yml --var TAG=1.0.2 --master gs://swapper-master-my-project
+`
+)
+
+func DiffArgs(argv []string) docopt.Opts {
+	hostname, _ := utils.GetHostname()
+	diffUsage = strings.Replace(diffUsage, "{{hostname}}", hostname, -1)
+
+	arguments, _ := docopt.ParseArgs(diffUsage, argv, "")
+	return arguments
+}
+
+func Diff(argv []string) response.Response {
+	arguments := DiffArgs(argv)
+	vars, err := getVars(arguments)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	filename := arguments["<file>"].(string)
+	masterHostname := MasterAddr(arguments["--master"].(string))
+
+	revisionA := RevisionNode
+	if arguments["<revision-a>"] != nil {
+		revisionA = arguments["<revision-a>"].(string)
+	}
+	revisionB := RevisionLatest
+	if arguments["<revision-b>"] != nil {
+		revisionB = arguments["<revision-b>"].(string)
+	}
+
+	oldYamlConf, err := getRevision(filename, revisionA, masterHostname, vars)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	newYamlConf, err := getRevision(filename, revisionB, masterHostname, vars)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+
+	title := "Diff of " + filename + " from " + revisionName(revisionA, oldYamlConf) + " to " + revisionName(revisionB, newYamlConf)
+	changes := yaml.Diff(oldYamlConf, newYamlConf)
+	if len(changes) == 0 {
+		return response.Success("\n" + title + "\n\nNo differences.\n")
+	}
+	return response.Success("\n" + title + "\n\n" + FormatChanges(changes) + "\n")
+}
+
+// getRevision returns the yaml configuration of a revision: latest, node, a hash, or a local file
+func getRevision(filename string, revision string, masterHostname string, vars []string) (yamlConf yaml.YamlConf, err error) {
+	var swapperYaml string
+	switch revision {
+	case RevisionLatest:
+		swapperYaml = GetYaml(filename, masterHostname)
+	case RevisionNode:
+		content, ioErr := ioutil.ReadFile(YamlDirectory + "/node_" + filename)
+		if ioErr == nil {
+			swapperYaml = string(content)
+		}
+	default:
+		if _, statErr := os.Stat(revision); statErr == nil {
+			swapperYaml, err = yaml.PrepareSwapperYaml(revision, vars)
+			if err != nil {
+				return yamlConf, err
+			}
+		} else if hashRegexp.MatchString(revision) {
+			swapperYaml = GetYamlRevision(filename, masterHostname, revision)
+		}
+	}
+
+	if swapperYaml == "" {
+		return yamlConf, errors.New(fmt.Sprintf(response.ErrorMessages["revision_not_found"], revision, filename))
+	}
+	return yaml.ParseSwapperYaml(swapperYaml)
+}
+
+func revisionName(revision string, yamlConf yaml.YamlConf) string {
+	if yamlConf.Hash == "" || yamlConf.Hash == revision {
+		return revision
+	}
+	return revision + " (" + yamlConf.Hash + ")"
+}
@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"github.com/docopt/docopt-go"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	vars := []string{"--var", "NGINXTAG=1.17.0", "--var", "TAG=1.0.1", "--var", "ENV=prod"}
+	resp := Diff(append([]string{"diff", "myapp.yml", "../yaml/tests/v1/valid.1.yml", "../yaml/tests/v1/valid.1.yml"}, vars...))
+	if resp.Code != 0 || strings.Contains(resp.Message, "No differences.") == false {
+		t.Fail()
+	}
+
+	resp = Diff(append([]string{"diff", "myapp.yml", "../yaml/tests/v1/valid.1.yml", "../yaml/tests/v1/valid.2.yml"}, vars...))
+	if resp.Code != 0 || strings.Contains(resp.Message, "- service nginx (ports: 80:80, 443:443)") == false || strings.Contains(resp.Message, "+ service my-app (ports: 80:80)") == false {
+		t.Fail()
+	}
+
+	resp = Diff([]string{"diff", "unknown.yml", "node", "../yaml/tests/v1/valid.2.yml"})
+	if resp.Code != 1 || strings.Contains(resp.Message, `Revision "node" of unknown.yml not found`) == false {
+		t.Fail()
+	}
+}
+
+func TestDiffArgs(t *testing.T) {
+	argv := []string{"diff", "myapp.yml", "3c5d1bbf", "latest", "--master", "gs://swapper-master-my-project"}
+	arguments := DiffArgs(argv)
+	args := docopt.Opts{
+		"<file>":       "myapp.yml",
+		"<revision-a>": "3c5d1bbf",
+		"<revision-b>": "latest",
+		"--var":        []string{},
+		"--var-file":   []string{},
+		"--env-vars":   false,
+		"--master":     "gs://swapper-master-my-project",
+		"--help":       false,
+		"diff":         true,
+	}
+	eq := reflect.DeepEqual(arguments, args)
+	if !eq {
+		t.Errorf("%v", arguments)
+	}
+}
@@ -0,0 +1,158 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/utils"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	AuditStatusOk      = "ok"
+	AuditStatusDenied  = "denied"
+	AuditStatusFailed  = "failed"
+	AuditStatusTimeout = "timeout"
+)
+
+// CommandPolicy decides how a node evaluates the $() expressions of the yaml configurations it applies.
+// Built-in facts are always allowed, commands must match one of the Allowed patterns with the same number of arguments (* matches anything inside an
+// argument) and run
+// without shell
+type CommandPolicy struct {
+	Allowed  []string
+	Timeout  time.Duration
+	AuditLog string
+	Facts    utils.Facts
+}
+
+var (
+	commandPolicy = CommandPolicy{Timeout: 5 * time.Second, AuditLog: "/tmp/swapper-commands.log"}
+
+	builtinRegexp = regexp.MustCompile(`^([a-z_]+)(?::(.+))?$`)
+	// shellRegexp matches the syntax a shell would interpret, like ; | $() or quotes, which an allowed pattern could
+	// otherwise chain to any command
+	shellRegexp = regexp.MustCompile("[;&|<>`$()'\"\\\\\n]")
+)
+
+// NewCommandPolicy parses the comma separated patterns of --allow-commands
+func NewCommandPolicy(allowCommands string, timeout string, auditLog string) (policy CommandPolicy, err error) {
+	policy = CommandPolicy{AuditLog: auditLog, Allowed: utils.AllowList(allowCommands)}
+	policy.Timeout, err = time.ParseDuration(timeout)
+	if err != nil || policy.Timeout <= 0 {
+		return policy, errors.New(fmt.Sprintf(response.ErrorMessages["command_timeout_invalid"], timeout))
+	}
+	return policy, nil
+}
+
+// Eval replaces the $() expressions of the input by their result
+func (p CommandPolicy) Eval(input string) (str string, err error) {
+	str = input
+	for _, expression := range findCommands(input) {
+		command := expression[2 : len(expression)-1]
+		start := time.Now()
+		out, status, err := p.eval(command)
+		p.audit(command, status, time.Since(start))
+		if err != nil {
+			return str, err
+		}
+		str = strings.Replace(str, expression, out, -1)
+	}
+	return str, nil
+}
+
+func (p CommandPolicy) eval(command string) (out string, status string, err error) {
+	if matches := builtinRegexp.FindStringSubmatch(strings.TrimSpace(command)); matches != nil {
+		out, found, err := p.Facts.Resolve(matches[1], matches[2])
+		if found && err != nil {
+			return out, AuditStatusFailed, errors.New(fmt.Sprintf(response.ErrorMessages["command_failed"], command+": "+err.Error()))
+		}
+		if found {
+			return out, AuditStatusOk, nil
+		}
+	}
+
+	if shellRegexp.MatchString(command) {
+		return out, AuditStatusDenied, errors.New(fmt.Sprintf(response.ErrorMessages["command_shell"], command))
+	}
+	fields := strings.Fields(command)
+	if len(fields) == 0 || p.allows(command) == false {
+		return out, AuditStatusDenied, errors.New(fmt.Sprintf(response.ErrorMessages["command_denied"], command))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, fields[0], fields[1:]...).Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return out, AuditStatusTimeout, errors.New(fmt.Sprintf(response.ErrorMessages["command_timeout"], command, p.Timeout))
+	}
+	if err != nil {
+		return out, AuditStatusFailed, errors.New(fmt.Sprintf(response.ErrorMessages["command_failed"], command))
+	}
+	return strings.TrimSpace(string(output)), AuditStatusOk, nil
+}
+
+// allows matches the command with the patterns argument by argument, so that * never matches more arguments
+func (p CommandPolicy) allows(command string) bool {
+	arguments := strings.Fields(command)
+	for _, pattern := range p.Allowed {
+		patternArguments := strings.Fields(pattern)
+		if len(patternArguments) != len(arguments) {
+			continue
+		}
+		matched := true
+		for i, patternArgument := range patternArguments {
+			expr := "^" + strings.Replace(regexp.QuoteMeta(patternArgument), `\*`, ".*", -1) + "$"
+			if ok, _ := regexp.MatchString(expr, arguments[i]); ok == false {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// audit logs what was evaluated, without the results, which can be sensitive
+func (p CommandPolicy) audit(command string, status string, duration time.Duration) {
+	if p.AuditLog == "" {
+		return
+	}
+	f, err := os.OpenFile(p.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	defer f.Close()
+	_, _ = fmt.Fprintf(f, "%s status=%s duration=%s command=%q\n", time.Now().UTC().Format(time.RFC3339), status, duration.Round(time.Millisecond), command)
+}
+
+// findCommands returns the $() expressions of the input, with their nested parentheses
+func findCommands(input string) (expressions []string) {
+	for i := 0; i+1 < len(input); i++ {
+		if input[i] != '$' || input[i+1] != '(' {
+			continue
+		}
+		depth := 0
+		for j := i + 1; j < len(input); j++ {
+			if input[j] == '(' {
+				depth++
+			} else if input[j] == ')' {
+				depth--
+				if depth == 0 {
+					expressions = append(expressions, input[i:j+1])
+					i = j
+					break
+				}
+			}
+		}
+	}
+	return expressions
+}
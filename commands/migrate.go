@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/yaml"
+	"io/ioutil"
+
+	"github.com/docopt/docopt-go"
+)
+
+var (
+	migrateUsage = `
+swapper migrate [OPTIONS].
+
+Rewrite a version 1 yaml file into version 2, keeping its comments and variables.
+
+Usage:
+ swapper migrate [-f <file>] [--output <output>]
+ swapper migrate (-h|--help)
+
+Options:
+ -h --help                 Show this screen.
+ -f NAME --file=NAME       Swapper yml config file [default: default.yml]
+ -o FILE --output=FILE     Where to write the migrated file instead of the file itself, '-' to print it
+
+Examples:
+ $ swapper migrate -f myapp.yml
+ $ swapper migrate -f myapp.yml --output -
+`
+)
+
+func MigrateArgs(argv []string) docopt.Opts {
+	arguments, _ := docopt.ParseArgs(migrateUsage, argv, "")
+	return arguments
+}
+
+func Migrate(argv []string) response.Response {
+	arguments := MigrateArgs(argv)
+	file := arguments["--file"].(string)
+	output := file
+	if arguments["--output"] != nil {
+		output = arguments["--output"].(string)
+	}
+
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		return response.Fail(fmt.Sprintf(response.ErrorMessages["file_not_exist"], file))
+	}
+	migrated, err := yaml.MigrateV1(string(input))
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	if output == "-" {
+		return response.Success(migrated)
+	}
+	if err := ioutil.WriteFile(output, []byte(migrated), 0644); err != nil {
+		return response.Fail(err.Error())
+	}
+	return response.Success("\n>> " + file + " migrated to version 2 in " + output + "\nCheck it with: swapper validate -f " + output + "\n")
+}
@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	resp := Migrate([]string{"migrate", "-f", "../yaml/tests/v1/valid.2.yml", "--output", "-"})
+	if resp.Code != 0 || strings.HasPrefix(resp.Message, "version: '2'\n") == false || strings.Contains(resp.Message, "healthcheck:\n") == false {
+		t.Error(resp.Message)
+	}
+
+	output := os.TempDir() + "/swapper-migrate-test.yml"
+	defer os.Remove(output)
+	resp = Migrate([]string{"migrate", "-f", "../yaml/tests/v1/valid.2.yml", "-o", output})
+	migrated, _ := ioutil.ReadFile(output)
+	if resp.Code != 0 || strings.HasPrefix(string(migrated), "version: '2'\n") == false {
+		t.Error(resp.Message)
+	}
+
+	resp = Migrate([]string{"migrate", "-f", output})
+	if resp.Code != 1 || resp.Message != response.ErrorMessages["yaml_migrated"] {
+		t.Error(resp.Message)
+	}
+
+	resp = Migrate([]string{"migrate", "-f", "unknown.yml"})
+	if resp.Code != 1 || resp.Message != fmt.Sprintf(response.ErrorMessages["file_not_exist"], "unknown.yml") {
+		t.Fail()
+	}
+}
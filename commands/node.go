@@ -9,8 +9,8 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
-	"reflect"
-	"regexp"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -28,6 +28,7 @@ Manage a node
 Commands:
  start     Start a node and join it to the swapper cluster
  stop      Stop a node
+ config    Show the effective configuration applied by the node
 
 Run 'swapper node COMMAND --help' for more information on a command.
 
@@ -38,14 +39,26 @@ swapper node start [OPTIONS].
 Start a swapper node
 
 Usage:
- swapper node start [--join <hostnames>] [--apply <file>] [--detach]
+ swapper node start [--join <hostnames>] [--apply <file>] [--allow-commands <patterns>] [--allow-env <names>] [--allow-files <paths>] [--command-timeout <duration>] [--audit-log <file>] [--label <label>...] [--metadata-url <url>] [--private-key <file>] [--proxy <proxy>] [--detach]
  swapper node start (-h|--help)
 
 Options:
- -h --help                Show this screen.
- --join=HOSTNAMES         Masters' hostnames (separated by comma)
- --apply=FILE             Apply a specific yaml configuration file [default: default.yml]
- -d --detach              Run node in background
+ -h --help                      Show this screen.
+ --join=HOSTNAMES               Masters' hostnames (separated by comma)
+ --apply=FILE                   Apply a specific yaml configuration file [default: default.yml]
+ --allow-commands=PATTERNS      Commands allowed in $() expressions, run without shell (separated by comma, * matches anything inside an argument).
+                                By default, only built-in facts are: $(hostname), $(fqdn), $(host_ip), $(host_ip:eth0),
+                                $(cpu_count), $(memory), $(memory:mb), $(label:KEY), $(metadata:path), $(env:VAR), $(file:path)
+ --allow-env=NAMES              Environment variables readable by $(env:VAR) (separated by comma, * matches anything)
+ --allow-files=PATHS            Files, and directories, readable by $(file:path) and mounted by files[].source (separated by comma)
+ --command-timeout=DURATION     Timeout of each $() command [default: 5s]
+ --audit-log=FILE               Where evaluated $() expressions are logged [default: /tmp/swapper-commands.log]
+ --label KEY=VALUE              Label of the node, available as $(label:KEY)
+ --metadata-url=URL             Cloud instance metadata endpoint, for $(metadata:path) [default: http://169.254.169.254/computeMetadata/v1/]
+ --private-key=FILE             Private key decrypting the encrypted values of the yaml
+ --proxy=PROXY                  container runs haproxy and nginx in swapper-proxy, builtin forwards the tcp ports in the
+                                node process, without proxy image [default: container]
+ -d --detach                    Run node in background
 
 Examples:
  To start a new node, connected with one master, and apply a specific yaml configuration file:
@@ -54,6 +67,18 @@ Examples:
  To start a new node, connected with two masters:
  $ swapper node start --join master-hostname-1,master-hostname-2 --apply my.yml
 
+ To give labels to the node, used in $(label:KEY) expressions:
+ $ swapper node start --join master-hostname-1 --apply my.yml --label zone=europe-west1-b --label role=front
+
+ To allow some shell commands in $() expressions:
+ $ swapper node start --join master-hostname-1 --apply my.yml --allow-commands 'cat /etc/machine-id,curl -s http://169.254.169.254/*'
+
+ To allow $(env:VAR) and $(file:path) expressions to read some variables and files of the node:
+ $ swapper node start --join master-hostname-1 --apply my.yml --allow-env 'APP_*' --allow-files /etc/app
+
+ To run the builtin proxy instead of swapper-proxy:
+ $ swapper node start --join master-hostname-1 --apply my.yml --proxy builtin
+
 `
 	nodeStopUsage = `
 swapper node stop.
@@ -70,6 +95,24 @@ Options:
 Examples:
  $ swapper node stop
 
+`
+	nodeConfigUsage = `
+swapper node config [OPTIONS].
+
+Show the effective yaml configuration applied by the node running on this machine, with its $() expressions evaluated
+
+Usage:
+ swapper node config [--apply <file>] [--raw]
+ swapper node config (-h|--help)
+
+Options:
+ -h --help                Show this screen.
+ --apply=FILE             Yaml configuration file applied by the node [default: default.yml]
+ --raw                    Show the configuration as served by masters, without evaluating $() expressions
+
+Examples:
+ $ swapper node config --apply my.yml
+
 `
 )
 
@@ -89,6 +132,39 @@ func NodeStart(argv []string) response.Response {
 	if arguments["--join"] == nil {
 		return response.Fail(response.ErrorMessages["need_master_addr"])
 	}
+
+	allowCommands := ""
+	if arguments["--allow-commands"] != nil {
+		allowCommands = arguments["--allow-commands"].(string)
+	}
+	policy, err := NewCommandPolicy(allowCommands, arguments["--command-timeout"].(string), arguments["--audit-log"].(string))
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	labels := utils.InterfaceToArray(arguments["--label"])
+	policy.Facts.Labels, err = utils.ParseLabels(labels)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	policy.Facts.MetadataUrl = arguments["--metadata-url"].(string)
+	if arguments["--allow-env"] != nil {
+		policy.Facts.AllowedEnv = utils.AllowList(arguments["--allow-env"].(string))
+	}
+	if arguments["--allow-files"] != nil {
+		policy.Facts.AllowedFiles = utils.AllowList(arguments["--allow-files"].(string))
+	}
+	commandPolicy = policy
+
+	if arguments["--private-key"] != nil {
+		nodePrivateKey, err = utils.ReadPrivateKey(arguments["--private-key"].(string))
+		if err != nil {
+			return response.Fail(err.Error())
+		}
+	}
+	nodeProxy = arguments["--proxy"].(string)
+	if nodeProxy != ProxyContainer && nodeProxy != ProxyBuiltin {
+		return response.Fail(response.ErrorMessages["node_proxy_invalid"])
+	}
 	mastersHostname := strings.Split(arguments["--join"].(string), ",")
 
 	// Check mastersHostname ports
@@ -110,37 +186,64 @@ func NodeStart(argv []string) response.Response {
 
 	// Get yaml configuration file from master(s)
 	filename := arguments["--apply"].(string)
-	yamlConf, err := getYamlConfFromMasters(filename, masters)
+	yamlConf, swapperYaml, err := getYamlConfFromMasters(filename, masters)
 	if err != nil {
 		return response.Fail(err.Error())
 	}
-
-	// run containers
-	err = runContainers(yamlConf)
+	yamlConf, effectiveYaml, err := evalYamlCommands(swapperYaml)
 	if err != nil {
 		return response.Fail(err.Error())
 	}
 
-	// create frontend haproxy conf
-	haproxyConf, err := CreateHaproxyConf(yamlConf)
-	if err != nil {
-		return response.Fail(err.Error())
+	// the builtin proxy refuses the options of swapper-proxy before any container starts
+	if nodeProxy == ProxyBuiltin {
+		if err = checkBuiltinProxy(yamlConf); err != nil {
+			return response.Fail(err.Error())
+		}
+		if err = inheritBuiltinProxy(); err != nil {
+			return response.Fail(err.Error())
+		}
 	}
 
-	// start haproxy
-	err = startProxy(yamlConf)
+	// run containers
+	err = runContainers(yamlConf)
 	if err != nil {
 		return response.Fail(err.Error())
 	}
 
-	// write file into swapper-proxy to automatically start the haproxy
-	cmd := exec.Command("docker", "exec", "swapper-proxy", "bash", "-c", "echo '"+haproxyConf+"' > /app/src/haproxy.tmp.cfg")
-	_, err = cmd.Output()
-	if err != nil {
-		return response.Fail(response.ErrorMessages["proxy_failed"])
+	if nodeProxy == ProxyBuiltin {
+		// listen on the ports of the services in this process
+		err = applyBuiltinProxy(yamlConf)
+		if err != nil {
+			return response.Fail(err.Error())
+		}
+	} else {
+		// create frontend haproxy and udp confs
+		haproxyConf, err := CreateHaproxyConf(yamlConf)
+		if err != nil {
+			return response.Fail(err.Error())
+		}
+		udpConf, err := CreateUdpProxyConf(yamlConf)
+		if err != nil {
+			return response.Fail(err.Error())
+		}
+
+		// start haproxy
+		err = startProxy(yamlConf)
+		if err != nil {
+			return response.Fail(err.Error())
+		}
+
+		// write files into swapper-proxy to start or reload its proxies
+		err = reloadProxy(haproxyConf, udpConf)
+		if err != nil {
+			return response.Fail(response.ErrorMessages["proxy_failed"])
+		}
 	}
 
 	currentHash = yamlConf.Hash
+	appliedYamlConf = yamlConf
+	writeNodeYaml(filename, swapperYaml, effectiveYaml)
 
 	// update regularly
 	fmt.Println("Now, listening changes on "+filename+" configuration file...")
@@ -148,8 +251,28 @@ func NodeStart(argv []string) response.Response {
 		ListenToMasters(filename, yamlConf)
 	} else {
 		joinArg := arguments["--join"]
-		cmd := exec.Command("swapper","node", "start", "--join", joinArg.(string), "--apply", filename)
+		args := []string{"node", "start", "--join", joinArg.(string), "--apply", filename, "--allow-commands", allowCommands, "--command-timeout", arguments["--command-timeout"].(string), "--audit-log", arguments["--audit-log"].(string), "--metadata-url", policy.Facts.MetadataUrl, "--proxy", nodeProxy}
+		for _, label := range labels {
+			args = append(args, "--label", label)
+		}
+		for _, option := range []string{"--allow-env", "--allow-files", "--private-key"} {
+			if arguments[option] != nil {
+				args = append(args, option, arguments[option].(string))
+			}
+		}
+		cmd := exec.Command("swapper", args...)
+		// the background node serves the ports of the builtin proxy with the same sockets
+		files, err := handOverBuiltinProxy(cmd)
+		if err != nil {
+			return response.Fail(err.Error())
+		}
 		_ = cmd.Start()
+		for _, file := range files {
+			_ = file.Close()
+		}
+		if builtinProxy != nil {
+			builtinProxy.Close()
+		}
 	}
 
 	return response.Success("")
@@ -157,6 +280,7 @@ func NodeStart(argv []string) response.Response {
 
 func startProxy(yamlConf yaml.YamlConf) (err error) {
 
+	signature := proxySignature(yamlConf)
 	Id, _ := utils.Command("docker ps --format {{.ID}} --filter name=swapper-proxy")
 	if Id == "" {
 		fmt.Print("Starting swapper-proxy... ")
@@ -164,11 +288,18 @@ func startProxy(yamlConf yaml.YamlConf) (err error) {
 		command = append(command, "docker run --rm")
 		command = append(command, "--name swapper-proxy")
 		command = append(command, "--hostname swapper-proxy")
+		command = append(command, "--label "+ProxyPortsLabel+"="+signature)
 		for _, frontend := range yamlConf.Frontends  {
-			command = append(command, "-p "+strconv.Itoa(frontend.Listen)+":"+strconv.Itoa(frontend.Listen))
+			command = append(command, "-p "+publishedPort(frontend.Port))
+		}
+		if yamlConf.ProxyStats.Port != 0 {
+			command = append(command, "-p "+publishedPort(yamlConf.ProxyStats.Binding()))
+		}
+		if yamlConf.ProxyLogs.Path != "" {
+			command = append(command, "-v "+yamlConf.ProxyLogs.Path+":"+ProxyLogDirectory)
 		}
 		command = append(command, "-d")
-		command = append(command, "gcr.io/docker-swapper/swapper-proxy:1.0.0")
+		command = append(command, ProxyImage)
 
 		commandStr := strings.Join(command, " ")
 		_, err = utils.Command(commandStr)
@@ -181,20 +312,23 @@ func startProxy(yamlConf yaml.YamlConf) (err error) {
 	} else {
 		fmt.Println("swapper-proxy already started")
 
-		// Check if it's necessary to recreate proxy
-		cmd := exec.Command("docker", "inspect", "--format", "{{ .Config.ExposedPorts }}", "swapper-proxy")
+		// Check if it's necessary to recreate proxy, for new ports or a new swapper-proxy image
+		cmd := exec.Command("docker", "inspect", "--format", "{{ .Config.Image }}|{{ index .Config.Labels \""+ProxyPortsLabel+"\" }}|{{ .Config.ExposedPorts }}", "swapper-proxy")
 		out, err := cmd.Output()
 		if err != nil {
 			return errors.New(response.ErrorMessages["proxy_failed"])
 		}
-		restart := false
-		for _, frontend := range yamlConf.Frontends  {
-			if strings.Contains(string(out), strconv.Itoa(frontend.Listen)+"/tcp") == false {
-				restart = true
+		inspect := strings.SplitN(string(out), "|", 2)
+		if len(inspect) < 2 || inspect[0] != ProxyImage {
+			fmt.Println("[CAREFULL] swapper-proxy image changed, recreate swapper-proxy with short interruption!!!")
+			_, err = utils.Command("docker rm -f swapper-proxy")
+			if err != nil {
+				return errors.New(response.ErrorMessages["proxy_stop_failed"])
 			}
+			return startProxy(yamlConf)
 		}
-		if restart == true {
-			fmt.Println("[CAREFULL] Frontend ports changed, recreate swapper-proxy with short interruption!!!")
+		if runningProxySignature(inspect[1]) != signature {
+			fmt.Println("[CAREFULL] Frontend ports or log directory changed, recreate swapper-proxy with short interruption!!!")
 			_, err = utils.Command("docker rm -f swapper-proxy")
 			if err != nil {
 				return errors.New(response.ErrorMessages["proxy_stop_failed"])
@@ -205,6 +339,148 @@ func startProxy(yamlConf yaml.YamlConf) (err error) {
 	return err
 }
 
+// proxySignature lists the ports published by swapper-proxy for a configuration, and the log directory it mounts
+func proxySignature(yamlConf yaml.YamlConf) string {
+	var published []string
+	for _, frontend := range yamlConf.Frontends {
+		published = append(published, frontend.Published())
+	}
+	if yamlConf.ProxyStats.Port != 0 {
+		published = append(published, yamlConf.ProxyStats.Binding().Published())
+	}
+	if yamlConf.ProxyLogs.Path != "" {
+		published = append(published, "logs:"+yamlConf.ProxyLogs.Path)
+	}
+	sort.Strings(published)
+	return strings.Join(published, ",")
+}
+
+// runningProxySignature reads the signature of the running swapper-proxy from its "label|exposed ports" inspection.
+// The proxies of the first swapper versions have no label, and only published tcp ports on all the addresses.
+func runningProxySignature(inspect string) string {
+	parts := strings.SplitN(strings.TrimSpace(inspect), "|", 2)
+	if parts[0] != "" && parts[0] != "<no value>" || len(parts) < 2 {
+		return parts[0]
+	}
+	published := exposedPortRegexp.FindAllString(parts[1], -1)
+	sort.Strings(published)
+	return strings.Join(published, ",")
+}
+
+// publishedPort returns the docker publication of a port of swapper-proxy, which listens on the same port
+func publishedPort(port yaml.Port) string {
+	published := port.Range() + ":" + port.Range() + "/" + port.Protocol
+	if port.Address != "" {
+		published = port.Address + ":" + published
+	}
+	return published
+}
+
+// startDrain applies the drained conf and removes the old containers of a swap once the drain is over, or as soon as
+// stopDrain is called, without blocking the updates of the node
+func startDrain(drain time.Duration, hash string, drainedConf string, udpConf string) {
+	stop, done := make(chan struct{}), make(chan struct{})
+	drainStop, drainDone = stop, done
+	go func() {
+		defer close(done)
+		timer := time.NewTimer(drain)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-stop:
+			fmt.Println("Drain stopped by a new swap")
+		}
+		if err := reloadProxy(drainedConf, udpConf); err != nil {
+			fmt.Println(response.ErrorMessages["proxy_failed"])
+		}
+		if err := removeUnusedContainers(hash); err != nil {
+			fmt.Println(err.Error())
+		}
+	}()
+}
+
+// stopDrain ends the drain in progress at once, and waits until its old containers are removed
+func stopDrain() {
+	if drainStop == nil {
+		return
+	}
+	close(drainStop)
+	<-drainDone
+	drainStop, drainDone = nil, nil
+}
+
+// removeUnusedContainers stops the containers which are not of the configuration applied by the node, and removes the
+// unused docker images to save space
+func removeUnusedContainers(hash string) error {
+	fmt.Println("Remove unused containers")
+	out, err := exec.Command("docker", "container", "ls", "--format", "{{.ID}} {{.Names}}", "--filter", "name=swapper-container.").Output()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(out)) == "" {
+		return nil
+	}
+	psStr := strings.Split(strings.TrimSpace(string(out)), "\n")
+
+	// Stop unused containers
+	var stopCmd []string
+	stopCmd = append(stopCmd, "docker")
+	stopCmd = append(stopCmd, "stop")
+	for _, v := range psStr  {
+		containerPs := strings.Split(v, " ")
+		if strings.Contains(containerPs[1], "swapper-container." + hash) == false {
+			stopCmd = append(stopCmd, containerPs[0])
+			removeContainerFiles(containerPs[1])
+		}
+	}
+	if _, err = exec.Command(stopCmd[0], stopCmd[1:]...).Output(); err != nil {
+		return err
+	}
+
+	_, err = exec.Command("docker", "system", "prune", "--all", "--force").Output()
+	return err
+}
+
+// drainDuration returns how long the old containers of the sticky services are kept after a swap
+func drainDuration(yamlConf yaml.YamlConf) (drain time.Duration) {
+	for _, service := range yamlConf.Services {
+		if d := service.Proxy.DrainDuration(); d > drain {
+			drain = d
+		}
+	}
+	return drain
+}
+
+// reloadProxy writes the confs of haproxy and nginx (for udp) into swapper-proxy, and reloads them gracefully. The
+// confs are sent on the stdin of docker exec, they are never part of a shell command
+func reloadProxy(haproxyConf string, udpConf string) error {
+	confs := map[string]string{"/app/src/haproxy.next.cfg": haproxyConf}
+	if udpConf != "" {
+		confs["/app/src/nginx.next.conf"] = udpConf
+	}
+	for file, conf := range confs {
+		cmd := exec.Command("docker", "exec", "-i", "swapper-proxy", "sh", "-c", "cat > "+file)
+		cmd.Stdin = strings.NewReader(conf)
+		if _, err := cmd.Output(); err != nil {
+			return err
+		}
+	}
+	_, err := exec.Command("docker", "exec", "swapper-proxy", "bash", "-c", proxyReloadCommand(udpConf != "")).Output()
+	return err
+}
+
+// proxyReloadCommand moves the confs written by reloadProxy in place. A new swapper-proxy starts haproxy itself from
+// the haproxy.tmp.cfg file.
+func proxyReloadCommand(udp bool) string {
+	command := "if [ -f /var/run/haproxy.pid ]; then mv /app/src/haproxy.next.cfg /app/src/haproxy.cfg && kill -HUP $(cat /var/run/haproxy.pid); " +
+		"else mv /app/src/haproxy.next.cfg /app/src/haproxy.tmp.cfg; fi"
+	if udp == false {
+		return command
+	}
+	return command + " && mv /app/src/nginx.next.conf /app/src/nginx.conf && nginx -t -q -c /app/src/nginx.conf && " +
+		"if [ -f /var/run/nginx.pid ]; then nginx -s reload -c /app/src/nginx.conf; else nginx -c /app/src/nginx.conf; fi"
+}
+
 func runContainers(yamlConf yaml.YamlConf) (err error) {
 
 	for _, service := range yamlConf.Services  {
@@ -239,11 +515,7 @@ func runContainers(yamlConf yaml.YamlConf) (err error) {
 
 				for k, v := range container.LoggingOptions {
 					command = append(command, "--log-opt")
-					logOpt, err := ReplaceCommandIfExist(v.(string))
-					if err != nil {
-						return err
-					}
-					command = append(command, k.(string) + "=" + logOpt)
+					command = append(command, k.(string) + "=" + v.(string))
 				}
 
 				if container.HealthCmd != "" {
@@ -268,40 +540,29 @@ func runContainers(yamlConf yaml.YamlConf) (err error) {
 
 				for _, v := range container.ExtraHosts {
 					command = append(command, "--add-host")
-					extraHost, err := ReplaceCommandIfExist(v.(string))
-					if err != nil {
-						return err
-					}
-					command = append(command, extraHost)
+					command = append(command, v.(string))
 				}
 
+				volumes, err := writeContainerFiles(containerName, container.Files)
+				if err != nil {
+					fmt.Println(err)
+					return errors.New(fmt.Sprintf(response.ErrorMessages["container_failed"], containerName))
+				}
+				for _, v := range volumes {
+					command = append(command, "-v")
+					command = append(command, v)
+				}
+
+				// values are strings since the yaml is interpreted
 				for k, v := range container.Envs {
 					command = append(command, "-e")
-					var value string
-					if reflect.TypeOf(v).String() == "string" {
-						value = v.(string)
-					} else if reflect.TypeOf(v).String() == "bool" {
-						if v == true {
-							value = "true"
-						} else {
-							value = "false"
-						}
-					} else if reflect.TypeOf(v).String() == "int" {
-						if val, ok := (v).(int); ok {
-							value = strconv.Itoa(val)
-						}
-					}
-					envValue, err := ReplaceCommandIfExist(value)
-					if err != nil {
-						return err
-					}
-					command = append(command, k.(string) + "=" + envValue)
+					command = append(command, fmt.Sprint(k) + "=" + fmt.Sprint(v))
 				}
 				command = append(command, "-d")
 				command = append(command, container.Image+":"+container.Tag)
 
 				cmd := exec.Command(command[0], command[1:]...)
-				_, err := cmd.Output()
+				_, err = cmd.Output()
 				// todo: if errors, print docker log
 				if err != nil {
 					fmt.Println(err)
@@ -318,22 +579,9 @@ func runContainers(yamlConf yaml.YamlConf) (err error) {
 	return err
 }
 
+// ReplaceCommandIfExist evaluates the $() expressions of the input, with the command policy of the node
 func ReplaceCommandIfExist(input string) (str string, err error) {
-	re := regexp.MustCompile(`\$\(.+\)`)
-	matches := re.FindAllString(input, -1)
-	str = input
-	var command string
-	for _, p := range matches {
-		command = strings.Replace(strings.Replace(p, "$(", "", 1), ")", "", -1)
-		cmd := exec.Command("bash","-c", command)
-		out, err := cmd.Output()
-		if err != nil {
-			return str, errors.New(fmt.Sprintf(response.ErrorMessages["command_failed"], command))
-		}
-		outStr := strings.TrimSpace(string(out))
-		str = strings.Replace(str, p, outStr, -1)
-	}
-	return str, nil
+	return commandPolicy.Eval(input)
 }
 
 func ListenToMasters(filename string, yamlConf yaml.YamlConf) {
@@ -347,10 +595,10 @@ func ListenToMasters(filename string, yamlConf yaml.YamlConf) {
 	}
 
 	// Get yaml configuration file from master(s)
-	yamlConf, err := getYamlConfFromMasters(filename, masters)
+	yamlConf, swapperYaml, err := getYamlConfFromMasters(filename, masters)
 	if err != nil {
 		fmt.Println(err.Error())
-		_ = utils.SlackSendError(err.Error(), previousYamlConf)
+		_ = utils.Notify(utils.Event{Name: utils.EventNodeFailed, File: filename, OldHash: currentHash, Message: err.Error()}, previousYamlConf)
 		time.Sleep(5000 * time.Millisecond)
 		ListenToMasters(filename, previousYamlConf)
 		return
@@ -358,109 +606,278 @@ func ListenToMasters(filename string, yamlConf yaml.YamlConf) {
 
 	if yamlConf.Hash != currentHash {
 		fmt.Println("\n>>> Updating node...")
-
-		// start containers
-		err = runContainers(yamlConf)
+		updateStart := time.Now()
+		// the old containers of the previous swap are not kept longer than the next one
+		stopDrain()
+		previousAppliedYamlConf := appliedYamlConf
+
+		// evaluate $() expressions
+		var effectiveYaml string
+		yamlConf, effectiveYaml, err = evalYamlCommands(swapperYaml)
 		if err != nil {
 			fmt.Println(err.Error())
-			_ = utils.SlackSendError("Node failed to update\n"+err.Error(), yamlConf)
-			ListenToMasters(filename, yamlConf)
+			_ = utils.Notify(utils.Event{Name: utils.EventNodeFailed, File: filename, OldHash: currentHash, Message: "Node failed to update\n"+err.Error()}, previousYamlConf)
+			ListenToMasters(filename, previousYamlConf)
 			return
 		}
 
-		// create frontend haproxy conf
-		haproxyConf, err := CreateHaproxyConf(yamlConf)
+		// start containers, unless the builtin proxy cannot serve them
+		if nodeProxy == ProxyBuiltin {
+			err = checkBuiltinProxy(yamlConf)
+		}
+		if err == nil {
+			err = runContainers(yamlConf)
+		}
 		if err != nil {
 			fmt.Println(err.Error())
-			_ = utils.SlackSendError("Node failed to update\n"+err.Error(), yamlConf)
+			_ = utils.Notify(utils.Event{Name: utils.EventNodeFailed, File: filename, OldHash: currentHash, Message: "Node failed to update\n"+err.Error()}, yamlConf)
 			ListenToMasters(filename, yamlConf)
 			return
 		}
 
-		// start haproxy if necessary
-		err = startProxy(yamlConf)
-		if err != nil {
-			fmt.Println(err.Error())
-			_ = utils.SlackSendError("Node failed to update\n"+err.Error(), yamlConf)
-			ListenToMasters(filename, yamlConf)
-			return
+		var haproxyConf, drainedConf, udpConf string
+		if nodeProxy == ProxyBuiltin {
+			// swap the backends of the builtin proxy, which keeps the previous ones when it fails
+			fmt.Println("Swap builtin proxy")
+			err = applyBuiltinProxy(yamlConf)
+			if err != nil {
+				fmt.Println(err.Error())
+				_ = utils.Notify(utils.Event{Name: utils.EventNodeFailed, File: filename, OldHash: currentHash, Message: "Node failed to update\n"+err.Error()}, yamlConf)
+				ListenToMasters(filename, yamlConf)
+				return
+			}
+		} else {
+			// create frontend haproxy and udp confs, the old containers of sticky services are drained before being stopped
+			haproxyConf, err = CreateDrainingHaproxyConf(yamlConf, previousAppliedYamlConf)
+			if err == nil {
+				drainedConf, err = CreateHaproxyConf(yamlConf)
+			}
+			if err == nil {
+				udpConf, err = CreateUdpProxyConf(yamlConf)
+			}
+			if err != nil {
+				fmt.Println(err.Error())
+				_ = utils.Notify(utils.Event{Name: utils.EventNodeFailed, File: filename, OldHash: currentHash, Message: "Node failed to update\n"+err.Error()}, yamlConf)
+				ListenToMasters(filename, yamlConf)
+				return
+			}
+
+			// start haproxy if necessary
+			err = startProxy(yamlConf)
+			if err != nil {
+				fmt.Println(err.Error())
+				_ = utils.Notify(utils.Event{Name: utils.EventNodeFailed, File: filename, OldHash: currentHash, Message: "Node failed to update\n"+err.Error()}, yamlConf)
+				ListenToMasters(filename, yamlConf)
+				return
+			}
+
+			// write new files into swapper-proxy and reload it
+			fmt.Println("Reload proxy")
+			err = reloadProxy(haproxyConf, udpConf)
+			if err != nil {
+				fmt.Println(response.ErrorMessages["proxy_failed"])
+				_ = utils.Notify(utils.Event{Name: utils.EventNodeFailed, File: filename, OldHash: currentHash, Message: "Node failed to update\n"+response.ErrorMessages["proxy_failed"]}, yamlConf)
+				rollbackProxy(filename, yamlConf.Hash)
+				ListenToMasters(filename, yamlConf)
+				return
+			}
 		}
 
-		// write new file into swapper-proxy
-		fmt.Println("Reload proxy")
-		cmd := exec.Command("docker", "exec", "swapper-proxy", "bash", "-c", "echo '"+haproxyConf+"' > /app/src/haproxy.cfg")
-		_, err = cmd.Output()
-		if err != nil {
-			// todo rollback ?
-			fmt.Println(response.ErrorMessages["proxy_failed"])
-			_ = utils.SlackSendError("Node failed to update\n"+response.ErrorMessages["proxy_failed"], yamlConf)
+		// update currentHash
+		currentHash = yamlConf.Hash
+		appliedYamlConf = yamlConf
+		writeNodeYaml(filename, swapperYaml, effectiveYaml)
+
+		// the old containers are removed in the background at the end of the drain of sticky services, at once otherwise
+		if drainedConf != haproxyConf {
+			drain := drainDuration(yamlConf)
+			fmt.Println("Drain old containers during "+drain.String())
+			startDrain(drain, yamlConf.Hash, drainedConf, udpConf)
+		} else if err = removeUnusedContainers(yamlConf.Hash); err != nil {
+			fmt.Println(err.Error())
 			ListenToMasters(filename, yamlConf)
 			return
 		}
 
-		// reload swapper-proxy
-		cmdKill := exec.Command("docker", "exec", "swapper-proxy", "bash", "-c", "kill -HUP $(cat /var/run/haproxy.pid)")
-		_, err = cmdKill.Output()
-		if err != nil {
-			// todo rollback ?
-			fmt.Println(response.ErrorMessages["proxy_failed"])
-			_ = utils.SlackSendError("Node failed to update\n"+response.ErrorMessages["proxy_failed"], yamlConf)
-			ListenToMasters(filename, yamlConf)
-			return
+		fmt.Println(">>> Node updated")
+		event := utils.Event{
+			Name:     utils.EventNodeUpdated,
+			Success:  true,
+			File:     filename,
+			OldHash:  previousAppliedYamlConf.Hash,
+			NewHash:  yamlConf.Hash,
+			Changes:  yaml.ImageChanges(previousAppliedYamlConf, yamlConf),
+			Duration: time.Since(updateStart),
+		}
+		event.Hostname, _ = utils.GetHostname()
+		event.Message = event.Summary()
+		if event.Message == "" {
+			event.Message = "Node updated"
 		}
+		_ = utils.Notify(event, yamlConf)
 
-		// update currentHash
-		currentHash = yamlConf.Hash
+	}
 
+	ListenToMasters(filename, yamlConf)
+}
 
-		// remove old containers and images
-		fmt.Println("Remove unused containers")
-		cmd = exec.Command("docker", "container", "ls", "--format", "{{.ID}} {{.Names}}", "--filter", "name=swapper-container.")
-		out, err := cmd.Output()
-		if err != nil {
-			fmt.Println(err.Error())
-			ListenToMasters(filename, yamlConf)
-			return
+// evalYamlCommands evaluates the $() expressions of the yaml configuration served by masters, and returns the effective configuration
+func evalYamlCommands(swapperYaml string) (yamlConf yaml.YamlConf, effectiveYaml string, err error) {
+	effectiveYaml, err = yaml.EvalCommands(swapperYaml, ReplaceCommandIfExist)
+	if err != nil {
+		return yamlConf, effectiveYaml, err
+	}
+	yamlConf, err = yaml.ParseSwapperYaml(effectiveYaml)
+	if err != nil {
+		return yamlConf, effectiveYaml, err
+	}
+	err = checkFileSources(yamlConf)
+	if err != nil {
+		return yamlConf, effectiveYaml, err
+	}
+	err = decryptSecrets(yamlConf)
+	return yamlConf, effectiveYaml, err
+}
+
+// checkFileSources rejects the files whose source is not allowed by --allow-files, the yaml comes from anyone who can
+// deploy and would mount any path of the node otherwise
+func checkFileSources(yamlConf yaml.YamlConf) error {
+	for _, service := range yamlConf.Services {
+		for _, container := range service.Containers {
+			for _, file := range container.Files {
+				if err := checkFileSource(file); err != nil {
+					return err
+				}
+			}
 		}
-		if strings.TrimSpace(string(out)) == "" {
-			ListenToMasters(filename, yamlConf)
-			return
+	}
+	return nil
+}
+
+func checkFileSource(file yaml.File) error {
+	if file.Source != "" && utils.AllowedPath(file.Source, commandPolicy.Facts.AllowedFiles) == false {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["file_source_denied"], file.Source))
+	}
+	return nil
+}
+
+// decryptSecrets decrypts the encrypted environment values of the containers in memory, they are never written on disk
+func decryptSecrets(yamlConf yaml.YamlConf) error {
+	for _, service := range yamlConf.Services {
+		for _, container := range service.Containers {
+			for k, v := range container.Envs {
+				value, ok := v.(string)
+				if ok == false || strings.HasPrefix(value, yaml.EncryptedPrefix) == false {
+					continue
+				}
+				if nodePrivateKey == nil {
+					return errors.New(response.ErrorMessages["secret_private_key_needed"])
+				}
+				plaintext, err := utils.DecryptSecret(nodePrivateKey, value)
+				if err != nil {
+					return err
+				}
+				container.Envs[k] = plaintext
+			}
+			for i, file := range container.Files {
+				if file.Secret == "" {
+					continue
+				}
+				if nodePrivateKey == nil {
+					return errors.New(response.ErrorMessages["secret_private_key_needed"])
+				}
+				plaintext, err := utils.DecryptSecret(nodePrivateKey, file.Secret)
+				if err != nil {
+					return err
+				}
+				container.Files[i].Secret = plaintext
+			}
 		}
-		psStr := strings.Split(strings.TrimSpace(string(out)), "\n")
+	}
+	return nil
+}
 
-		// Stop unused containers
-		var stopCmd []string
-		stopCmd = append(stopCmd, "docker")
-		stopCmd = append(stopCmd, "stop")
-		for _, v := range psStr  {
-			containerPs := strings.Split(v, " ")
-			if strings.Contains(containerPs[1], "swapper-container." + yamlConf.Hash) == false {
-				stopCmd = append(stopCmd, containerPs[0])
+// writeContainerFiles materializes the files of a container in FilesDirectory, and returns the volumes mounting them read-only
+func writeContainerFiles(containerName string, files []yaml.File) (volumes []string, err error) {
+	directory := FilesDirectory + "/" + containerName
+	_ = os.RemoveAll(directory)
+	for i, file := range files {
+		if file.Source != "" {
+			if err := checkFileSource(file); err != nil {
+				return volumes, err
 			}
+			volumes = append(volumes, file.Source+":"+file.Path+":ro")
+			continue
 		}
-		cmd = exec.Command(stopCmd[0], stopCmd[1:]...)
-		out, err = cmd.Output()
-		if err != nil {
-			fmt.Println(err.Error())
-			ListenToMasters(filename, yamlConf)
-			return
+		if err := os.MkdirAll(directory, 0700); err != nil {
+			return volumes, err
+		}
+		content := file.Content
+		if file.Secret != "" {
+			content = file.Secret
 		}
+		hostFile := directory + "/" + strconv.Itoa(i) + "-" + filepath.Base(file.Path)
+		if err := ioutil.WriteFile(hostFile, []byte(content), file.Mode); err != nil {
+			return volumes, err
+		}
+		// WriteFile mode is filtered by the umask
+		if err := os.Chmod(hostFile, file.Mode); err != nil {
+			return volumes, err
+		}
+		volumes = append(volumes, hostFile+":"+file.Path+":ro")
+	}
+	return volumes, nil
+}
+
+// removeContainerFiles removes the files of a retired container
+func removeContainerFiles(containerName string) {
+	if err := os.RemoveAll(FilesDirectory + "/" + containerName); err != nil {
+		fmt.Println(err.Error())
+	}
+}
 
-		// remove unused docker images to save space
-		cmd = exec.Command("docker", "system", "prune", "--all", "--force")
-		out, err = cmd.Output()
+// writeNodeYaml keeps the yaml configuration applied by this node (as served by masters, and with its $() expressions evaluated),
+// only readable by its user as the effective one holds the resolved $(env:VAR) and $(file:path)
+func writeNodeYaml(filename string, swapperYaml string, effectiveYaml string) {
+	files := map[string]string{"node_" + filename: swapperYaml, "node_effective_" + filename: effectiveYaml}
+	for name, content := range files {
+		path := YamlDirectory + "/" + name
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		if err == nil {
+			// the files of previous versions were readable by all
+			err = os.Chmod(path, 0600)
+		}
 		if err != nil {
 			fmt.Println(err.Error())
-			ListenToMasters(filename, yamlConf)
-			return
 		}
+	}
+}
 
-		fmt.Println(">>> Node updated")
-		_ = utils.SlackSendSuccess("Node updated", yamlConf)
+// rollbackProxy puts back the proxy conf of the last applied yaml configuration, whose containers are still running
+func rollbackProxy(filename string, failedHash string) {
+	if appliedYamlConf.Hash == "" {
+		return
+	}
 
+	haproxyConf, err := CreateHaproxyConf(appliedYamlConf)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	udpConf, err := CreateUdpProxyConf(appliedYamlConf)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
 	}
 
-	ListenToMasters(filename, yamlConf)
+	err = reloadProxy(haproxyConf, udpConf)
+	if err != nil {
+		fmt.Println(response.ErrorMessages["proxy_failed"])
+		return
+	}
+
+	fmt.Println("Proxy rolled back to " + appliedYamlConf.Hash)
+	_ = utils.Notify(utils.Event{Name: utils.EventRollback, Success: true, File: filename, OldHash: failedHash, NewHash: appliedYamlConf.Hash, Message: "Proxy rolled back to " + appliedYamlConf.Hash}, appliedYamlConf)
 }
 
 func NodeStop(argv []string) response.Response {
@@ -514,5 +931,26 @@ func NodeStop(argv []string) response.Response {
 	ids := strings.Replace(out, "\n", " ", -1)
 	command = "docker stop " + strings.TrimSpace(ids)
 	out, _ = utils.Command(command)
+	_ = os.RemoveAll(FilesDirectory)
 	return response.Success("Stopped\n")
 }
+
+func NodeConfigArgs(argv []string) docopt.Opts {
+	arguments, _ := docopt.ParseArgs(nodeConfigUsage, argv, "")
+	return arguments
+}
+
+func NodeConfig(argv []string) response.Response {
+	arguments := NodeConfigArgs(argv)
+	filename := arguments["--apply"].(string)
+
+	sourceFile := YamlDirectory + "/node_effective_" + filename
+	if arguments["--raw"] == true {
+		sourceFile = YamlDirectory + "/node_" + filename
+	}
+	swapperYaml, err := ioutil.ReadFile(sourceFile)
+	if err != nil {
+		return response.Fail(fmt.Sprintf(response.ErrorMessages["node_config_not_found"], filename))
+	}
+	return response.Success(string(swapperYaml))
+}
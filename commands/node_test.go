@@ -4,10 +4,12 @@ import (
 	"github.com/docopt/docopt-go"
 	"github.com/sachamorard/swapper/response"
 	"github.com/sachamorard/swapper/utils"
+	"github.com/sachamorard/swapper/yaml"
 	"io/ioutil"
 	"os"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 )
@@ -86,9 +88,134 @@ func TestReplaceCommandIfExist(t *testing.T) {
 		t.Fail()
 	}
 
+	// shell syntax is always denied, and commands are denied by default
+	_, err = ReplaceCommandIfExist("$(ifconfig | grep inet)")
+	if err == nil || strings.Contains(err.Error(), "uses shell syntax, nodes run commands without shell") == false {
+		t.Error(err)
+	}
+	_, err = ReplaceCommandIfExist("$(ifconfig eth0)")
+	if err == nil || strings.Contains(err.Error(), "is not allowed on this node") == false {
+		t.Error(err)
+	}
+
+	defaultPolicy := commandPolicy
+	defer func() { commandPolicy = defaultPolicy }()
+	commandPolicy, _ = NewCommandPolicy("ifconfig *", "5s", "")
+	commandPolicy.Facts.Labels = map[string]string{"zone": "europe-west1-b"}
+	_, err = ReplaceCommandIfExist("$(ifconfig | grep inet)")
+	if err != nil && strings.Contains(err.Error(), "is not allowed on this node") {
+		t.Fail()
+	}
+
 	input, _ := ioutil.ReadFile("../doc/yml-examples/7.with.command.yml")
 	str, err = ReplaceCommandIfExist(string(input))
+	if err != nil || strings.Contains(str, "ZONE: europe-west1-b") == false {
+		t.Fail()
+	}
+}
+
+func TestNodeConfig(t *testing.T) {
+	_ = ioutil.WriteFile(YamlDirectory+"/node_effective_node-config.yml", []byte(""), 0644)
+	writeNodeYaml("node-config.yml", "hostname: $(hostname)", "hostname: node-1")
+	for _, name := range []string{"node_node-config.yml", "node_effective_node-config.yml"} {
+		if info, err := os.Stat(YamlDirectory + "/" + name); err != nil || info.Mode().Perm() != 0600 {
+			t.Error(name, err)
+		}
+	}
+
+	resp := NodeConfig([]string{"node", "config", "--apply", "node-config.yml"})
+	if resp.Code != 0 || resp.Message != "hostname: node-1" {
+		t.Fail()
+	}
+
+	resp = NodeConfig([]string{"node", "config", "--apply", "node-config.yml", "--raw"})
+	if resp.Code != 0 || resp.Message != "hostname: $(hostname)" {
+		t.Fail()
+	}
+
+	resp = NodeConfig([]string{"node", "config", "--apply", "unknown.yml"})
+	if resp.Code != 1 {
+		t.Fail()
+	}
+}
+
+func TestWriteContainerFiles(t *testing.T) {
+	containerName := "swapper-container.test.my-app.0"
+	files := []yaml.File{
+		{Path: "/etc/nginx/nginx.conf", Content: "worker_processes 1;", Mode: yaml.DefaultFileMode},
+		{Path: "/etc/nginx/my-app.key", Secret: "my-key", Mode: 0400},
+		{Path: "/etc/nginx/my-app.crt", Source: "/etc/ssl/certs/my-app.crt", Mode: yaml.DefaultFileMode},
+	}
+	// sources are denied unless their directory is allowed
+	if _, err := writeContainerFiles(containerName, files); err == nil || strings.Contains(err.Error(), "/etc/ssl/certs/my-app.crt") == false {
+		t.Error(err)
+	}
+	input, _ := ioutil.ReadFile("../doc/yml-examples/10.with.files.yml")
+	if _, _, err := evalYamlCommands(strings.Replace(string(input), "${TLS_KEY}", "my-key", 1)); err == nil || strings.Contains(err.Error(), "--allow-files") == false {
+		t.Error(err)
+	}
+	commandPolicy.Facts.AllowedFiles = []string{"/etc/ssl"}
+	defer func() { commandPolicy.Facts.AllowedFiles = nil }()
+	volumes, err := writeContainerFiles(containerName, files)
 	if err != nil {
+		t.Fatal(err)
+	}
+	directory := FilesDirectory + "/" + containerName
+	expected := []string{
+		directory + "/0-nginx.conf:/etc/nginx/nginx.conf:ro",
+		directory + "/1-my-app.key:/etc/nginx/my-app.key:ro",
+		"/etc/ssl/certs/my-app.crt:/etc/nginx/my-app.crt:ro",
+	}
+	if !reflect.DeepEqual(volumes, expected) {
+		t.Error(volumes)
+	}
+	content, _ := ioutil.ReadFile(directory + "/1-my-app.key")
+	info, _ := os.Stat(directory + "/1-my-app.key")
+	if string(content) != "my-key" || info.Mode().Perm() != 0400 {
+		t.Fail()
+	}
+
+	removeContainerFiles(containerName)
+	if _, err := os.Stat(directory); os.IsNotExist(err) == false {
+		t.Fail()
+	}
+}
+
+func TestProxySignature(t *testing.T) {
+	yamlConf, err := yaml.ParseSwapperYaml("version: '1'\nservices:\n  dns:\n    ports:\n      - 53:53/udp\n      - 53:53\n      - 127.0.0.1:8000-8010:9000-9010\n    containers:\n      - image: coredns/coredns\n        tag: 1.6.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := proxySignature(yamlConf)
+	if signature != "127.0.0.1:8000-8010/tcp,53/tcp,53/udp" {
+		t.Error(signature)
+	}
+	if publishedPort(yamlConf.Frontends[0].Port) != "53:53/udp" || publishedPort(yamlConf.Frontends[2].Port) != "127.0.0.1:8000-8010:8000-8010/tcp" {
+		t.Fail()
+	}
+
+	if runningProxySignature(signature+"|map[53/tcp:{} 53/udp:{} 8000/tcp:{}]\n") != signature {
+		t.Fail()
+	}
+	// proxies of the first versions have no label
+	if runningProxySignature("<no value>|map[81/tcp:{} 80/tcp:{}]\n") != "80/tcp,81/tcp" {
+		t.Fail()
+	}
+
+	yamlConf.ProxyStats = yaml.ProxyStats{Address: "127.0.0.1", Port: 8404, Username: "admin", Password: "s3cr3t"}
+	if proxySignature(yamlConf) != "127.0.0.1:8000-8010/tcp,127.0.0.1:8404/tcp,53/tcp,53/udp" {
+		t.Error(proxySignature(yamlConf))
+	}
+	yamlConf.ProxyLogs = yaml.ProxyLogs{Destination: "file", Path: "/var/log/swapper"}
+	if proxySignature(yamlConf) != "127.0.0.1:8000-8010/tcp,127.0.0.1:8404/tcp,53/tcp,53/udp,logs:/var/log/swapper" {
+		t.Error(proxySignature(yamlConf))
+	}
+
+	udpConf, err := CreateUdpProxyConf(yaml.YamlConf{})
+	if udpConf != "" || err != nil {
+		t.Fail()
+	}
+	if strings.Contains(proxyReloadCommand(false), "nginx") || strings.Contains(proxyReloadCommand(true), "nginx -s reload") == false {
 		t.Fail()
 	}
 }
@@ -99,6 +226,15 @@ func TestNodeStartArgs(t *testing.T) {
 	args := docopt.Opts{
 		"--join":    nil,
 		"--apply":   "default.yml",
+		"--allow-commands":  nil,
+		"--allow-env":       nil,
+		"--allow-files":     nil,
+		"--command-timeout": "5s",
+		"--audit-log":       "/tmp/swapper-commands.log",
+		"--label":           []string{},
+		"--metadata-url":    "http://169.254.169.254/computeMetadata/v1/",
+		"--private-key":     nil,
+		"--proxy":           "container",
 		"--detach":  false,
 		"--help":    false,
 		"start":     true,
@@ -114,6 +250,15 @@ func TestNodeStartArgs(t *testing.T) {
 	args = docopt.Opts{
 		"--join":    "localhost",
 		"--apply":   "default.yml",
+		"--allow-commands":  nil,
+		"--allow-env":       nil,
+		"--allow-files":     nil,
+		"--command-timeout": "5s",
+		"--audit-log":       "/tmp/swapper-commands.log",
+		"--label":           []string{},
+		"--metadata-url":    "http://169.254.169.254/computeMetadata/v1/",
+		"--private-key":     nil,
+		"--proxy":           "container",
 		"--detach":  false,
 		"--help":    false,
 		"start":     true,
@@ -129,6 +274,15 @@ func TestNodeStartArgs(t *testing.T) {
 	args = docopt.Opts{
 		"--join":    "localhost",
 		"--apply":   "default.yml",
+		"--allow-commands":  nil,
+		"--allow-env":       nil,
+		"--allow-files":     nil,
+		"--command-timeout": "5s",
+		"--audit-log":       "/tmp/swapper-commands.log",
+		"--label":           []string{},
+		"--metadata-url":    "http://169.254.169.254/computeMetadata/v1/",
+		"--private-key":     nil,
+		"--proxy":           "container",
 		"--detach":  true,
 		"--help":    false,
 		"start":     true,
@@ -144,6 +298,15 @@ func TestNodeStartArgs(t *testing.T) {
 	args = docopt.Opts{
 		"--join":    "localhost",
 		"--apply":   "default.yml",
+		"--allow-commands":  nil,
+		"--allow-env":       nil,
+		"--allow-files":     nil,
+		"--command-timeout": "5s",
+		"--audit-log":       "/tmp/swapper-commands.log",
+		"--label":           []string{},
+		"--metadata-url":    "http://169.254.169.254/computeMetadata/v1/",
+		"--private-key":     nil,
+		"--proxy":           "container",
 		"--detach":  true,
 		"--help":    false,
 		"start":     true,
@@ -159,6 +322,15 @@ func TestNodeStartArgs(t *testing.T) {
 	args = docopt.Opts{
 		"--join":    "localhost",
 		"--apply":   "ok.yml",
+		"--allow-commands":  nil,
+		"--allow-env":       nil,
+		"--allow-files":     nil,
+		"--command-timeout": "5s",
+		"--audit-log":       "/tmp/swapper-commands.log",
+		"--label":           []string{},
+		"--metadata-url":    "http://169.254.169.254/computeMetadata/v1/",
+		"--private-key":     nil,
+		"--proxy":           "container",
 		"--detach":  true,
 		"--help":    false,
 		"start":     true,
@@ -169,3 +341,20 @@ func TestNodeStartArgs(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestDrainDuration(t *testing.T) {
+	yamlConf, err := yaml.ParseSwapperYaml("version: '1'\nservices:\n  api:\n    ports:\n      - 80:80\n    sticky:\n      type: cookie\n    containers:\n      - image: nginx\n        tag: 1.17.0\n  web:\n    ports:\n      - 81:80\n    sticky:\n      type: source\n      drain: 2m\n    containers:\n      - image: nginx\n        tag: 1.17.0\n  static:\n    ports:\n      - 82:80\n    containers:\n      - image: nginx\n        tag: 1.17.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	services := map[string]yaml.Service{}
+	for _, service := range yamlConf.Services {
+		services[service.Name] = service
+	}
+	if drainDuration(yamlConf) != 2*time.Minute || drainDuration(yaml.YamlConf{Services: []yaml.Service{services["api"]}}) != 30*time.Second {
+		t.Fail()
+	}
+	if drainDuration(yaml.YamlConf{Services: []yaml.Service{services["static"]}}) != 0 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,130 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/utils"
+	"github.com/sachamorard/swapper/yaml"
+	"os"
+	"strings"
+
+	"github.com/docopt/docopt-go"
+)
+
+var (
+	planUsage = `
+swapper plan [OPTIONS].
+
+Show what a deploy would change, compared to the configuration currently deployed on the master.
+
+Usage:
+ swapper plan [-f <file>] [--var <variable>...] [--var-file <vars>...] [--env-vars] [--public-key <file>] [--master <hostname>]
+ swapper plan (-h|--help)
+
+Options:
+ -h --help                 Show this screen.
+ -f NAME --file=NAME       Swapper yml config file [default: default.yml]
+ --var VAR=VALUE           To inject variable into yaml file
+ --var-file=FILE           Read variables from a .env or .yml file
+ --env-vars                Read variables from the environment
+ --public-key=FILE         Public key of the deploys, to compare the secret: values with the deployed ones
+ --master=HOSTNAME         Master's hostname [default: {{hostname}}]
+
+Examples:
+ $ swapper plan -f myapp.yml --var TAG=1.0.2
+ $ swapper plan -f myapp.yml --var TAG=1.0.2 --public-key swapper.pub
+`
+)
+
+func PlanArgs(argv []string) docopt.Opts {
+	hostname, _ := utils.GetHostname()
+	planUsage = strings.Replace(planUsage, "{{hostname}}", hostname, -1)
+
+	arguments, _ := docopt.ParseArgs(planUsage, argv, "")
+	return arguments
+}
+
+func Plan(argv []string) response.Response {
+	arguments := PlanArgs(argv)
+	vars, err := getVars(arguments)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	file := arguments["--file"].(string)
+	masterHostname := arguments["--master"].(string)
+
+	cleanYaml, err := yaml.PrepareSwapperYaml(file, vars)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	// encrypted like a deploy, the secrets have the fingerprints of the deployed ones
+	if arguments["--public-key"] != nil {
+		cleanYaml, err = encryptSecrets(cleanYaml, arguments["--public-key"])
+		if err != nil {
+			return response.Fail(err.Error())
+		}
+	}
+	newYamlConf, err := yaml.ParseSwapperYaml(cleanYaml)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+
+	fileInfo, _ := os.Stat(file)
+	deployedYaml, err := GetDeployedYaml(fileInfo.Name(), newYamlConf, masterHostname)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+
+	var oldYamlConf yaml.YamlConf
+	title := "Plan for " + fileInfo.Name() + " (not deployed yet)"
+	if deployedYaml != "" {
+		oldYamlConf, err = yaml.ParseSwapperYaml(deployedYaml)
+		if err != nil {
+			return response.Fail(err.Error())
+		}
+		title = "Plan for " + fileInfo.Name() + " (deployed hash: " + oldYamlConf.Hash + ")"
+	}
+
+	changes := yaml.Diff(oldYamlConf, newYamlConf)
+	if len(changes) == 0 {
+		return response.Success("\nNo changes. " + fileInfo.Name() + " is up to date.\n")
+	}
+	return response.Success("\n" + title + "\n\n" + FormatChanges(changes) + "\n")
+}
+
+// FormatChanges prints the changes grouped by service, and counts the disruptive ones
+func FormatChanges(changes []yaml.Change) string {
+	var lines []string
+	disruptive := 0
+	currentService := ""
+	for _, change := range changes {
+		if change.Disruptive != "" {
+			disruptive++
+		}
+
+		if change.Service == "" {
+			lines = append(lines, change.String())
+			continue
+		}
+
+		if change.Path == "" {
+			line := change.Action + " service " + change.Service + " (ports: " + change.Old + change.New + ")"
+			if change.Disruptive != "" {
+				line = line + "   [DISRUPTIVE] " + change.Disruptive
+			}
+			lines = append(lines, line)
+			currentService = change.Service
+			continue
+		}
+
+		if change.Service != currentService {
+			lines = append(lines, yaml.ActionChange+" service "+change.Service)
+			currentService = change.Service
+		}
+		lines = append(lines, "    "+change.String())
+	}
+
+	lines = append(lines, "")
+	lines = append(lines, fmt.Sprintf("%d change(s), %d disruptive", len(changes), disruptive))
+	return strings.Join(lines, "\n")
+}
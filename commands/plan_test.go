@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"github.com/docopt/docopt-go"
+	"github.com/sachamorard/swapper/yaml"
+	"reflect"
+	"testing"
+)
+
+func TestFormatChanges(t *testing.T) {
+	changes := []yaml.Change{
+		{Path: "notifications", Action: yaml.ActionAdd, New: "slack"},
+		{Service: "api", Path: "ports", Action: yaml.ActionAdd, New: "443:443", Disruptive: "new frontend port 443 recreates swapper-proxy with a short interruption"},
+		{Service: "api", Path: "containers[0].tag", Action: yaml.ActionChange, Old: "1.16.0", New: "1.17.0"},
+		{Service: "new", Action: yaml.ActionAdd, New: "81:80"},
+	}
+	expected := `+ notifications: slack
+~ service api
+    + ports: 443:443   [DISRUPTIVE] new frontend port 443 recreates swapper-proxy with a short interruption
+    ~ containers[0].tag: 1.16.0 → 1.17.0
++ service new (ports: 81:80)
+
+4 change(s), 1 disruptive`
+	if FormatChanges(changes) != expected {
+		t.Fail()
+	}
+}
+
+func TestPlanArgs(t *testing.T) {
+	argv := []string{"plan", "-f", "ok.yml", "--var", "ENV=prod", "--public-key", "swapper.pub", "--master", "swapper-master:1207"}
+	arguments := PlanArgs(argv)
+	args := docopt.Opts{
+		"--var":        []string{"ENV=prod"},
+		"--var-file":   []string{},
+		"--env-vars":   false,
+		"--public-key": "swapper.pub",
+		"--file":       "ok.yml",
+		"--master":     "swapper-master:1207",
+		"--help":       false,
+		"plan":         true,
+	}
+	eq := reflect.DeepEqual(arguments, args)
+	if !eq {
+		t.Fail()
+	}
+}
@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/yaml"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docopt/docopt-go"
+)
+
+var (
+	ProxyUsage = `
+swapper proxy COMMAND [OPTIONS].
+
+Inspect swapper-proxy on this node
+
+Commands:
+ status    Show the state of the containers behind swapper-proxy
+
+Run 'swapper proxy COMMAND --help' for more information on a command.
+
+`
+	proxyStatusUsage = `
+swapper proxy status [OPTIONS].
+
+Show the state, weight, sessions and last health check of each container behind swapper-proxy, read from the stats
+socket of haproxy. Udp ports are served by nginx, they are not listed.
+
+Usage:
+ swapper proxy status [--apply <file>]
+ swapper proxy status (-h|--help)
+
+Options:
+ -h --help                Show this screen.
+ --apply=FILE             Yaml configuration file applied by the node [default: default.yml]
+
+Examples:
+ $ swapper proxy status --apply my.yml
+
+`
+)
+
+// proxyStatsCommand reads the stats of haproxy from its socket in swapper-proxy, as csv
+const proxyStatsCommand = "echo 'show stat' | socat stdio /var/run/haproxy.sock"
+
+func ProxyStatusArgs(argv []string) docopt.Opts {
+	arguments, _ := docopt.ParseArgs(proxyStatusUsage, argv, "")
+	return arguments
+}
+
+func ProxyStatus(argv []string) response.Response {
+	arguments := ProxyStatusArgs(argv)
+	filename := arguments["--apply"].(string)
+
+	// the configuration applied by the node names the services of the backends
+	var yamlConf yaml.YamlConf
+	if swapperYaml, err := ioutil.ReadFile(YamlDirectory + "/node_effective_" + filename); err == nil {
+		yamlConf, _ = yaml.ParseSwapperYaml(string(swapperYaml))
+	}
+
+	cmd := exec.Command("docker", "exec", "swapper-proxy", "sh", "-c", proxyStatsCommand)
+	out, err := cmd.Output()
+	if err != nil {
+		return response.Fail(response.ErrorMessages["proxy_stats_unavailable"])
+	}
+	status, err := FormatProxyStatus(string(out), yamlConf)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	return response.Success(status)
+}
+
+// FormatProxyStatus formats the csv stats of haproxy, one line per container of each frontend
+func FormatProxyStatus(stats string, yamlConf yaml.YamlConf) (status string, err error) {
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(stats, "# "))).ReadAll()
+	if err != nil || len(records) == 0 {
+		return status, errors.New(response.ErrorMessages["proxy_stats_unavailable"])
+	}
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	for _, name := range []string{"pxname", "svname", "scur", "weight", "status", "check_status", "check_code"} {
+		if _, ok := columns[name]; ok == false {
+			return status, errors.New(response.ErrorMessages["proxy_stats_unavailable"])
+		}
+	}
+
+	var buffer bytes.Buffer
+	writer := tabwriter.NewWriter(&buffer, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "SERVICE\tPORT\tSERVER\tSTATUS\tWEIGHT\tSESSIONS\tCHECK")
+	for _, record := range records[1:] {
+		if len(record) < len(records[0]) {
+			continue
+		}
+		backend, server := record[columns["pxname"]], record[columns["svname"]]
+		if server == "FRONTEND" || server == "BACKEND" || backend == "stats" {
+			continue
+		}
+		service, port := backend, "-"
+		for _, frontend := range yamlConf.Frontends {
+			if frontend.BackendName == backend {
+				service, port = frontend.ServiceName, strings.TrimSuffix(frontend.Published(), "/"+yaml.ProtocolTcp)
+			}
+		}
+		check := strings.TrimSpace(record[columns["check_status"]] + " " + record[columns["check_code"]])
+		if check == "" {
+			check = "-"
+		}
+		_, _ = fmt.Fprintln(writer, strings.Join([]string{service, port, server, record[columns["status"]], record[columns["weight"]], record[columns["scur"]], check}, "\t"))
+	}
+	_ = writer.Flush()
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"github.com/docopt/docopt-go"
+	"github.com/sachamorard/swapper/yaml"
+	"reflect"
+	"testing"
+)
+
+func TestFormatProxyStatus(t *testing.T) {
+	yamlConf, err := yaml.ParseSwapperYaml("version: '1'\nservices:\n  api:\n    ports:\n      - 127.0.0.1:80:8080\n    containers:\n      - image: nginx\n        tag: 1.17.0\n      - image: nginx\n        tag: 1.17.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := `# pxname,svname,qcur,scur,status,weight,check_status,check_code,
+stats,FRONTEND,,0,OPEN,,,,
+frontend_127.0.0.1_80,FRONTEND,,3,OPEN,,,,
+backend_127.0.0.1_80_8080,container_0,0,2,UP,100,L7OK,200,
+backend_127.0.0.1_80_8080,container_1,0,1,DOWN,100,L7STS,500,
+backend_127.0.0.1_80_8080,drain_0,0,0,UP,0,L4OK,,
+backend_127.0.0.1_80_8080,BACKEND,0,3,UP,200,,,
+backend_81_80,container_0,0,0,no check,100,,,
+`
+	status, err := FormatProxyStatus(stats, yamlConf)
+	expected := `SERVICE        PORT          SERVER       STATUS    WEIGHT  SESSIONS  CHECK
+api            127.0.0.1:80  container_0  UP        100     2         L7OK 200
+api            127.0.0.1:80  container_1  DOWN      100     1         L7STS 500
+api            127.0.0.1:80  drain_0      UP        0       0         L4OK
+backend_81_80  -             container_0  no check  100     0         -`
+	if err != nil || status != expected {
+		t.Errorf("%v\n%s", err, status)
+	}
+
+	if _, err := FormatProxyStatus("Unknown command\n", yamlConf); err == nil {
+		t.Fail()
+	}
+}
+
+func TestProxyStatusArgs(t *testing.T) {
+	argv := []string{"proxy", "status", "--apply", "my.yml"}
+	arguments := ProxyStatusArgs(argv)
+	args := docopt.Opts{
+		"--apply": "my.yml",
+		"--help":  false,
+		"proxy":   true,
+		"status":  true,
+	}
+	if !reflect.DeepEqual(arguments, args) {
+		t.Error(arguments)
+	}
+}
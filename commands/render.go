@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/utils"
+	"github.com/sachamorard/swapper/yaml"
+
+	"github.com/docopt/docopt-go"
+)
+
+var (
+	renderUsage = `
+swapper render [OPTIONS].
+
+Print the yaml configuration with its variables replaced, as it would be deployed.
+
+Usage:
+ swapper render [-f <file>] [--var <variable>...] [--var-file <vars>...] [--env-vars] [--node-eval [--allow-commands <patterns>] [--allow-env <names>] [--allow-files <paths>] [--label <label>...]]
+ swapper render (-h|--help)
+
+Options:
+ -h --help                 Show this screen.
+ -f NAME --file=NAME       Swapper yml config file [default: default.yml]
+ --var VAR=VALUE           To inject variable into yaml file
+ --var-file=FILE           Read variables from a .env or .yml file
+ --env-vars                Read variables from the environment
+ --node-eval               Evaluate $() expressions locally, like a node would do
+ --allow-commands=PATTERNS Commands allowed in $() expressions, like swapper node start
+ --allow-env=NAMES         Environment variables readable by $(env:VAR), like swapper node start
+ --allow-files=PATHS       Files, and directories, readable by $(file:path), like swapper node start
+ --label KEY=VALUE         Label of the node, available as $(label:KEY)
+
+Examples:
+ $ swapper render -f myapp.yml --var TAG=1.0.2
+ $ swapper render -f myapp.yml --var TAG=1.0.2 --node-eval
+`
+)
+
+func RenderArgs(argv []string) docopt.Opts {
+	arguments, _ := docopt.ParseArgs(renderUsage, argv, "")
+	return arguments
+}
+
+func Render(argv []string) response.Response {
+	arguments := RenderArgs(argv)
+	vars, err := getVars(arguments)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	file := arguments["--file"].(string)
+
+	cleanYaml, err := yaml.PrepareSwapperYaml(file, vars)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	if arguments["--node-eval"] == true {
+		allowCommands := ""
+		if arguments["--allow-commands"] != nil {
+			allowCommands = arguments["--allow-commands"].(string)
+		}
+		policy, err := NewCommandPolicy(allowCommands, commandPolicy.Timeout.String(), "")
+		if err != nil {
+			return response.Fail(err.Error())
+		}
+		policy.Facts.Labels, err = utils.ParseLabels(utils.InterfaceToArray(arguments["--label"]))
+		if err != nil {
+			return response.Fail(err.Error())
+		}
+		if arguments["--allow-env"] != nil {
+			policy.Facts.AllowedEnv = utils.AllowList(arguments["--allow-env"].(string))
+		}
+		if arguments["--allow-files"] != nil {
+			policy.Facts.AllowedFiles = utils.AllowList(arguments["--allow-files"].(string))
+		}
+		cleanYaml, err = yaml.EvalCommands(cleanYaml, policy.Eval)
+		if err != nil {
+			return response.Fail(err.Error())
+		}
+	}
+
+	_, err = yaml.ParseSwapperYaml(cleanYaml)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	return response.Success(cleanYaml)
+}
@@ -0,0 +1,54 @@
+package commands
+
+import (
+	"github.com/docopt/docopt-go"
+	"github.com/sachamorard/swapper/utils"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	resp := Render([]string{"render", "-f", "../yaml/tests/v1/valid.2.yml", "--var", "TAG=1.0.1", "--var", "ENV=prod"})
+	if resp.Code != 0 || strings.Contains(resp.Message, "tag: 1.0.1") == false {
+		t.Fail()
+	}
+
+	resp = Render([]string{"render", "-f", "../yaml/tests/v1/valid.2.yml"})
+	if resp.Code != 1 {
+		t.Fail()
+	}
+
+	resp = Render([]string{"render", "-f", "../doc/yml-examples/7.with.command.yml", "--node-eval"})
+	if resp.Code != 1 || strings.Contains(resp.Message, "node has no label zone") == false {
+		t.Fail()
+	}
+
+	hostname, _ := utils.GetHostname()
+	resp = Render([]string{"render", "-f", "../doc/yml-examples/7.with.command.yml", "--node-eval", "--label", "zone=europe-west1-b"})
+	if resp.Code != 0 || strings.Contains(resp.Message, "fluentd-address: "+hostname+":24224") == false || strings.Contains(resp.Message, "ZONE: europe-west1-b") == false {
+		t.Fail()
+	}
+}
+
+func TestRenderArgs(t *testing.T) {
+	argv := []string{"render", "-f", "ok.yml", "--var", "ENV=prod", "--node-eval"}
+	arguments := RenderArgs(argv)
+	args := docopt.Opts{
+		"--var":            []string{"ENV=prod"},
+		"--var-file":       []string{},
+		"--env-vars":       false,
+		"--file":           "ok.yml",
+		"--node-eval":      true,
+		"--allow-commands": nil,
+		"--allow-env":      nil,
+		"--allow-files":    nil,
+		"--label":          []string{},
+		"--help":           false,
+		"render":           true,
+	}
+	eq := reflect.DeepEqual(arguments, args)
+	if !eq {
+		t.Fail()
+	}
+}
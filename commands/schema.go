@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/yaml"
+
+	"github.com/docopt/docopt-go"
+)
+
+var (
+	schemaUsage = `
+swapper schema [OPTIONS].
+
+Print the JSON Schema of the swapper yaml format, to check and autocomplete your files in your editor.
+
+Usage:
+ swapper schema [--version <version>]
+ swapper schema (-h|--help)
+
+Options:
+ -h --help                 Show this screen.
+ --version=VERSION         Version of the yaml format [default: 1]
+
+Examples:
+ $ swapper schema --version 2 > swapper.schema.json
+`
+)
+
+func SchemaArgs(argv []string) docopt.Opts {
+	arguments, _ := docopt.ParseArgs(schemaUsage, argv, "")
+	return arguments
+}
+
+func Schema(argv []string) response.Response {
+	arguments := SchemaArgs(argv)
+	version := arguments["--version"].(string)
+	schema, err := yaml.JSONSchema(version)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	return response.Success(string(schema))
+}
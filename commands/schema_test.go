@@ -0,0 +1,25 @@
+package commands
+
+import (
+	"encoding/json"
+	"github.com/sachamorard/swapper/response"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	resp := Schema([]string{"schema"})
+	var schema map[string]interface{}
+	if resp.Code != 0 || json.Unmarshal([]byte(resp.Message), &schema) != nil {
+		t.Fail()
+	}
+
+	resp = Schema([]string{"schema", "--version", "2"})
+	if resp.Code != 0 || json.Unmarshal([]byte(resp.Message), &schema) != nil {
+		t.Fail()
+	}
+
+	resp = Schema([]string{"schema", "--version", "0"})
+	if resp.Code != 1 || resp.Message != response.ErrorMessages["yaml_version"] {
+		t.Fail()
+	}
+}
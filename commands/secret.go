@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/utils"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docopt/docopt-go"
+)
+
+var (
+	SecretUsage = `
+swapper secret COMMAND [OPTIONS].
+
+Manage the secrets of your yaml files. Values are encrypted with a public key, only nodes holding the private key decrypt them.
+
+Commands:
+ keygen    Create a key pair
+ encrypt   Encrypt a value, to write it in a yaml file
+ rotate    Encrypt again the values of a yaml file with a new key pair
+
+Run 'swapper secret COMMAND --help' for more information on a command.
+
+`
+	secretKeygenUsage = `
+swapper secret keygen [OPTIONS].
+
+Create a key pair: <name>.pem is the private key of the nodes, <name>.pub is the public key used to encrypt values
+
+Usage:
+ swapper secret keygen [--name <name>] [--bits <bits>]
+ swapper secret keygen (-h|--help)
+
+Options:
+ -h --help                Show this screen.
+ --name=NAME              Name of the key files [default: swapper]
+ --bits=BITS              Size of the RSA key [default: 4096]
+
+Examples:
+ $ swapper secret keygen --name prod
+
+`
+	secretEncryptUsage = `
+swapper secret encrypt [OPTIONS].
+
+Encrypt a value (read from stdin if not given), and print its enc: value to write in a yaml file
+
+Usage:
+ swapper secret encrypt --public-key <file> [<value>]
+ swapper secret encrypt (-h|--help)
+
+Options:
+ -h --help                Show this screen.
+ --public-key=FILE        Public key
+
+Examples:
+ $ swapper secret encrypt --public-key prod.pub my-db-password
+ $ cat password.txt | swapper secret encrypt --public-key prod.pub
+
+`
+	secretRotateUsage = `
+swapper secret rotate [OPTIONS].
+
+Decrypt the enc: values of a yaml file with the current private key, and encrypt them again with a new public key
+
+Usage:
+ swapper secret rotate -f <file> --private-key <file> --public-key <file>
+ swapper secret rotate (-h|--help)
+
+Options:
+ -h --help                Show this screen.
+ -f NAME --file=NAME      Swapper yml config file
+ --private-key=FILE       Current private key
+ --public-key=FILE        New public key
+
+Examples:
+ $ swapper secret keygen --name prod-2020
+ $ swapper secret rotate -f myapp.yml --private-key prod.pem --public-key prod-2020.pub
+
+`
+)
+
+func SecretKeygenArgs(argv []string) docopt.Opts {
+	arguments, _ := docopt.ParseArgs(secretKeygenUsage, argv, "")
+	return arguments
+}
+
+func SecretKeygen(argv []string) response.Response {
+	arguments := SecretKeygenArgs(argv)
+	name := arguments["--name"].(string)
+	bits, err := strconv.Atoi(arguments["--bits"].(string))
+	if err != nil || bits < 2048 {
+		return response.Fail(fmt.Sprintf(response.ErrorMessages["secret_bits_invalid"], arguments["--bits"]))
+	}
+
+	privatePEM, publicPEM, err := utils.GenerateKeyPair(bits)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	if err := ioutil.WriteFile(name+".pem", privatePEM, 0600); err != nil {
+		return response.Fail(err.Error())
+	}
+	if err := ioutil.WriteFile(name+".pub", publicPEM, 0644); err != nil {
+		return response.Fail(err.Error())
+	}
+	return response.Success("\n>> " + name + ".pem and " + name + ".pub created\nKeep " + name + ".pem on your nodes only:\nswapper node start --private-key " + name + ".pem\n")
+}
+
+func SecretEncryptArgs(argv []string) docopt.Opts {
+	arguments, _ := docopt.ParseArgs(secretEncryptUsage, argv, "")
+	return arguments
+}
+
+func SecretEncrypt(argv []string) response.Response {
+	arguments := SecretEncryptArgs(argv)
+	publicKey, err := utils.ReadPublicKey(arguments["--public-key"].(string))
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+
+	var value string
+	if arguments["<value>"] != nil {
+		value = arguments["<value>"].(string)
+	} else {
+		input, err := ioutil.ReadAll(bufio.NewReader(os.Stdin))
+		if err != nil {
+			return response.Fail(err.Error())
+		}
+		value = strings.TrimSuffix(string(input), "\n")
+	}
+
+	encrypted, err := utils.EncryptSecret(publicKey, value)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	return response.Success(encrypted)
+}
+
+func SecretRotateArgs(argv []string) docopt.Opts {
+	arguments, _ := docopt.ParseArgs(secretRotateUsage, argv, "")
+	return arguments
+}
+
+func SecretRotate(argv []string) response.Response {
+	arguments := SecretRotateArgs(argv)
+	file := arguments["--file"].(string)
+	privateKey, err := utils.ReadPrivateKey(arguments["--private-key"].(string))
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	publicKey, err := utils.ReadPublicKey(arguments["--public-key"].(string))
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		return response.Fail(fmt.Sprintf(response.ErrorMessages["file_not_exist"], file))
+	}
+	output, count, err := utils.RotateSecrets(string(input), privateKey, publicKey)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	if err := ioutil.WriteFile(file, []byte(output), 0644); err != nil {
+		return response.Fail(err.Error())
+	}
+	return response.Success(fmt.Sprintf("\n>> %d secret(s) of %s encrypted with the new key\nDeploy it, then start your nodes with the new private key\n", count, file))
+}
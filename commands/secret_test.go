@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/utils"
+	"github.com/sachamorard/swapper/yaml"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSecretCommands(t *testing.T) {
+	resp := SecretKeygen([]string{"secret", "keygen", "--name", "/tmp/swapper-secret-test", "--bits", "2048"})
+	if resp.Code != 0 {
+		t.Fatal(resp.Message)
+	}
+	resp = SecretKeygen([]string{"secret", "keygen", "--name", "/tmp/swapper-secret-test-2", "--bits", "2048"})
+	if resp.Code != 0 {
+		t.Fatal(resp.Message)
+	}
+	resp = SecretKeygen([]string{"secret", "keygen", "--bits", "512"})
+	if resp.Code != 1 {
+		t.Fail()
+	}
+
+	resp = SecretEncrypt([]string{"secret", "encrypt", "--public-key", "/tmp/swapper-secret-test.pub", "p@ss"})
+	if resp.Code != 0 || strings.HasPrefix(resp.Message, yaml.EncryptedPrefix) == false {
+		t.Fail()
+	}
+
+	file := "/tmp/swapper-secret-test.yml"
+	_ = ioutil.WriteFile(file, []byte("environment:\n  DB_PASSWORD: "+resp.Message+"\n"), 0644)
+	resp = SecretRotate([]string{"secret", "rotate", "-f", file, "--private-key", "/tmp/swapper-secret-test.pem", "--public-key", "/tmp/swapper-secret-test-2.pub"})
+	if resp.Code != 0 || strings.Contains(resp.Message, "1 secret(s)") == false {
+		t.Fail()
+	}
+	rotated, _ := ioutil.ReadFile(file)
+	newPrivateKey, _ := utils.ReadPrivateKey("/tmp/swapper-secret-test-2.pem")
+	plaintext, err := utils.DecryptSecret(newPrivateKey, strings.TrimSpace(strings.Split(string(rotated), "DB_PASSWORD: ")[1]))
+	if err != nil || plaintext != "p@ss" {
+		t.Fail()
+	}
+}
+
+func TestEncryptSecrets(t *testing.T) {
+	SecretKeygen([]string{"secret", "keygen", "--name", "/tmp/swapper-secret-test", "--bits", "2048"})
+	cleanYaml, _ := yaml.PrepareSwapperYaml("../doc/yml-examples/9.with.secrets.yml", []string{"DB_PASSWORD=p@ss"})
+
+	_, err := encryptSecrets(cleanYaml, nil)
+	if err == nil || err.Error() != response.ErrorMessages["secret_public_key_needed"] {
+		t.Fail()
+	}
+
+	encryptedYaml, err := encryptSecrets(cleanYaml, "/tmp/swapper-secret-test.pub")
+	if err != nil || strings.Contains(encryptedYaml, "p@ss") {
+		t.Fatal(err)
+	}
+
+	defer func() { nodePrivateKey = nil }()
+	nodePrivateKey = nil
+	_, _, err = evalYamlCommands(encryptedYaml)
+	if err == nil || err.Error() != response.ErrorMessages["secret_private_key_needed"] {
+		t.Fail()
+	}
+
+	nodePrivateKey, _ = utils.ReadPrivateKey("/tmp/swapper-secret-test.pem")
+	yamlConf, effectiveYaml, err := evalYamlCommands(strings.Replace(encryptedYaml, "API_KEY", "#API_KEY", 1))
+	if err != nil || yamlConf.Services[0].Containers[0].Envs["DB_PASSWORD"] != "p@ss" || strings.Contains(effectiveYaml, "p@ss") {
+		t.Errorf("%v %s", err, effectiveYaml)
+	}
+}
+
+// Two deploys of the same file have the same hash, though their secrets are encrypted again
+func TestYamlHashOfSecrets(t *testing.T) {
+	SecretKeygen([]string{"secret", "keygen", "--name", "/tmp/swapper-secret-test", "--bits", "2048"})
+	cleanYaml, _ := yaml.PrepareSwapperYaml("../doc/yml-examples/9.with.secrets.yml", []string{"DB_PASSWORD=p@ss"})
+	first, err := encryptSecrets(cleanYaml, "/tmp/swapper-secret-test.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := encryptSecrets(cleanYaml, "/tmp/swapper-secret-test.pub")
+	if first == second || yamlHash(first) != yamlHash(second) || yamlHash(first+"deployer: alice\n") != yamlHash(second) {
+		t.Error(yamlHash(first), yamlHash(second))
+	}
+
+	changedYaml, _ := yaml.PrepareSwapperYaml("../doc/yml-examples/9.with.secrets.yml", []string{"DB_PASSWORD=n3w"})
+	changed, _ := encryptSecrets(changedYaml, "/tmp/swapper-secret-test.pub")
+	if yamlHash(changed) == yamlHash(first) {
+		t.Error("same hash for another secret")
+	}
+}
+
+func TestDecryptSecretFiles(t *testing.T) {
+	SecretKeygen([]string{"secret", "keygen", "--name", "/tmp/swapper-secret-test", "--bits", "2048"})
+	cleanYaml, _ := yaml.PrepareSwapperYaml("../doc/yml-examples/10.with.files.yml", []string{"TLS_KEY=my-key"})
+	encryptedYaml, err := encryptSecrets(cleanYaml, "/tmp/swapper-secret-test.pub")
+	if err != nil || strings.Contains(encryptedYaml, "my-key") {
+		t.Fatal(err)
+	}
+
+	defer func() { nodePrivateKey = nil }()
+	nodePrivateKey, _ = utils.ReadPrivateKey("/tmp/swapper-secret-test.pem")
+	allowedFiles := commandPolicy.Facts.AllowedFiles
+	defer func() { commandPolicy.Facts.AllowedFiles = allowedFiles }()
+
+	// the source of a file is refused outside the allowed directories
+	commandPolicy.Facts.AllowedFiles = []string{"/etc/app"}
+	_, _, err = evalYamlCommands(encryptedYaml)
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["file_source_denied"], "/etc/ssl/certs/my-app.crt") {
+		t.Error(err)
+	}
+
+	commandPolicy.Facts.AllowedFiles = []string{"/etc/ssl"}
+	yamlConf, effectiveYaml, err := evalYamlCommands(encryptedYaml)
+	if err != nil || yamlConf.Services[0].Containers[0].Files[1].Secret != "my-key" || strings.Contains(effectiveYaml, "my-key") {
+		t.Errorf("%v %s", err, effectiveYaml)
+	}
+}
@@ -0,0 +1,71 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/yaml"
+	"strings"
+
+	"github.com/docopt/docopt-go"
+)
+
+var (
+	validateUsage = `
+swapper validate [OPTIONS].
+
+Check a swapper yaml file offline, and report all its problems with their line and column.
+
+Usage:
+ swapper validate [-f <file>] [--var <variable>...] [--var-file <vars>...] [--env-vars]
+ swapper validate (-h|--help)
+
+Options:
+ -h --help                 Show this screen.
+ -f NAME --file=NAME       Swapper yml config file [default: default.yml]
+ --var VAR=VALUE           To inject variable into yaml file
+ --var-file=FILE           Read variables from a .env or .yml file
+ --env-vars                Read variables from the environment
+
+Examples:
+ $ swapper validate -f myapp.yml --var TAG=1.0.2
+`
+)
+
+func ValidateArgs(argv []string) docopt.Opts {
+	arguments, _ := docopt.ParseArgs(validateUsage, argv, "")
+	return arguments
+}
+
+func Validate(argv []string) response.Response {
+	arguments := ValidateArgs(argv)
+	vars, err := getVars(arguments)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+	file := arguments["--file"].(string)
+
+	problems, err := yaml.Validate(file, vars)
+	if err != nil {
+		return response.Fail(err.Error())
+	}
+
+	errorsCount := 0
+	var lines []string
+	for _, problem := range problems {
+		if problem.Level == yaml.LevelError {
+			errorsCount++
+		}
+		problemFile := file
+		if problem.File != "" {
+			problemFile = problem.File
+		}
+		lines = append(lines, problemFile+":"+problem.String())
+	}
+	lines = append(lines, fmt.Sprintf("%s: %d error(s), %d warning(s)", file, errorsCount, len(problems)-errorsCount))
+
+	if errorsCount > 0 {
+		return response.Fail(strings.Join(lines, "\n"))
+	}
+	return response.Success(strings.Join(lines, "\n"))
+}
+
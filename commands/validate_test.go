@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"github.com/docopt/docopt-go"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	resp := Validate([]string{"validate", "-f", "../yaml/tests/v1/valid.2.yml", "--var", "TAG=1.0.1", "--var", "ENV=prod"})
+	if resp.Code != 0 || resp.Message != "../yaml/tests/v1/valid.2.yml: 0 error(s), 0 warning(s)" {
+		t.Fail()
+	}
+
+	resp = Validate([]string{"validate", "-f", "../yaml/tests/include/invalid.yml"})
+	if resp.Code != 1 || strings.HasPrefix(resp.Message, "../yaml/tests/include/invalid.common.yml:3:7: error: unknown field \"drivers\"") == false {
+		t.Error(resp.Message)
+	}
+
+	resp = Validate([]string{"validate", "-f", "../yaml/tests/v1/invalid.13.yml"})
+	if resp.Code != 1 || strings.HasSuffix(resp.Message, "invalid.13.yml: 7 error(s), 1 warning(s)") == false {
+		t.Fail()
+	}
+}
+
+func TestValidateArgs(t *testing.T) {
+	argv := []string{"validate", "-f", "ok.yml", "--var", "ENV=prod"}
+	arguments := ValidateArgs(argv)
+	args := docopt.Opts{
+		"--var":      []string{"ENV=prod"},
+		"--var-file": []string{},
+		"--env-vars": false,
+		"--file":     "ok.yml",
+		"--help":     false,
+		"validate":   true,
+	}
+	eq := reflect.DeepEqual(arguments, args)
+	if !eq {
+		t.Fail()
+	}
+}
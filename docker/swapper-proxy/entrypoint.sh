@@ -15,7 +15,12 @@ while true; do
             echo '[START] Start Haproxy' ;
             cp /app/src/haproxy.tmp.cfg /app/src/haproxy.cfg
             rm /app/src/haproxy.tmp.cfg
-            haproxy -W -D -f /app/src/haproxy.cfg -p /var/run/haproxy.pid &
+            # haproxy stays in the foreground to log on its stdout: docker logs, or the log directory of the node
+            if [ -d /var/log/swapper-proxy ]; then
+                haproxy -W -f /app/src/haproxy.cfg -p /var/run/haproxy.pid >> /var/log/swapper-proxy/haproxy.log 2>&1 &
+            else
+                haproxy -W -f /app/src/haproxy.cfg -p /var/run/haproxy.pid &
+            fi
             echo '[SUCCESS] Start Haproxy' ;
         fi
     fi
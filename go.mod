@@ -8,4 +8,5 @@ require (
 	github.com/dustin/go-humanize v1.0.0
 	github.com/valyala/fasthttp v1.4.0
 	gopkg.in/yaml.v2 v2.2.2
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -14,8 +14,17 @@ Usage: swapper COMMAND [OPTIONS]
 Commands:
  master     Manage master
  node       Manage node
+ proxy      Inspect swapper-proxy
  status     Status of your 
  deploy     Deploy a new Swapper configuration
+ plan       Show what a deploy would change
+ diff       Show the differences between two revisions of a Swapper configuration
+ render     Print the resolved Swapper configuration
+ convert    Convert a docker-compose file into a Swapper configuration
+ migrate    Rewrite a Swapper configuration file into the latest version
+ validate   Check a Swapper configuration file
+ schema     Print the JSON Schema of the Swapper configuration file
+ secret     Manage secrets of Swapper configurations
  version    Show the Swapper version information
  upgrade    Upgrade version of swapper
 
@@ -35,6 +44,14 @@ func HelpNode() response.Response {
 	return response.Success(commands.NodeUsage)
 }
 
+func HelpProxy() response.Response {
+	return response.Success(commands.ProxyUsage)
+}
+
+func HelpSecret() response.Response {
+	return response.Success(commands.SecretUsage)
+}
+
 func main() {
 	_ = os.Mkdir(commands.YamlDirectory, 0777)
 	_ = os.Mkdir(commands.PidDirectory, 0777)
@@ -60,6 +77,8 @@ func main() {
 			response = commands.NodeStart(os.Args[1:])
 		case "stop":
 			response = commands.NodeStop(os.Args[1:])
+		case "config":
+			response = commands.NodeConfig(os.Args[1:])
 		default:
 			response = HelpNode()
 		}
@@ -72,8 +91,40 @@ func main() {
 		default:
 			response = HelpMaster()
 		}
+	case "proxy":
+		switch arg2 {
+		case "status":
+			response = commands.ProxyStatus(os.Args[1:])
+		default:
+			response = HelpProxy()
+		}
+	case "secret":
+		switch arg2 {
+		case "keygen":
+			response = commands.SecretKeygen(os.Args[1:])
+		case "encrypt":
+			response = commands.SecretEncrypt(os.Args[1:])
+		case "rotate":
+			response = commands.SecretRotate(os.Args[1:])
+		default:
+			response = HelpSecret()
+		}
 	case "deploy":
 		response = commands.Deploy(os.Args[1:])
+	case "plan":
+		response = commands.Plan(os.Args[1:])
+	case "diff":
+		response = commands.Diff(os.Args[1:])
+	case "render":
+		response = commands.Render(os.Args[1:])
+	case "convert":
+		response = commands.Convert(os.Args[1:])
+	case "migrate":
+		response = commands.Migrate(os.Args[1:])
+	case "validate":
+		response = commands.Validate(os.Args[1:])
+	case "schema":
+		response = commands.Schema(os.Args[1:])
 	case "status":
 		response = commands.Status()
 	case "version":
@@ -33,3 +33,13 @@ func TestHelpNode(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestHelpProxy(t *testing.T) {
+	response := HelpProxy()
+	if response.Message != commands.ProxyUsage {
+		t.Fail()
+	}
+	if response.Code != 0 {
+		t.Fail()
+	}
+}
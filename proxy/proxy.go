@@ -0,0 +1,513 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Defaults of the builtin proxy, the same as the defaults of swapper-proxy
+	DefaultMaxConn        = 800
+	DefaultConnectTimeout = 5 * time.Second
+	DefaultIdleTimeout    = 50 * time.Second
+	DefaultCheckInterval  = 2 * time.Second
+	DefaultCheckRise      = 2
+	DefaultCheckFall      = 3
+)
+
+// Frontend is a tcp address the proxy listens on, and the backends its connections are forwarded to
+type Frontend struct {
+	Name    string
+	Address string
+	// Balance is roundrobin, leastconn or source
+	Balance        string
+	MaxConn        int
+	ConnectTimeout time.Duration
+	ClientTimeout  time.Duration
+	ServerTimeout  time.Duration
+	CheckInterval  time.Duration
+	CheckRise      int
+	CheckFall      int
+	// SendProxy sends the PROXY protocol header to backends, with the address of clients
+	SendProxy bool
+	// Allow and Deny are the networks of clients, only the allowed clients are accepted when Allow is set and the
+	// denied ones never are
+	Allow    []*net.IPNet
+	Deny     []*net.IPNet
+	Backends []Backend
+}
+
+// Backend is a container behind a frontend, a weight of 0 gets no new connections
+type Backend struct {
+	Name    string
+	Address string
+	Weight  int
+}
+
+// Proxy forwards the connections of its frontends to their backends, in the process. Applying new frontends swaps
+// the backends of the listening addresses at once, the connections in progress finish with the old backends.
+type Proxy struct {
+	mutex     sync.Mutex
+	listeners map[string]*listener
+	checks    map[string]*check
+	// inherited are the listeners of another process, used by Apply instead of listening again on their addresses
+	inherited map[string]net.Listener
+}
+
+type listener struct {
+	net.Listener
+	// pool holds the *pool of the frontend, replaced atomically by Apply
+	pool   atomic.Value
+	closed int32
+	// conns counts the connections of the listener for its whole life, so that they stay within the maxconn of the
+	// latest pool across swaps
+	mutex sync.Mutex
+	freed *sync.Cond
+	conns int
+}
+
+// the counters updated atomically come first in the structs, to be aligned on 32-bit systems
+type pool struct {
+	next     uint64
+	frontend Frontend
+	servers  []*server
+}
+
+type server struct {
+	conns int64
+	Backend
+	check *check
+}
+
+// check is the health check of a backend address, shared by the successive pools of a frontend so that swaps keep
+// the state of the backends that do not change
+type check struct {
+	name     string
+	address  string
+	interval time.Duration
+	rise     int
+	fall     int
+	up       int32
+	stop     chan struct{}
+}
+
+func New() *Proxy {
+	return &Proxy{listeners: map[string]*listener{}, checks: map[string]*check{}, inherited: map[string]net.Listener{}}
+}
+
+// Apply replaces the frontends of the proxy. The new addresses are listened on before anything changes, so that the
+// running frontends are kept when one of them cannot be.
+func (p *Proxy) Apply(frontends []Frontend) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	opened := map[string]*listener{}
+	for _, frontend := range frontends {
+		if _, exists := p.listeners[frontend.Address]; exists {
+			continue
+		}
+		if _, exists := opened[frontend.Address]; exists {
+			continue
+		}
+		if l, exists := p.inherited[frontend.Address]; exists {
+			opened[frontend.Address] = newListener(l)
+			continue
+		}
+		l, err := net.Listen("tcp", frontend.Address)
+		if err != nil {
+			for address, o := range opened {
+				if _, exists := p.inherited[address]; exists == false {
+					_ = o.Listener.Close()
+				}
+			}
+			return errors.New("frontend " + frontend.Name + ": " + err.Error())
+		}
+		opened[frontend.Address] = newListener(l)
+	}
+
+	checks := map[string]*check{}
+	listeners := map[string]*listener{}
+	for _, frontend := range frontends {
+		frontend = withDefaults(frontend)
+		newPool := &pool{frontend: frontend}
+		for _, backend := range frontend.Backends {
+			key := checkKey(frontend, backend)
+			c, exists := checks[key]
+			if exists == false {
+				c, exists = p.checks[key]
+			}
+			if exists == false {
+				c = &check{name: frontend.Name + "/" + backend.Name, address: backend.Address, interval: frontend.CheckInterval, rise: frontend.CheckRise, fall: frontend.CheckFall, up: 1, stop: make(chan struct{})}
+				go c.run()
+			}
+			checks[key] = c
+			newPool.servers = append(newPool.servers, &server{Backend: backend, check: c})
+		}
+
+		l, exists := p.listeners[frontend.Address]
+		if exists == false {
+			l = opened[frontend.Address]
+			l.swap(newPool)
+			go l.serve()
+		} else {
+			l.swap(newPool)
+		}
+		listeners[frontend.Address] = l
+	}
+
+	for address, l := range p.listeners {
+		if _, exists := listeners[address]; exists == false {
+			l.close()
+		}
+	}
+	for key, c := range p.checks {
+		if _, exists := checks[key]; exists == false {
+			close(c.stop)
+		}
+	}
+	for address, l := range p.inherited {
+		if _, exists := listeners[address]; exists == false {
+			_ = l.Close()
+		}
+	}
+	p.listeners = listeners
+	p.checks = checks
+	p.inherited = map[string]net.Listener{}
+	return nil
+}
+
+// Files returns a copy of the listening sockets of the proxy, for a process which takes over its frontends with
+// Inherit. The sockets stay open in this process until Close.
+func (p *Proxy) Files() (addresses []string, files []*os.File, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for address, l := range p.listeners {
+		tcpListener, ok := l.Listener.(*net.TCPListener)
+		if ok == false {
+			continue
+		}
+		file, err := tcpListener.File()
+		if err != nil {
+			for _, f := range files {
+				_ = f.Close()
+			}
+			return nil, nil, errors.New("frontend " + l.pool.Load().(*pool).frontend.Name + ": " + err.Error())
+		}
+		addresses = append(addresses, address)
+		files = append(files, file)
+	}
+	return addresses, files, nil
+}
+
+// Inherit takes the listening sockets of another process, the next Apply serves them without listening again on their
+// addresses, so that no connection is refused in between. The sockets no frontend uses are closed by Apply.
+func (p *Proxy) Inherit(addresses []string, files []*os.File) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for i, file := range files {
+		l, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			return errors.New("inherited " + addresses[i] + ": " + err.Error())
+		}
+		p.inherited[addresses[i]] = l
+	}
+	return nil
+}
+
+// Close stops listening, the connections in progress are not interrupted
+func (p *Proxy) Close() {
+	_ = p.Apply(nil)
+}
+
+func withDefaults(frontend Frontend) Frontend {
+	if frontend.MaxConn == 0 {
+		frontend.MaxConn = DefaultMaxConn
+	}
+	if frontend.ConnectTimeout == 0 {
+		frontend.ConnectTimeout = DefaultConnectTimeout
+	}
+	if frontend.ClientTimeout == 0 {
+		frontend.ClientTimeout = DefaultIdleTimeout
+	}
+	if frontend.ServerTimeout == 0 {
+		frontend.ServerTimeout = DefaultIdleTimeout
+	}
+	if frontend.CheckInterval == 0 {
+		frontend.CheckInterval = DefaultCheckInterval
+	}
+	if frontend.CheckRise == 0 {
+		frontend.CheckRise = DefaultCheckRise
+	}
+	if frontend.CheckFall == 0 {
+		frontend.CheckFall = DefaultCheckFall
+	}
+	return frontend
+}
+
+func checkKey(frontend Frontend, backend Backend) string {
+	return frontend.Name + "/" + backend.Name + "|" + backend.Address + "|" + frontend.CheckInterval.String() + "|" + strconv.Itoa(frontend.CheckRise) + "|" + strconv.Itoa(frontend.CheckFall)
+}
+
+func newListener(l net.Listener) *listener {
+	newListener := &listener{Listener: l}
+	newListener.freed = sync.NewCond(&newListener.mutex)
+	return newListener
+}
+
+// serve accepts the connections of the frontend, no more than its maxconn at once like haproxy: the next ones wait
+// in the backlog of the system. Each connection goes to the backends of the latest pool.
+func (l *listener) serve() {
+	for {
+		if l.acquire() == false {
+			return
+		}
+		conn, err := l.Accept()
+		if err != nil {
+			l.release()
+			if atomic.LoadInt32(&l.closed) == 1 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		go func() {
+			defer l.release()
+			l.pool.Load().(*pool).forward(conn)
+		}()
+	}
+}
+
+// swap replaces the pool of the listener, the connections waiting for its maxconn are woken up in case it is higher
+func (l *listener) swap(newPool *pool) {
+	l.mutex.Lock()
+	l.pool.Store(newPool)
+	l.mutex.Unlock()
+	l.freed.Broadcast()
+}
+
+// acquire waits until the listener has less connections than its maxconn and counts a new one, false once it is closed
+func (l *listener) acquire() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for l.conns >= l.pool.Load().(*pool).frontend.MaxConn && atomic.LoadInt32(&l.closed) == 0 {
+		l.freed.Wait()
+	}
+	if atomic.LoadInt32(&l.closed) == 1 {
+		return false
+	}
+	l.conns++
+	return true
+}
+
+func (l *listener) release() {
+	l.mutex.Lock()
+	l.conns--
+	l.mutex.Unlock()
+	l.freed.Signal()
+}
+
+func (l *listener) close() {
+	l.mutex.Lock()
+	atomic.StoreInt32(&l.closed, 1)
+	l.mutex.Unlock()
+	l.freed.Broadcast()
+	_ = l.Listener.Close()
+}
+
+// forward proxies a client connection to a backend, the next backends are tried when it cannot connect
+func (p *pool) forward(client net.Conn) {
+	defer client.Close()
+	ip := clientIp(client)
+	if p.accepts(ip) == false {
+		return
+	}
+
+	var backend net.Conn
+	var s *server
+	tried := map[*server]bool{}
+	for len(tried) < len(p.servers) {
+		s = p.pick(ip, tried)
+		if s == nil {
+			break
+		}
+		tried[s] = true
+		conn, err := net.DialTimeout("tcp", s.Address, p.frontend.ConnectTimeout)
+		if err == nil {
+			backend = conn
+			break
+		}
+	}
+	if backend == nil {
+		fmt.Println("[builtin proxy] " + p.frontend.Name + ": no backend available for " + client.RemoteAddr().String())
+		return
+	}
+	defer backend.Close()
+	atomic.AddInt64(&s.conns, 1)
+	defer atomic.AddInt64(&s.conns, -1)
+
+	if p.frontend.SendProxy {
+		if _, err := io.WriteString(backend, proxyHeader(client)); err != nil {
+			return
+		}
+	}
+	activity := time.Now().UnixNano()
+	done := make(chan struct{})
+	go func() {
+		pipe(backend, client, p.frontend.ClientTimeout, &activity)
+		close(done)
+	}()
+	pipe(client, backend, p.frontend.ServerTimeout, &activity)
+	<-done
+}
+
+// accepts tells whether a client may connect to the frontend
+func (p *pool) accepts(ip net.IP) bool {
+	for _, network := range p.frontend.Deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(p.frontend.Allow) == 0 {
+		return true
+	}
+	for _, network := range p.frontend.Allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// pick chooses a backend which is up and not tried yet, by weight, nil when there is none
+func (p *pool) pick(ip net.IP, tried map[*server]bool) *server {
+	var candidates []*server
+	total := 0
+	for _, s := range p.servers {
+		if s.Weight > 0 && tried[s] == false && atomic.LoadInt32(&s.check.up) == 1 {
+			candidates = append(candidates, s)
+			total += s.Weight
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	switch p.frontend.Balance {
+	case "leastconn":
+		best := candidates[0]
+		for _, s := range candidates[1:] {
+			// compare conns/weight without dividing
+			if atomic.LoadInt64(&s.conns)*int64(best.Weight) < atomic.LoadInt64(&best.conns)*int64(s.Weight) {
+				best = s
+			}
+		}
+		return best
+	case "source":
+		hash := fnv.New32a()
+		_, _ = hash.Write(ip)
+		return weighted(candidates, int(hash.Sum32()%uint32(total)))
+	}
+	return weighted(candidates, int((atomic.AddUint64(&p.next, 1)-1)%uint64(total)))
+}
+
+// weighted returns the server of a position in the sum of the weights of the servers
+func weighted(servers []*server, position int) *server {
+	for _, s := range servers {
+		if position < s.Weight {
+			return s
+		}
+		position -= s.Weight
+	}
+	return servers[len(servers)-1]
+}
+
+// pipe copies a side of a connection to the other until its end. Both sides are closed when the connection is idle,
+// in both directions, for longer than the timeout: activity is the last time data went through, in nanoseconds.
+func pipe(dst net.Conn, src net.Conn, timeout time.Duration, activity *int64) {
+	buffer := make([]byte, 32*1024)
+	for {
+		_ = src.SetReadDeadline(time.Now().Add(timeout))
+		n, err := src.Read(buffer)
+		if n > 0 {
+			atomic.StoreInt64(activity, time.Now().UnixNano())
+			if _, err := dst.Write(buffer[:n]); err != nil {
+				break
+			}
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			if time.Since(time.Unix(0, atomic.LoadInt64(activity))) < timeout {
+				continue
+			}
+			_ = dst.Close()
+			_ = src.Close()
+			return
+		}
+		if err != nil {
+			break
+		}
+	}
+	// the other side finishes its own direction, like a half-closed tcp connection
+	if tcp, ok := dst.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+	} else {
+		_ = dst.Close()
+	}
+}
+
+// proxyHeader returns the version 1 header of the PROXY protocol of a client connection
+func proxyHeader(client net.Conn) string {
+	source, sourceOk := client.RemoteAddr().(*net.TCPAddr)
+	destination, destinationOk := client.LocalAddr().(*net.TCPAddr)
+	if sourceOk == false || destinationOk == false {
+		return "PROXY UNKNOWN\r\n"
+	}
+	family := "TCP4"
+	if source.IP.To4() == nil {
+		family = "TCP6"
+	}
+	return fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, source.IP.String(), destination.IP.String(), source.Port, destination.Port)
+}
+
+func clientIp(conn net.Conn) net.IP {
+	if address, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return address.IP
+	}
+	return nil
+}
+
+// run checks the backend until it is stopped, it is up after rise successful connections in a row and down after
+// fall failed ones
+func (c *check) run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	successes, failures := 0, 0
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+		conn, err := net.DialTimeout("tcp", c.address, c.interval)
+		if err == nil {
+			_ = conn.Close()
+			successes, failures = successes+1, 0
+		} else {
+			successes, failures = 0, failures+1
+		}
+		if successes == c.rise && atomic.CompareAndSwapInt32(&c.up, 0, 1) {
+			fmt.Println("[builtin proxy] " + c.name + " is UP")
+		}
+		if failures == c.fall && atomic.CompareAndSwapInt32(&c.up, 1, 0) {
+			fmt.Println("[builtin proxy] " + c.name + " is DOWN")
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// backend listens on a free port of the loopback, and greets each connection with its name
+func backend(t *testing.T, name string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = conn.Write([]byte(name + "\n"))
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				_, _ = conn.Write([]byte(line))
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// greeting connects to the proxy and returns the name of the backend which answered, empty when it was refused
+func greeting(t *testing.T, address string) string {
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(conn)
+	name, _ := reader.ReadString('\n')
+	_, _ = conn.Write([]byte("ping\n"))
+	echo, _ := reader.ReadString('\n')
+	if name != "" && echo != "ping\n" {
+		t.Error("no echo from " + name)
+	}
+	return strings.TrimSpace(name)
+}
+
+func TestApply(t *testing.T) {
+	blue, green := backend(t, "blue"), backend(t, "green")
+	defer blue.Close()
+	defer green.Close()
+	address := freeAddress(t)
+
+	p := New()
+	defer p.Close()
+	err := p.Apply([]Frontend{{Name: "api_80", Address: address, Backends: []Backend{{Name: "container_0", Address: blue.Addr().String(), Weight: 100}, {Name: "container_1", Address: green.Addr().String(), Weight: 0}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if name := greeting(t, address); name != "blue" {
+			t.Error(name)
+		}
+	}
+
+	// swap on the same address
+	err = p.Apply([]Frontend{{Name: "api_80", Address: address, Backends: []Backend{{Name: "container_0", Address: green.Addr().String(), Weight: 100}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := greeting(t, address); name != "green" {
+		t.Error(name)
+	}
+
+	// a frontend which cannot listen keeps the running ones
+	err = p.Apply([]Frontend{{Name: "api_80", Address: address}, {Name: "web_81", Address: blue.Addr().String()}})
+	if err == nil || strings.HasPrefix(err.Error(), "frontend web_81: ") == false {
+		t.Error(err)
+	}
+	if name := greeting(t, address); name != "green" {
+		t.Error(name)
+	}
+
+	p.Close()
+	if _, err := net.DialTimeout("tcp", address, time.Second); err == nil {
+		t.Error("still listening on " + address)
+	}
+}
+
+// hold connects to the proxy and returns the connection with the name of the backend which answered within timeout
+func hold(t *testing.T, address string, timeout time.Duration) (net.Conn, *bufio.Reader, string) {
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	name, _ := reader.ReadString('\n')
+	return conn, reader, strings.TrimSpace(name)
+}
+
+func TestMaxConn(t *testing.T) {
+	blue := backend(t, "blue")
+	defer blue.Close()
+	address := freeAddress(t)
+	frontends := []Frontend{{Name: "api_80", Address: address, MaxConn: 2, Backends: []Backend{{Name: "container_0", Address: blue.Addr().String(), Weight: 100}}}}
+
+	p := New()
+	defer p.Close()
+	if err := p.Apply(frontends); err != nil {
+		t.Fatal(err)
+	}
+	first, _, name := hold(t, address, 2*time.Second)
+	defer first.Close()
+	if name != "blue" {
+		t.Fatal(name)
+	}
+
+	// the connections of the previous pool count for the maxconn of the new one
+	if err := p.Apply(frontends); err != nil {
+		t.Fatal(err)
+	}
+	second, _, name := hold(t, address, 2*time.Second)
+	defer second.Close()
+	if name != "blue" {
+		t.Fatal(name)
+	}
+	third, reader, name := hold(t, address, 200*time.Millisecond)
+	defer third.Close()
+	if name != "" {
+		t.Error("over maxconn: " + name)
+	}
+
+	_ = first.Close()
+	_ = third.SetDeadline(time.Now().Add(2 * time.Second))
+	if name, _ := reader.ReadString('\n'); name != "blue\n" {
+		t.Error(name)
+	}
+}
+
+func TestInherit(t *testing.T) {
+	blue := backend(t, "blue")
+	defer blue.Close()
+	address := freeAddress(t)
+	frontends := []Frontend{{Name: "api_80", Address: address, Backends: []Backend{{Name: "container_0", Address: blue.Addr().String(), Weight: 100}}}}
+
+	previous := New()
+	if err := previous.Apply(frontends); err != nil {
+		t.Fatal(err)
+	}
+	addresses, files, err := previous.Files()
+	if err != nil || len(addresses) != 1 || addresses[0] != address {
+		t.Fatal(addresses, err)
+	}
+	p := New()
+	defer p.Close()
+	if err := p.Inherit(addresses, files); err != nil {
+		t.Fatal(err)
+	}
+	previous.Close()
+
+	// the connections wait for the next Apply instead of being refused
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := p.Apply(frontends); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if name, _ := bufio.NewReader(conn).ReadString('\n'); name != "blue\n" {
+		t.Error(name)
+	}
+}
+
+func TestPick(t *testing.T) {
+	up := &check{up: 1}
+	servers := []*server{{Backend: Backend{Name: "a", Weight: 3}, check: up}, {Backend: Backend{Name: "b", Weight: 1}, check: up}, {Backend: Backend{Name: "c", Weight: 5}, check: &check{}}}
+	p := &pool{servers: servers}
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		counts[p.pick(nil, map[*server]bool{}).Name]++
+	}
+	if counts["a"] != 6 || counts["b"] != 2 || counts["c"] != 0 {
+		t.Error(counts)
+	}
+	if s := p.pick(nil, map[*server]bool{servers[0]: true}); s.Name != "b" {
+		t.Error(s.Name)
+	}
+	if s := p.pick(nil, map[*server]bool{servers[0]: true, servers[1]: true}); s != nil {
+		t.Error(s.Name)
+	}
+
+	p.frontend.Balance = "source"
+	ip := net.ParseIP("10.1.2.3")
+	first := p.pick(ip, map[*server]bool{})
+	for i := 0; i < 4; i++ {
+		if s := p.pick(ip, map[*server]bool{}); s != first {
+			t.Error(s.Name, first.Name)
+		}
+	}
+
+	p.frontend.Balance = "leastconn"
+	servers[0].conns = 4
+	servers[1].conns = 1
+	if s := p.pick(nil, map[*server]bool{}); s.Name != "b" {
+		t.Error(s.Name)
+	}
+	servers[1].conns = 2
+	if s := p.pick(nil, map[*server]bool{}); s.Name != "a" {
+		t.Error(s.Name)
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	_, denied, _ := net.ParseCIDR("10.1.2.3/32")
+	p := &pool{}
+	if p.accepts(net.ParseIP("192.168.1.1")) == false {
+		t.Fail()
+	}
+	p.frontend = Frontend{Allow: []*net.IPNet{private}, Deny: []*net.IPNet{denied}}
+	if p.accepts(net.ParseIP("10.0.0.1")) == false || p.accepts(net.ParseIP("10.1.2.3")) || p.accepts(net.ParseIP("192.168.1.1")) {
+		t.Fail()
+	}
+}
+
+func TestProxyHeader(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	if proxyHeader(server) != "PROXY UNKNOWN\r\n" {
+		t.Error(proxyHeader(server))
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	accepted, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+	source, destination := conn.LocalAddr().(*net.TCPAddr), l.Addr().(*net.TCPAddr)
+	expected := "PROXY TCP4 127.0.0.1 127.0.0.1 " + strconv.Itoa(source.Port) + " " + strconv.Itoa(destination.Port) + "\r\n"
+	if proxyHeader(accepted) != expected {
+		t.Error(proxyHeader(accepted))
+	}
+}
+
+func TestCheck(t *testing.T) {
+	blue := backend(t, "blue")
+	address := blue.Addr().String()
+	c := &check{name: "api_80/container_0", address: address, interval: 20 * time.Millisecond, rise: 2, fall: 2, up: 1, stop: make(chan struct{})}
+	go c.run()
+	defer close(c.stop)
+
+	_ = blue.Close()
+	if waitUp(c, 0) == false {
+		t.Fatal("still up")
+	}
+	blue, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer blue.Close()
+	if waitUp(c, 1) == false {
+		t.Error("still down")
+	}
+}
+
+func waitUp(c *check, up int32) bool {
+	for i := 0; i < 100; i++ {
+		if atomic.LoadInt32(&c.up) == up {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
@@ -17,6 +17,18 @@ var (
 
 		"proxy_stop_failed": `
 [ERROR] Swapper proxy failed to stop
+`,
+
+		"node_proxy_invalid": `
+[ERROR] --proxy must be container or builtin
+`,
+
+		"builtin_proxy_unsupported": `
+[ERROR] The builtin proxy does not support %s, start the node with --proxy=container
+`,
+
+		"builtin_proxy_failed": `
+[ERROR] The builtin proxy failed: %s
 `,
 
 		"no_masters_field": `
@@ -25,6 +37,10 @@ var (
 
 		"no_hash_field": `
 [ERROR] Your yaml is invalid. You cannot use "hash" field
+`,
+
+		"no_deployer_field": `
+[ERROR] Your yaml is invalid. You cannot use "deployer" field
 `,
 
 		"no_time_field": `
@@ -61,6 +77,15 @@ Or you can specify its address with following command:
   swapper master start --master master-hostname:1207
 `,
 
+		"node_config_not_found": `
+[ERROR] No node applied %s on this machine. Start a node with:
+  swapper node start --join <master-hostname> --apply <file>
+`,
+
+		"revision_not_found": `
+[ERROR] Revision "%s" of %s not found
+`,
+
 		"cannot_contact_master": `
 [ERROR] Swapper master is not responding!
 `,
@@ -72,6 +97,15 @@ Or you can specify its address with following command:
 
 		"yaml_invalid": `
 [ERROR] Your Yaml file is invalid
+`,
+
+		"compose_invalid": `
+[ERROR] Your docker-compose file is invalid: %s
+`,
+
+		"yaml_schema": `
+[ERROR] Your Yaml file is invalid:
+%s
 `,
 
 		"yaml_name": `
@@ -91,6 +125,14 @@ Or you can specify its address with following command:
   %s
 `,
 
+		"var_required": `
+[ERROR] Missing variable %s: %s
+`,
+
+		"var_file_invalid": `
+[ERROR] Variables file %s is invalid: %s
+`,
+
 		"wrong_port": `
 [ERROR] A swapper master is already running on this machine with this port! You have to specify a new one:
   swapper master start --join %s -p <FREE PORT>
@@ -108,6 +150,14 @@ Or you can specify its address with following command:
 
 		"yaml_version": `
 [ERROR] Yaml Error, unknown version
+`,
+
+		"yaml_include": `
+[ERROR] Yaml file %s is invalid: %s
+`,
+
+		"yaml_migrated": `
+[ERROR] Your Yaml file is already in version 2
 `,
 
 		"service_field_needed": `
@@ -126,9 +176,85 @@ Or you can specify its address with following command:
 [ERROR] Ports cannot be an empty string
 `,
 
+		"file_field_needed": `
+[ERROR] '%s' for file #%d of service '%s' is required or invalid
+`,
+
+		"proxy_invalid": `
+[ERROR] Proxy of service '%s' is invalid: %s
+`,
+
+		"proxy_stats_unavailable": `
+[ERROR] Stats of swapper-proxy are unavailable, is a node running on this machine with swapper-proxy 1.2.0 or later?
+`,
+
+		"proxy_logs_invalid": `
+[ERROR] Proxy logs are invalid: %s
+`,
+
+		"proxy_stats_invalid": `
+[ERROR] Proxy stats are invalid: %s
+`,
+
+		"notification_field_needed": `
+[ERROR] '%s' for notification #%d is required or invalid
+`,
+
+		"notification_failed": `
+[ERROR] Notification to %s failed: %s
+`,
+
+		"secret_bits_invalid": `
+[ERROR] Key size %s is invalid, use at least 2048 bits
+`,
+
+		"secret_key_invalid": `
+[ERROR] %s is not a valid RSA key
+`,
+
+		"secret_decrypt_failed": `
+[ERROR] Cannot decrypt a secret of your yaml, it was encrypted with another public key
+`,
+
+		"secret_public_key_needed": `
+[ERROR] Your yaml contains secret: values, they must be encrypted with a public key. Try same command ended by:
+  --public-key <file>
+`,
+
+		"secret_private_key_needed": `
+[ERROR] Your yaml contains encrypted values, start the node with its private key:
+  swapper node start --private-key <file>
+`,
+
+		"file_source_denied": `
+[ERROR] The source %s of a file inside your yaml is not allowed on this node, allow its directory with:
+  swapper node start --allow-files <directory>
+`,
+
 		"command_failed": `
 [ERROR] A command inside your yaml failed:
 %s
+`,
+
+		"command_denied": `
+[ERROR] A command inside your yaml is not allowed on this node:
+%s
+Allow it with:
+  swapper node start --allow-commands <patterns>
+`,
+
+		"command_shell": `
+[ERROR] A command inside your yaml uses shell syntax, nodes run commands without shell:
+%s
+`,
+
+		"command_timeout": `
+[ERROR] A command inside your yaml timed out:
+%s (after %s)
+`,
+
+		"command_timeout_invalid": `
+[ERROR] Command timeout "%s" is invalid, use a duration like 5s
 `,
 	}
 )
@@ -3,6 +3,7 @@ package utils
 import (
 	"os"
 	"os/exec"
+	"os/user"
 	"reflect"
 	"strings"
 )
@@ -12,6 +13,16 @@ func GetHostname() (hostname string, err error) {
 	return
 }
 
+// GetDeployer returns who is deploying, as user@hostname
+func GetDeployer() string {
+	hostname, _ := GetHostname()
+	current, err := user.Current()
+	if err != nil {
+		return hostname
+	}
+	return current.Username + "@" + hostname
+}
+
 func Command(command string) (string, error) {
 	args := strings.Split(strings.TrimSpace(command), " ")
 	cmd := exec.Command(args[0], args[1:]...)
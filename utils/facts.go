@@ -0,0 +1,244 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultMetadataUrl = "http://169.254.169.254/computeMetadata/v1/"
+
+// Facts resolves the built-in $() expressions of a node, without spawning a shell:
+//
+//      $(hostname), $(fqdn), $(host_ip), $(host_ip:eth0), $(cpu_count), $(memory), $(memory:mb),
+//      $(label:zone), $(metadata:instance/id), $(env:VAR), $(file:/path)
+//
+// Environment variables and files are denied unless they are allowed with --allow-env and --allow-files
+type Facts struct {
+	// Labels are given to the node with --label KEY=VALUE
+	Labels map[string]string
+	// MetadataUrl is the local endpoint of the cloud instance metadata
+	MetadataUrl string
+	// AllowedEnv are the patterns of the variables $(env:VAR) may read (* matches anything)
+	AllowedEnv []string
+	// AllowedFiles are the files, and the directories, $(file:path) may read
+	AllowedFiles []string
+}
+
+// AllowList parses the comma separated values of the --allow-* options
+func AllowList(list string) (values []string) {
+	for _, value := range strings.Split(list, ",") {
+		if strings.TrimSpace(value) != "" {
+			values = append(values, strings.TrimSpace(value))
+		}
+	}
+	return values
+}
+
+// AllowedPath tells if a path is one of the allowed files or is inside one of the allowed directories. Symlinks and ..
+// are resolved first, so that they cannot lead outside of them
+func AllowedPath(path string, allowed []string) bool {
+	resolved := resolvePath(path)
+	if resolved == "" {
+		return false
+	}
+	for _, allowedPath := range allowed {
+		allowedPath = resolvePath(allowedPath)
+		if allowedPath != "" && (resolved == allowedPath || strings.HasPrefix(resolved, strings.TrimSuffix(allowedPath, "/")+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func resolvePath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	return abs
+}
+
+// ParseLabels transforms KEY=VALUE strings to a map
+func ParseLabels(labels []string) (map[string]string, error) {
+	labelMap := map[string]string{}
+	for _, label := range labels {
+		keyValue := strings.SplitN(label, "=", 2)
+		if len(keyValue) != 2 || keyValue[0] == "" {
+			return labelMap, errors.New("label " + label + " must look like KEY=VALUE")
+		}
+		labelMap[keyValue[0]] = keyValue[1]
+	}
+	return labelMap, nil
+}
+
+// Resolve returns the value of a fact, found is false when the name is not a built-in fact
+func (f Facts) Resolve(name string, arg string) (value string, found bool, err error) {
+	switch name {
+	case "hostname":
+		value, err = GetHostname()
+	case "fqdn":
+		value, err = Fqdn()
+	case "host_ip":
+		value, err = HostIp(arg)
+	case "cpu_count":
+		value = strconv.Itoa(runtime.NumCPU())
+	case "memory":
+		value, err = Memory(arg)
+	case "label":
+		label, ok := f.Labels[arg]
+		if ok == false {
+			return "", true, errors.New("node has no label " + arg + ", start it with --label " + arg + "=<value>")
+		}
+		value = label
+	case "metadata":
+		value, err = f.Metadata(arg)
+	case "env":
+		if f.allowsEnv(arg) == false {
+			return "", true, errors.New("environment variable " + arg + " is not allowed on this node, start it with --allow-env " + arg)
+		}
+		env, ok := os.LookupEnv(arg)
+		if ok == false {
+			return "", true, errors.New("environment variable " + arg + " is not set")
+		}
+		value = env
+	case "file":
+		if AllowedPath(arg, f.AllowedFiles) == false {
+			return "", true, errors.New("file " + arg + " is not allowed on this node, start it with --allow-files <directory>")
+		}
+		content, ioErr := ioutil.ReadFile(arg)
+		value, err = string(content), ioErr
+	default:
+		return "", false, nil
+	}
+	return strings.TrimSpace(value), true, err
+}
+
+func (f Facts) allowsEnv(name string) bool {
+	for _, pattern := range f.AllowedEnv {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// HostIp returns the first IPv4 address of an interface, or of the host when the interface is empty, which is not a loopback
+func HostIp(iface string) (string, error) {
+	var addrs []net.Addr
+	var err error
+	if iface == "" {
+		addrs, err = net.InterfaceAddrs()
+	} else {
+		netInterface, ifaceErr := net.InterfaceByName(iface)
+		if ifaceErr != nil {
+			return "", ifaceErr
+		}
+		addrs, err = netInterface.Addrs()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsLoopback() == false && ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+	}
+	return "", errors.New("no IPv4 address found")
+}
+
+// Fqdn returns the fully qualified domain name of the host, or its hostname if it cannot be resolved
+func Fqdn() (string, error) {
+	hostname, err := GetHostname()
+	if err != nil {
+		return "", err
+	}
+	if cname, err := net.LookupCNAME(hostname); err == nil && cname != "" {
+		return strings.TrimSuffix(cname, "."), nil
+	}
+	ips, err := net.LookupHost(hostname)
+	if err != nil {
+		return hostname, nil
+	}
+	for _, ip := range ips {
+		names, err := net.LookupAddr(ip)
+		if err == nil && len(names) > 0 {
+			return strings.TrimSuffix(names[0], "."), nil
+		}
+	}
+	return hostname, nil
+}
+
+// Memory returns the total memory of the host, in bytes or in the given unit (kb, mb, gb)
+func Memory(unit string) (string, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var kb uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err = strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	if kb == 0 {
+		return "", errors.New("cannot read total memory")
+	}
+
+	switch strings.ToLower(unit) {
+	case "":
+		return strconv.FormatUint(kb*1024, 10), nil
+	case "kb":
+		return strconv.FormatUint(kb, 10), nil
+	case "mb":
+		return strconv.FormatUint(kb/1024, 10), nil
+	case "gb":
+		return strconv.FormatUint(kb/1024/1024, 10), nil
+	}
+	return "", errors.New("unknown memory unit " + unit + ", use kb, mb or gb")
+}
+
+// Metadata requests the cloud instance metadata endpoint of the node
+func (f Facts) Metadata(path string) (string, error) {
+	metadataUrl := f.MetadataUrl
+	if metadataUrl == "" {
+		metadataUrl = DefaultMetadataUrl
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(metadataUrl, "/")+"/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("metadata %s answered %s", path, resp.Status))
+	}
+	return string(body), nil
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"zone=europe-west1-b", "query=a=b"})
+	if err != nil || !reflect.DeepEqual(labels, map[string]string{"zone": "europe-west1-b", "query": "a=b"}) {
+		t.Fail()
+	}
+
+	if _, err = ParseLabels([]string{"zone"}); err == nil {
+		t.Fail()
+	}
+}
+
+func TestFactsResolve(t *testing.T) {
+	facts := Facts{Labels: map[string]string{"zone": "europe-west1-b"}}
+
+	value, found, err := facts.Resolve("label", "zone")
+	if value != "europe-west1-b" || found == false || err != nil {
+		t.Fail()
+	}
+	_, found, err = facts.Resolve("label", "role")
+	if found == false || err == nil {
+		t.Fail()
+	}
+
+	value, _, _ = facts.Resolve("cpu_count", "")
+	if value != strconv.Itoa(runtime.NumCPU()) {
+		t.Fail()
+	}
+
+	value, _, err = facts.Resolve("memory", "mb")
+	if err != nil || value == "" || value == "0" {
+		t.Fail()
+	}
+	if _, _, err = facts.Resolve("memory", "tb"); err == nil {
+		t.Fail()
+	}
+
+	value, _, err = facts.Resolve("host_ip", "")
+	if err != nil || value == "" {
+		t.Fail()
+	}
+	if _, _, err = facts.Resolve("host_ip", "unknown0"); err == nil {
+		t.Fail()
+	}
+
+	value, _, err = facts.Resolve("fqdn", "")
+	if err != nil || value == "" {
+		t.Fail()
+	}
+
+	_, found, _ = facts.Resolve("date", "")
+	if found {
+		t.Fail()
+	}
+}
+
+func TestFactsResolveAllowed(t *testing.T) {
+	_ = os.Setenv("SWAPPER_TEST_ZONE", "europe-west1-b")
+	defer os.Unsetenv("SWAPPER_TEST_ZONE")
+	dir, _ := ioutil.TempDir("", "swapper-facts")
+	defer os.RemoveAll(dir)
+	_ = ioutil.WriteFile(dir+"/zone", []byte("europe-west1-b\n"), 0644)
+
+	// environment variables and files are denied by default
+	facts := Facts{}
+	if _, found, err := facts.Resolve("env", "SWAPPER_TEST_ZONE"); found == false || err == nil {
+		t.Error(err)
+	}
+	if _, found, err := facts.Resolve("file", dir+"/zone"); found == false || err == nil {
+		t.Error(err)
+	}
+
+	facts = Facts{AllowedEnv: AllowList("SWAPPER_TEST_*, HOME"), AllowedFiles: AllowList(dir)}
+	if value, _, err := facts.Resolve("env", "SWAPPER_TEST_ZONE"); value != "europe-west1-b" || err != nil {
+		t.Error(value, err)
+	}
+	if value, _, err := facts.Resolve("file", dir+"/zone"); value != "europe-west1-b" || err != nil {
+		t.Error(value, err)
+	}
+	if _, _, err := facts.Resolve("file", dir+"/../../etc/passwd"); err == nil {
+		t.Fail()
+	}
+}
+
+func TestAllowedPath(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "swapper-allowed")
+	defer os.RemoveAll(dir)
+	_ = os.Mkdir(dir+"/ssl", 0755)
+	_ = ioutil.WriteFile(dir+"/ssl/cert.pem", []byte("cert"), 0644)
+	_ = ioutil.WriteFile(dir+"/secret", []byte("secret"), 0644)
+	_ = os.Symlink(dir+"/secret", dir+"/ssl/link")
+
+	allowed := []string{dir + "/ssl/"}
+	if AllowedPath(dir+"/ssl/cert.pem", allowed) == false || AllowedPath(dir+"/ssl", allowed) == false {
+		t.Fail()
+	}
+	for _, path := range []string{dir + "/secret", dir + "/ssl/../secret", dir + "/ssl/link", dir + "/sslx/cert.pem"} {
+		if AllowedPath(path, allowed) {
+			t.Error(path)
+		}
+	}
+	if AllowedPath(dir+"/secret", nil) || AllowedPath(dir+"/secret", []string{dir + "/secret"}) == false {
+		t.Fail()
+	}
+}
+
+func TestFactsMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/computeMetadata/v1/instance/zone" || r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("projects/123/zones/europe-west1-b\n"))
+	}))
+	defer server.Close()
+
+	facts := Facts{MetadataUrl: server.URL + "/computeMetadata/v1/"}
+	value, found, err := facts.Resolve("metadata", "instance/zone")
+	if value != "projects/123/zones/europe-west1-b" || found == false || err != nil {
+		t.Fail()
+	}
+
+	if _, _, err = facts.Resolve("metadata", "instance/unknown"); err == nil {
+		t.Fail()
+	}
+}
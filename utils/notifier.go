@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/yaml"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	EventDeploy      = "deploy"
+	EventNodeUpdated = "node-updated"
+	EventNodeFailed  = "node-failed"
+	EventRollback    = "rollback"
+)
+
+// Event is what a Notifier sends to its sink. Its fields are also available in notification templates
+type Event struct {
+	Name     string
+	Success  bool
+	Message  string
+	File     string
+	OldHash  string
+	NewHash  string
+	Changes  []yaml.ImageChange
+	Deployer string
+	Hostname string
+	Duration time.Duration
+}
+
+// Summary describes the image changes of the event, one line per container
+//
+// Example:
+//      api: nginx 1.16.0 → 1.17.0 on node-3 in 12s
+func (e Event) Summary() string {
+	var lines []string
+	for _, change := range e.Changes {
+		var line string
+		if change.OldImage == "" {
+			line = change.Service + ": " + change.NewImage + " " + change.NewTag + " added"
+		} else if change.NewImage == "" {
+			line = change.Service + ": " + change.OldImage + " " + change.OldTag + " removed"
+		} else if change.OldImage == change.NewImage {
+			line = change.Service + ": " + change.NewImage + " " + change.OldTag + " → " + change.NewTag
+		} else {
+			line = change.Service + ": " + change.OldImage + ":" + change.OldTag + " → " + change.NewImage + ":" + change.NewTag
+		}
+		if e.Hostname != "" {
+			line = line + " on " + e.Hostname
+		}
+		if e.Duration != 0 {
+			line = line + " in " + e.Duration.Round(time.Second).String()
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Notifier is implemented by every notification sink (slack, webhook, teams, mattermost, smtp...)
+type Notifier interface {
+	Notify(event Event) error
+}
+
+type SlackNotifier struct {
+	WebHookUrl string
+	Channel    string
+}
+
+func (n SlackNotifier) Notify(event Event) error {
+	return SlackPush(event.Message, eventColor(event), n.Channel, n.WebHookUrl)
+}
+
+type MattermostNotifier struct {
+	WebHookUrl string
+	Channel    string
+}
+
+type MattermostRequestBody struct {
+	Channel     string            `json:"channel,omitempty"`
+	Username    string            `json:"username"`
+	Emoji       string            `json:"icon_emoji"`
+	Attachments []SlackAttachment `json:"attachments"`
+}
+
+func (n MattermostNotifier) Notify(event Event) error {
+	hostname, _ := GetHostname()
+	body, _ := json.Marshal(MattermostRequestBody{
+		Channel:     n.Channel,
+		Username:    "swapper",
+		Emoji:       ":robot_face:",
+		Attachments: []SlackAttachment{{Text: "**" + hostname + "**\n" + event.Message, Color: eventColor(event)}},
+	})
+	return PostJSON(n.WebHookUrl, body, nil)
+}
+
+type TeamsNotifier struct {
+	WebHookUrl string
+}
+
+type TeamsRequestBody struct {
+	Type       string `json:"@type"`
+	Context    string `json:"@context"`
+	ThemeColor string `json:"themeColor"`
+	Title      string `json:"title"`
+	Text       string `json:"text"`
+}
+
+func (n TeamsNotifier) Notify(event Event) error {
+	hostname, _ := GetHostname()
+	body, _ := json.Marshal(TeamsRequestBody{
+		Type:       "MessageCard",
+		Context:    "http://schema.org/extensions",
+		ThemeColor: strings.TrimPrefix(eventColor(event), "#"),
+		Title:      "swapper - " + hostname,
+		Text:       event.Message,
+	})
+	return PostJSON(n.WebHookUrl, body, nil)
+}
+
+type WebhookNotifier struct {
+	Url     string
+	Headers map[string]string
+}
+
+type WebhookRequestBody struct {
+	Event    string `json:"event"`
+	Success  bool   `json:"success"`
+	Message  string `json:"message"`
+	Hostname string `json:"hostname"`
+}
+
+func (n WebhookNotifier) Notify(event Event) error {
+	hostname, _ := GetHostname()
+	body, _ := json.Marshal(WebhookRequestBody{
+		Event:    event.Name,
+		Success:  event.Success,
+		Message:  event.Message,
+		Hostname: hostname,
+	})
+	return PostJSON(n.Url, body, n.Headers)
+}
+
+// TemplateNotifier renders the event message with a text/template before handing it to its Notifier
+type TemplateNotifier struct {
+	Notifier Notifier
+	Template string
+}
+
+func (n TemplateNotifier) Notify(event Event) error {
+	tmpl, err := template.New("notification").Parse(n.Template)
+	if err != nil {
+		return err
+	}
+	var message bytes.Buffer
+	if err = tmpl.Execute(&message, event); err != nil {
+		return err
+	}
+	event.Message = message.String()
+	return n.Notifier.Notify(event)
+}
+
+// RetryNotifier retries a Notifier, doubling the backoff between each attempt
+type RetryNotifier struct {
+	Notifier Notifier
+	Retries  int
+	Backoff  time.Duration
+}
+
+func (n RetryNotifier) Notify(event Event) (err error) {
+	backoff := n.Backoff
+	for attempt := 0; attempt <= n.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff = backoff * 2
+		}
+		err = n.Notifier.Notify(event)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Notifiers returns the notifiers of the yaml configuration that listen to the given event
+func Notifiers(eventName string, yamlConf yaml.YamlConf) (notifiers []Notifier) {
+	if yamlConf.Slack.WebHookUrl != "" && yamlConf.Slack.Channel != "" {
+		notifiers = append(notifiers, SlackNotifier{WebHookUrl: yamlConf.Slack.WebHookUrl, Channel: yamlConf.Slack.Channel})
+	}
+
+	for _, notification := range yamlConf.Notifications {
+		if listenTo(eventName, notification.Events) == false {
+			continue
+		}
+
+		var notifier Notifier
+		switch notification.Type {
+		case "slack":
+			notifier = SlackNotifier{WebHookUrl: notification.Url, Channel: notification.Channel}
+		case "mattermost":
+			notifier = MattermostNotifier{WebHookUrl: notification.Url, Channel: notification.Channel}
+		case "teams":
+			notifier = TeamsNotifier{WebHookUrl: notification.Url}
+		case "webhook":
+			notifier = WebhookNotifier{Url: notification.Url, Headers: notification.Headers}
+		case "smtp":
+			notifier = SmtpNotifier{
+				Server:     notification.Smtp.Server,
+				Port:       notification.Smtp.Port,
+				StartTLS:   notification.Smtp.StartTLS,
+				Username:   notification.Smtp.Username,
+				Password:   notification.Smtp.Password,
+				From:       notification.Smtp.From,
+				Recipients: notification.Smtp.Recipients,
+			}
+		default:
+			continue
+		}
+
+		if notification.Template != "" {
+			notifier = TemplateNotifier{Notifier: notifier, Template: notification.Template}
+		}
+
+		if notification.Retries > 0 {
+			backoff := time.Second
+			if notification.Backoff != "" {
+				backoff, _ = time.ParseDuration(notification.Backoff)
+			}
+			notifier = RetryNotifier{Notifier: notifier, Retries: notification.Retries, Backoff: backoff}
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return notifiers
+}
+
+// Notify sends the event to every notifier of the yaml configuration, and returns the last error
+func Notify(event Event, yamlConf yaml.YamlConf) (err error) {
+	if event.Hostname == "" {
+		event.Hostname, _ = GetHostname()
+	}
+	if event.NewHash == "" {
+		event.NewHash = yamlConf.Hash
+	}
+	if event.Deployer == "" {
+		event.Deployer = yamlConf.Deployer
+	}
+	for _, notifier := range Notifiers(event.Name, yamlConf) {
+		if errNotify := notifier.Notify(event); errNotify != nil {
+			err = errNotify
+		}
+	}
+	return err
+}
+
+func PostJSON(url string, body []byte, headers map[string]string) (err error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["request_failed"], err))
+	}
+	req.Header.Add("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["notification_failed"], url, resp.Status))
+	}
+	return nil
+}
+
+func listenTo(eventName string, events []string) bool {
+	if len(events) == 0 {
+		return true
+	}
+	for _, e := range events {
+		if e == eventName {
+			return true
+		}
+	}
+	return false
+}
+
+func eventColor(event Event) string {
+	if event.Success {
+		return "#00FF00"
+	}
+	return "#D0021B"
+}
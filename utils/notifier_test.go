@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"encoding/json"
+	"github.com/sachamorard/swapper/yaml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var body WebhookRequestBody
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		data, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+	}))
+	defer server.Close()
+
+	notifier := WebhookNotifier{Url: server.URL, Headers: map[string]string{"Authorization": "Bearer mytoken"}}
+	err := notifier.Notify(Event{Name: EventDeploy, Success: true, Message: "ok"})
+	if err != nil {
+		t.Fail()
+	}
+	if authorization != "Bearer mytoken" || body.Event != EventDeploy || body.Message != "ok" || body.Success != true {
+		t.Fail()
+	}
+}
+
+func TestRetryNotifier(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(500)
+		}
+	}))
+	defer server.Close()
+
+	notifier := RetryNotifier{Notifier: WebhookNotifier{Url: server.URL}, Retries: 3, Backoff: time.Millisecond}
+	err := notifier.Notify(Event{Name: EventDeploy, Message: "ok"})
+	if err != nil || calls != 3 {
+		t.Fail()
+	}
+
+	calls = -10
+	notifier = RetryNotifier{Notifier: WebhookNotifier{Url: server.URL}, Retries: 1, Backoff: time.Millisecond}
+	err = notifier.Notify(Event{Name: EventDeploy, Message: "ok"})
+	if err == nil || calls != -8 {
+		t.Fail()
+	}
+}
+
+func TestNotifiers(t *testing.T) {
+	yamlConf := yaml.YamlConf{
+		Slack: yaml.Slack{WebHookUrl: "https://hooks.slack.com/services/T0/B0/00", Channel: "test"},
+		Notifications: []yaml.Notification{
+			{Type: "teams", Url: "https://outlook.office.com/webhook/00", Events: []string{EventNodeFailed}},
+			{Type: "mattermost", Url: "https://mattermost.example.com/hooks/00"},
+			{Type: "webhook", Url: "https://example.com/hooks", Retries: 2, Backoff: "1s"},
+		},
+	}
+
+	notifiers := Notifiers(EventDeploy, yamlConf)
+	if len(notifiers) != 3 {
+		t.Fail()
+	}
+	if _, ok := notifiers[0].(SlackNotifier); !ok {
+		t.Fail()
+	}
+	if retry, ok := notifiers[2].(RetryNotifier); !ok || retry.Retries != 2 || retry.Backoff != time.Second {
+		t.Fail()
+	}
+
+	notifiers = Notifiers(EventNodeFailed, yamlConf)
+	if len(notifiers) != 4 {
+		t.Fail()
+	}
+}
+
+func TestNotify(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	yamlConf := yaml.YamlConf{
+		Notifications: []yaml.Notification{
+			{Type: "teams", Url: server.URL, Events: []string{EventRollback}},
+			{Type: "mattermost", Url: server.URL},
+			{Type: "webhook", Url: server.URL, Events: []string{EventDeploy}},
+		},
+	}
+	err := Notify(Event{Name: EventDeploy, Success: true, Message: "ok"}, yamlConf)
+	if err != nil || calls != 2 {
+		t.Fail()
+	}
+}
+
+func TestEventSummary(t *testing.T) {
+	event := Event{
+		Hostname: "node-3",
+		Duration: 12300 * time.Millisecond,
+		Changes: []yaml.ImageChange{
+			{Service: "api", OldImage: "nginx", OldTag: "1.16.0", NewImage: "nginx", NewTag: "1.17.0"},
+			{Service: "db", NewImage: "mysql", NewTag: "8"},
+		},
+	}
+	if event.Summary() != "api: nginx 1.16.0 → 1.17.0 on node-3 in 12s\ndb: mysql 8 added on node-3 in 12s" {
+		t.Fail()
+	}
+}
+
+func TestTemplateNotifier(t *testing.T) {
+	var body WebhookRequestBody
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+	}))
+	defer server.Close()
+
+	notifier := TemplateNotifier{Notifier: WebhookNotifier{Url: server.URL}, Template: "{{.File}} {{.OldHash}}..{{.NewHash}} by {{.Deployer}}: {{.Summary}}"}
+	event := Event{
+		Name:     EventNodeUpdated,
+		File:     "myapp.yml",
+		OldHash:  "aaa",
+		NewHash:  "bbb",
+		Deployer: "john@laptop",
+		Changes:  []yaml.ImageChange{{Service: "api", OldImage: "nginx", OldTag: "1.16.0", NewImage: "nginx", NewTag: "1.17.0"}},
+	}
+	err := notifier.Notify(event)
+	if err != nil || body.Message != "myapp.yml aaa..bbb by john@laptop: api: nginx 1.16.0 → 1.17.0" {
+		t.Fail()
+	}
+
+	notifier = TemplateNotifier{Notifier: WebhookNotifier{Url: server.URL}, Template: "{{.Unknown}}"}
+	if notifier.Notify(event) == nil {
+		t.Fail()
+	}
+}
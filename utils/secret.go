@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"github.com/sachamorard/swapper/yaml"
+	"io/ioutil"
+)
+
+// GenerateKeyPair creates the RSA key pair of secrets, PEM encoded. Only nodes should hold the private key
+func GenerateKeyPair(bits int) (privatePEM []byte, publicPEM []byte, err error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return privatePEM, publicPEM, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return privatePEM, publicPEM, err
+	}
+	privatePEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	publicPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	return privatePEM, publicPEM, nil
+}
+
+// ReadPublicKey reads a PEM encoded RSA public key
+func ReadPublicKey(file string) (*rsa.PublicKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if err != nil || ok == false {
+		return nil, errors.New(fmt.Sprintf(response.ErrorMessages["secret_key_invalid"], file))
+	}
+	return publicKey, nil
+}
+
+// ReadPrivateKey reads a PEM encoded RSA private key (PKCS#1 or PKCS#8)
+func ReadPrivateKey(file string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if err != nil || ok == false {
+		return nil, errors.New(fmt.Sprintf(response.ErrorMessages["secret_key_invalid"], file))
+	}
+	return privateKey, nil
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf(response.ErrorMessages["file_not_exist"], file))
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New(fmt.Sprintf(response.ErrorMessages["secret_key_invalid"], file))
+	}
+	return block, nil
+}
+
+// EncryptSecret encrypts a value with a random AES-256-GCM key, itself encrypted with RSA-OAEP.
+// The result is enc:fingerprint:base64(encrypted key + nonce + ciphertext), the fingerprint of the plaintext keeps
+// the hash of a configuration when its secrets do not change
+func EncryptSecret(publicKey *rsa.PublicKey, plaintext string) (string, error) {
+	fingerprint, err := secretFingerprint(publicKey, plaintext)
+	if err != nil {
+		return "", err
+	}
+
+	key := make([]byte, 32)
+	if _, err = rand.Read(key); err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, key, nil)
+	if err != nil {
+		return "", err
+	}
+
+	data := append(encryptedKey, nonce...)
+	data = gcm.Seal(data, nonce, []byte(plaintext), nil)
+	return yaml.EncryptedPrefix + fingerprint + ":" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// secretFingerprint is the start of an HMAC-SHA256 of the plaintext, keyed with the public key
+func secretFingerprint(publicKey *rsa.PublicKey, plaintext string) (string, error) {
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, publicDER)
+	mac.Write([]byte(plaintext))
+	return hex.EncodeToString(mac.Sum(nil))[:16], nil
+}
+
+// DecryptSecret decrypts an enc: value
+func DecryptSecret(privateKey *rsa.PrivateKey, value string) (string, error) {
+	_, ciphertext := yaml.SplitEncrypted(value)
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil || len(data) < privateKey.Size() {
+		return "", errors.New(response.ErrorMessages["secret_decrypt_failed"])
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, data[:privateKey.Size()], nil)
+	if err != nil {
+		return "", errors.New(response.ErrorMessages["secret_decrypt_failed"])
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	data = data[privateKey.Size():]
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New(response.ErrorMessages["secret_decrypt_failed"])
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New(response.ErrorMessages["secret_decrypt_failed"])
+	}
+	return string(plaintext), nil
+}
+
+// RotateSecrets re-encrypts all the enc: values of a yaml file with a new public key
+func RotateSecrets(input string, privateKey *rsa.PrivateKey, publicKey *rsa.PublicKey) (output string, count int, err error) {
+	output = yaml.EncryptedRegexp.ReplaceAllStringFunc(input, func(value string) string {
+		if err != nil {
+			return value
+		}
+		var plaintext string
+		plaintext, err = DecryptSecret(privateKey, value)
+		if err != nil {
+			return value
+		}
+		count++
+		var encrypted string
+		encrypted, err = EncryptSecret(publicKey, plaintext)
+		return encrypted
+	})
+	return output, count, err
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
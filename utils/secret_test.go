@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"github.com/sachamorard/swapper/yaml"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func writeTestKeys(t *testing.T, name string) {
+	privatePEM, publicPEM, err := GenerateKeyPair(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = ioutil.WriteFile("/tmp/"+name+".pem", privatePEM, 0600)
+	_ = ioutil.WriteFile("/tmp/"+name+".pub", publicPEM, 0644)
+}
+
+func TestEncryptSecret(t *testing.T) {
+	writeTestKeys(t, "swapper-test")
+	writeTestKeys(t, "swapper-test-2")
+	publicKey, err := ReadPublicKey("/tmp/swapper-test.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := ReadPrivateKey("/tmp/swapper-test.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := EncryptSecret(publicKey, "p@ss=word")
+	if err != nil || strings.HasPrefix(encrypted, "enc:") == false || strings.Contains(encrypted, "p@ss") {
+		t.Fail()
+	}
+	plaintext, err := DecryptSecret(privateKey, encrypted)
+	if err != nil || plaintext != "p@ss=word" {
+		t.Fail()
+	}
+
+	// the same plaintext gets another ciphertext, with the same fingerprint
+	again, _ := EncryptSecret(publicKey, "p@ss=word")
+	fingerprint, ciphertext := yaml.SplitEncrypted(encrypted)
+	if again == encrypted || len(fingerprint) != 16 || strings.HasPrefix(again, "enc:"+fingerprint+":") == false || ciphertext == "" {
+		t.Error(encrypted, again)
+	}
+	if other, _ := EncryptSecret(publicKey, "other"); strings.HasPrefix(other, "enc:"+fingerprint+":") {
+		t.Error(other)
+	}
+
+	otherKey, _ := ReadPrivateKey("/tmp/swapper-test-2.pem")
+	if _, err = DecryptSecret(otherKey, encrypted); err == nil {
+		t.Fail()
+	}
+	if _, err = DecryptSecret(privateKey, "enc:bm9wZQ=="); err == nil {
+		t.Fail()
+	}
+
+	if _, err = ReadPublicKey("/tmp/swapper-test.pem"); err == nil {
+		t.Fail()
+	}
+	if _, err = ReadPrivateKey("secret.go"); err == nil {
+		t.Fail()
+	}
+}
+
+func TestRotateSecrets(t *testing.T) {
+	writeTestKeys(t, "swapper-test")
+	writeTestKeys(t, "swapper-test-2")
+	publicKey, _ := ReadPublicKey("/tmp/swapper-test.pub")
+	privateKey, _ := ReadPrivateKey("/tmp/swapper-test.pem")
+	newPublicKey, _ := ReadPublicKey("/tmp/swapper-test-2.pub")
+	newPrivateKey, _ := ReadPrivateKey("/tmp/swapper-test-2.pem")
+
+	first, _ := EncryptSecret(publicKey, "first")
+	second, _ := EncryptSecret(publicKey, "second")
+	input := "environment:\n  A: " + first + "\n  B: '" + second + "'\n  C: plain\n"
+
+	output, count, err := RotateSecrets(input, privateKey, newPublicKey)
+	if err != nil || count != 2 || strings.Contains(output, first) || strings.Contains(output, "C: plain\n") == false {
+		t.Fail()
+	}
+	values := yaml.EncryptedRegexp.FindAllString(output, -1)
+	if len(values) != 2 {
+		t.Fatal(values)
+	}
+	if plaintext, _ := DecryptSecret(newPrivateKey, values[1]); plaintext != "second" {
+		t.Fail()
+	}
+
+	if _, _, err = RotateSecrets(output, privateKey, newPublicKey); err == nil {
+		t.Fail()
+	}
+}
@@ -27,14 +27,16 @@ type SlackAttachment struct {
 
 func SlackSendSuccess(message string, yamlConf yaml.YamlConf) (err error) {
 	if yamlConf.Slack.WebHookUrl != "" && yamlConf.Slack.Channel != "" {
-		return SlackPush(message, "#00FF00", yamlConf.Slack.Channel, yamlConf.Slack.WebHookUrl)
+		notifier := SlackNotifier{WebHookUrl: yamlConf.Slack.WebHookUrl, Channel: yamlConf.Slack.Channel}
+		return notifier.Notify(Event{Success: true, Message: message})
 	}
 	return nil
 }
 
 func SlackSendError(message string, yamlConf yaml.YamlConf) (err error) {
 	if yamlConf.Slack.WebHookUrl != "" && yamlConf.Slack.Channel != "" {
-		return SlackPush(message, "#D0021B", yamlConf.Slack.Channel, yamlConf.Slack.WebHookUrl)
+		notifier := SlackNotifier{WebHookUrl: yamlConf.Slack.WebHookUrl, Channel: yamlConf.Slack.Channel}
+		return notifier.Notify(Event{Success: false, Message: message})
 	}
 	return nil
 }
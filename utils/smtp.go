@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SmtpNotifier sends events by email, to the recipients of the event
+type SmtpNotifier struct {
+	Server     string
+	Port       int
+	StartTLS   bool
+	Username   string
+	Password   string
+	From       string
+	Recipients map[string][]string
+}
+
+func (n SmtpNotifier) Notify(event Event) error {
+	recipients := n.Recipients[event.Name]
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	addr := net.JoinHostPort(n.Server, strconv.Itoa(n.Port))
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["notification_failed"], addr, err))
+	}
+	client, err := smtp.NewClient(conn, n.Server)
+	if err != nil {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["notification_failed"], addr, err))
+	}
+	defer client.Close()
+
+	if n.StartTLS {
+		if err = client.StartTLS(&tls.Config{ServerName: n.Server}); err != nil {
+			return errors.New(fmt.Sprintf(response.ErrorMessages["notification_failed"], addr, err))
+		}
+	}
+
+	if n.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", n.Username, n.Password, n.Server)); err != nil {
+			return errors.New(fmt.Sprintf(response.ErrorMessages["notification_failed"], addr, err))
+		}
+	}
+
+	if err = client.Mail(n.From); err != nil {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["notification_failed"], addr, err))
+	}
+	for _, recipient := range recipients {
+		if err = client.Rcpt(recipient); err != nil {
+			return errors.New(fmt.Sprintf(response.ErrorMessages["notification_failed"], addr, err))
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["notification_failed"], addr, err))
+	}
+	if _, err = w.Write(SmtpMessage(n.From, recipients, event)); err != nil {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["notification_failed"], addr, err))
+	}
+	if err = w.Close(); err != nil {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["notification_failed"], addr, err))
+	}
+
+	return client.Quit()
+}
+
+// SmtpMessage builds the email of an event
+func SmtpMessage(from string, recipients []string, event Event) []byte {
+	status := "succeed"
+	if event.Success == false {
+		status = "failed"
+	}
+	subject := "[swapper] " + event.Name + " " + status
+	if event.Hostname != "" {
+		subject = subject + " on " + event.Hostname
+	}
+
+	var headers []string
+	headers = append(headers, "From: "+from)
+	headers = append(headers, "To: "+strings.Join(recipients, ", "))
+	headers = append(headers, "Subject: "+subject)
+	headers = append(headers, "Date: "+time.Now().Format(time.RFC1123Z))
+	headers = append(headers, "MIME-Version: 1.0")
+	headers = append(headers, "Content-Type: text/plain; charset=utf-8")
+
+	var body []string
+	body = append(body, event.Message)
+	body = append(body, "")
+	if event.File != "" {
+		body = append(body, "File: "+event.File)
+	}
+	if event.OldHash != "" {
+		body = append(body, "Previous hash: "+event.OldHash)
+	}
+	if event.NewHash != "" {
+		body = append(body, "Hash: "+event.NewHash)
+	}
+	if event.Deployer != "" {
+		body = append(body, "Deployer: "+event.Deployer)
+	}
+	if event.Hostname != "" {
+		body = append(body, "Node: "+event.Hostname)
+	}
+
+	message := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.Join(body, "\r\n") + "\r\n"
+	return []byte(message)
+}
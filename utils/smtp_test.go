@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeSmtpServer accepts one mail and sends its envelope and data on the returned channel
+func fakeSmtpServer(t *testing.T) (port int, mails chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mails = make(chan string, 1)
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		write := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		write("220 localhost ESMTP")
+
+		var mail []string
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if inData {
+				if line == "." {
+					inData = false
+					write("250 OK")
+					continue
+				}
+				mail = append(mail, line)
+				continue
+			}
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch command {
+			case "EHLO", "HELO":
+				write("250 localhost")
+			case "MAIL", "RCPT":
+				mail = append(mail, line)
+				write("250 OK")
+			case "DATA":
+				inData = true
+				write("354 End data with <CR><LF>.<CR><LF>")
+			case "QUIT":
+				write("221 Bye")
+				mails <- strings.Join(mail, "\n")
+				return
+			default:
+				write("502 Not implemented")
+			}
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, mails
+}
+
+func TestSmtpNotifier(t *testing.T) {
+	port, mails := fakeSmtpServer(t)
+
+	notifier := SmtpNotifier{
+		Server:     "127.0.0.1",
+		Port:       port,
+		From:       "swapper@example.com",
+		Recipients: map[string][]string{EventNodeFailed: {"ops@example.com", "dev@example.com"}},
+	}
+
+	// no recipients for this event, nothing is sent
+	err := notifier.Notify(Event{Name: EventDeploy, Message: "deployed"})
+	if err != nil {
+		t.Fail()
+	}
+
+	err = notifier.Notify(Event{Name: EventNodeFailed, Message: "Node failed to update", File: "myapp.yml", Hostname: "node-3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail := <-mails
+	if strings.Contains(mail, "MAIL FROM:<swapper@example.com>") == false ||
+		strings.Contains(mail, "RCPT TO:<ops@example.com>") == false ||
+		strings.Contains(mail, "RCPT TO:<dev@example.com>") == false ||
+		strings.Contains(mail, "Subject: [swapper] node-failed failed on node-3") == false ||
+		strings.Contains(mail, "Node failed to update") == false ||
+		strings.Contains(mail, "File: myapp.yml") == false {
+		t.Fail()
+	}
+}
+
+func TestSmtpNotifierFailure(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	notifier := SmtpNotifier{Server: "127.0.0.1", Port: port, From: "swapper@example.com", Recipients: map[string][]string{EventDeploy: {"ops@example.com"}}}
+	err := notifier.Notify(Event{Name: EventDeploy, Message: "deployed"})
+	if err == nil || strings.Contains(err.Error(), "127.0.0.1:"+strconv.Itoa(port)) == false {
+		t.Fail()
+	}
+}
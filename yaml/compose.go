@@ -0,0 +1,333 @@
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	yamlv3 "gopkg.in/yaml.v3"
+	"net"
+	"strconv"
+	"strings"
+)
+
+type convertedYaml struct {
+	Version  string      `yaml:"version"`
+	Services yamlv3.Node `yaml:"services"`
+}
+
+type convertedService struct {
+	Ports       []string             `yaml:"ports"`
+	EnvFile     []string             `yaml:"env_file,omitempty"`
+	Environment map[string]string    `yaml:"environment,omitempty"`
+	Containers  []convertedContainer `yaml:"containers"`
+}
+
+type convertedContainer struct {
+	Image          string            `yaml:"image"`
+	Tag            string            `yaml:"tag"`
+	Logging        *convertedLogging `yaml:"logging,omitempty"`
+	HealthCmd      string            `yaml:"health-cmd,omitempty"`
+	HealthInterval string            `yaml:"health-interval,omitempty"`
+	HealthTimeout  string            `yaml:"health-timeout,omitempty"`
+	HealthRetries  int               `yaml:"health-retries,omitempty"`
+	ExtraHosts     []string          `yaml:"extra_hosts,omitempty"`
+	Files          []convertedFile   `yaml:"files,omitempty"`
+}
+
+type convertedLogging struct {
+	Driver  string            `yaml:"driver,omitempty"`
+	Options map[string]string `yaml:"options,omitempty"`
+}
+
+type convertedFile struct {
+	Path   string `yaml:"path"`
+	Source string `yaml:"source"`
+}
+
+// ConvertCompose converts a docker-compose file into the swapper v1 format. Unsupported lists what has no swapper
+// equivalent, and was not converted or converted approximately.
+func ConvertCompose(input string) (output string, unsupported []string, err error) {
+	var document yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(input), &document); err != nil {
+		return output, unsupported, errors.New(fmt.Sprintf(response.ErrorMessages["compose_invalid"], err.Error()))
+	}
+	if len(document.Content) == 0 || mappingValue(document.Content[0], "services") == nil {
+		return output, unsupported, errors.New(fmt.Sprintf(response.ErrorMessages["compose_invalid"], "no services"))
+	}
+
+	for _, pair := range mappingPairs(document.Content[0]) {
+		if pair[0].Value != "version" && pair[0].Value != "services" && strings.HasPrefix(pair[0].Value, "x-") == false {
+			unsupported = append(unsupported, pair[0].Value)
+		}
+	}
+
+	converted := convertedYaml{Version: "1", Services: yamlv3.Node{Kind: yamlv3.MappingNode}}
+	for _, pair := range mappingPairs(mappingValue(document.Content[0], "services")) {
+		service, notes := convertComposeService(pair[0].Value, pair[1])
+		unsupported = append(unsupported, notes...)
+		if len(service.Ports) == 0 {
+			unsupported = append(unsupported, "services."+pair[0].Value+": no published port, swapper only runs services behind its proxy")
+			continue
+		}
+		var serviceNode yamlv3.Node
+		if err := serviceNode.Encode(service); err != nil {
+			return output, unsupported, err
+		}
+		converted.Services.Content = append(converted.Services.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Value: pair[0].Value}, &serviceNode)
+	}
+
+	var buffer bytes.Buffer
+	encoder := yamlv3.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(converted); err != nil {
+		return output, unsupported, err
+	}
+	_ = encoder.Close()
+	return buffer.String(), unsupported, nil
+}
+
+func convertComposeService(name string, node *yamlv3.Node) (service convertedService, unsupported []string) {
+	path := "services." + name
+	container := convertedContainer{}
+	replicas := 1
+
+	for _, pair := range mappingPairs(node) {
+		key, value := pair[0].Value, resolveAlias(pair[1])
+		switch key {
+		case "image":
+			container.Image, container.Tag = splitImage(value.Value)
+			if container.Tag == "" {
+				unsupported = append(unsupported, path+".image: digests are not supported, set a tag")
+			}
+		case "ports":
+			for i, port := range value.Content {
+				converted, note := convertComposePort(resolveAlias(port))
+				if note != "" {
+					unsupported = append(unsupported, path+".ports["+strconv.Itoa(i)+"]: "+note)
+				}
+				if converted != "" {
+					service.Ports = append(service.Ports, converted)
+				}
+			}
+		case "environment":
+			var unset []string
+			service.Environment, unset = keyValues(value)
+			// a variable without value comes from the environment of docker-compose, a deploy variable in swapper
+			for _, k := range unset {
+				service.Environment[k] = "${" + k + "}"
+			}
+		case "env_file":
+			for _, envFile := range scalarOrSequence(value) {
+				if envFile.Kind == yamlv3.MappingNode {
+					envFile = mappingValue(envFile, "path")
+				}
+				service.EnvFile = append(service.EnvFile, scalarValue(envFile))
+			}
+		case "logging":
+			container.Logging = &convertedLogging{Driver: scalarValue(mappingValue(value, "driver"))}
+			if options := mappingValue(value, "options"); options != nil {
+				container.Logging.Options, _ = keyValues(options)
+			}
+		case "healthcheck":
+			unsupported = append(unsupported, convertHealthcheck(path, value, &container)...)
+		case "extra_hosts":
+			if value.Kind == yamlv3.SequenceNode {
+				for _, host := range value.Content {
+					container.ExtraHosts = append(container.ExtraHosts, strings.Replace(host.Value, "=", ":", 1))
+				}
+			}
+			for _, host := range mappingPairs(value) {
+				container.ExtraHosts = append(container.ExtraHosts, host[0].Value+":"+host[1].Value)
+			}
+		case "volumes":
+			for i, volume := range value.Content {
+				file, note := convertComposeVolume(resolveAlias(volume))
+				if note != "" {
+					unsupported = append(unsupported, path+".volumes["+strconv.Itoa(i)+"]: "+note)
+				}
+				if file.Path != "" {
+					container.Files = append(container.Files, file)
+				}
+			}
+		case "deploy":
+			for _, deploy := range mappingPairs(value) {
+				if deploy[0].Value == "replicas" {
+					replicas, _ = strconv.Atoi(deploy[1].Value)
+				} else {
+					unsupported = append(unsupported, path+".deploy."+deploy[0].Value)
+				}
+			}
+		default:
+			unsupported = append(unsupported, path+"."+key)
+		}
+	}
+
+	if container.Image == "" {
+		unsupported = append(unsupported, path+": no image, build it and push it to a registry")
+	}
+	for i := 0; i < replicas; i++ {
+		service.Containers = append(service.Containers, container)
+	}
+	return service, unsupported
+}
+
+// splitImage returns the name and the tag of an image, the tag is empty for digests
+func splitImage(image string) (name string, tag string) {
+	if i := strings.Index(image, "@"); i != -1 {
+		return image[:i], ""
+	}
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		return image[:colon], image[colon+1:]
+	}
+	return image, "latest"
+}
+
+// convertComposePort converts the short ([ip:]host:container[/protocol]) and long syntaxes of compose ports, swapper
+// only publishes on ipv4 addresses
+func convertComposePort(port *yamlv3.Node) (converted string, note string) {
+	var ip, published, target, protocol string
+	if port.Kind == yamlv3.MappingNode {
+		ip = scalarValue(mappingValue(port, "host_ip"))
+		published = scalarValue(mappingValue(port, "published"))
+		target = scalarValue(mappingValue(port, "target"))
+		protocol = scalarValue(mappingValue(port, "protocol"))
+	} else {
+		value := port.Value
+		if i := strings.Index(value, "/"); i != -1 {
+			value, protocol = value[:i], value[i+1:]
+		}
+		parts := strings.Split(value, ":")
+		target = parts[len(parts)-1]
+		if len(parts) > 1 {
+			published = parts[len(parts)-2]
+		}
+		if len(parts) > 2 {
+			ip = strings.Trim(strings.Join(parts[:len(parts)-2], ":"), "[]")
+		}
+	}
+
+	if protocol != "" && protocol != ProtocolTcp && protocol != ProtocolUdp {
+		return "", protocol + " ports are not supported"
+	}
+	if published == "" {
+		published = target
+		note = "random published ports are not supported, published on " + target
+	}
+	converted = published + ":" + target
+	if ip != "" && ip != "0.0.0.0" {
+		if net.ParseIP(ip).To4() != nil {
+			converted = ip + ":" + converted
+		} else {
+			note = "published on all interfaces instead of " + ip
+		}
+	}
+	if protocol == ProtocolUdp {
+		converted = converted + "/" + ProtocolUdp
+	}
+	if _, err := ParsePort(converted); err != nil {
+		return "", "port " + converted + " " + err.Error()
+	}
+	return converted, note
+}
+
+// convertComposeVolume converts the bind mounts of absolute paths of the node, swapper mounts them read-only
+func convertComposeVolume(volume *yamlv3.Node) (file convertedFile, note string) {
+	readOnly := false
+	if volume.Kind == yamlv3.MappingNode {
+		file.Source = scalarValue(mappingValue(volume, "source"))
+		file.Path = scalarValue(mappingValue(volume, "target"))
+		readOnly = scalarValue(mappingValue(volume, "read_only")) == "true"
+		if volumeType := scalarValue(mappingValue(volume, "type")); volumeType != "bind" {
+			return convertedFile{}, volumeType + " volumes are not supported"
+		}
+	} else {
+		parts := strings.Split(volume.Value, ":")
+		if len(parts) < 2 {
+			return convertedFile{}, "anonymous volumes are not supported"
+		}
+		file.Source, file.Path = parts[0], parts[1]
+		readOnly = len(parts) > 2 && strings.Contains(parts[2], "ro")
+	}
+
+	if strings.HasPrefix(file.Source, "/") == false {
+		if strings.HasPrefix(file.Source, ".") || strings.HasPrefix(file.Source, "~") {
+			return convertedFile{}, "relative bind mounts are not supported, use an absolute path of the node"
+		}
+		return convertedFile{}, "named volumes are not supported"
+	}
+	if readOnly == false {
+		note = "mounted read-only"
+	}
+	return file, note
+}
+
+func convertHealthcheck(path string, healthcheck *yamlv3.Node, container *convertedContainer) (unsupported []string) {
+	if scalarValue(mappingValue(healthcheck, "disable")) == "true" {
+		return nil
+	}
+	for _, pair := range mappingPairs(healthcheck) {
+		value := resolveAlias(pair[1])
+		switch pair[0].Value {
+		case "test":
+			if value.Kind == yamlv3.ScalarNode {
+				container.HealthCmd = value.Value
+				continue
+			}
+			var args []string
+			for _, arg := range value.Content {
+				args = append(args, arg.Value)
+			}
+			if len(args) > 1 && args[0] == "CMD-SHELL" {
+				container.HealthCmd = args[1]
+			} else if len(args) > 1 && args[0] == "CMD" {
+				container.HealthCmd = strings.Join(args[1:], " ")
+			}
+		case "interval":
+			container.HealthInterval = value.Value
+		case "timeout":
+			container.HealthTimeout = value.Value
+		case "retries":
+			container.HealthRetries, _ = strconv.Atoi(value.Value)
+		default:
+			unsupported = append(unsupported, path+".healthcheck."+pair[0].Value)
+		}
+	}
+	return unsupported
+}
+
+// keyValues reads a mapping, or a sequence of "key=value" items. Unset lists the keys without value
+func keyValues(node *yamlv3.Node) (values map[string]string, unset []string) {
+	values = map[string]string{}
+	node = resolveAlias(node)
+	if node == nil {
+		return values, unset
+	}
+	if node.Kind == yamlv3.SequenceNode {
+		for _, item := range node.Content {
+			pair := strings.SplitN(item.Value, "=", 2)
+			if len(pair) == 1 {
+				unset = append(unset, pair[0])
+				values[pair[0]] = ""
+			} else {
+				values[pair[0]] = pair[1]
+			}
+		}
+		return values, unset
+	}
+	for _, pair := range mappingPairs(node) {
+		values[pair[0].Value] = resolveAlias(pair[1]).Value
+		if resolveAlias(pair[1]).ShortTag() == "!!null" {
+			unset = append(unset, pair[0].Value)
+			values[pair[0].Value] = ""
+		}
+	}
+	return values, unset
+}
+
+func scalarOrSequence(node *yamlv3.Node) []*yamlv3.Node {
+	if node.Kind == yamlv3.SequenceNode {
+		return node.Content
+	}
+	return []*yamlv3.Node{node}
+}
@@ -0,0 +1,99 @@
+package yaml
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestConvertCompose(t *testing.T) {
+	input, _ := ioutil.ReadFile("tests/compose/docker-compose.yml")
+	output, unsupported, err := ConvertCompose(string(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"volumes",
+		"services.web.container_name",
+		"services.web.ports[3]: published on all interfaces instead of ::1",
+		"services.web.ports[4]: sctp ports are not supported",
+		"services.web.healthcheck.start_period",
+		"services.web.volumes[1]: relative bind mounts are not supported, use an absolute path of the node",
+		"services.web.volumes[2]: named volumes are not supported",
+		"services.web.depends_on",
+		"services.redis: no published port, swapper only runs services behind its proxy",
+	}
+	if !reflect.DeepEqual(unsupported, expected) {
+		t.Error(unsupported)
+	}
+
+	if problems := ValidateString(output, []string{"SECRET_KEY=s3cr3t"}); len(problems) != 0 {
+		t.Errorf("%v\n%s", problems, output)
+	}
+	yamlConf, err := ParseSwapperYaml(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(yamlConf.Services) != 1 || len(yamlConf.Services[0].Containers) != 2 {
+		t.Fatal(output)
+	}
+	container := yamlConf.Services[0].Containers[0]
+	if container.Image != "registry.example.com:5000/my-app" || container.Tag != "1.0.2" || container.Envs["RATIO"] != "1.50" {
+		t.Error(container)
+	}
+	if container.HealthCmd != "curl -f http://localhost" || container.HealthRetries != 3 || container.LoggingDriver != "fluentd" {
+		t.Error(container)
+	}
+	if len(container.Files) != 1 || container.Files[0].Source != "/etc/ssl/certs/my-app.crt" || container.ExtraHosts[0] != "db.internal:10.0.0.2" {
+		t.Error(container)
+	}
+	if !reflect.DeepEqual(yamlConf.Services[0].Ports, []string{"80:8080", "127.0.0.1:443:8443", "53:53/udp", "8081:8081"}) {
+		t.Error(yamlConf.Services[0].Ports)
+	}
+
+	_, _, err = ConvertCompose("version: '3'\nimage: nginx")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["compose_invalid"], "no services") {
+		t.Error(err)
+	}
+}
+
+func TestConvertComposePort(t *testing.T) {
+	ports := map[string]string{
+		"80":                            "80:80",
+		"8080:80":                       "8080:80",
+		"8080:80/tcp":                   "8080:80",
+		"0.0.0.0:8080:80":               "8080:80",
+		"127.0.0.1:8080:80":             "127.0.0.1:8080:80",
+		"53:53/udp":                     "53:53/udp",
+		"8000-8010:9000-9010":           "8000-8010:9000-9010",
+		"8000-8010:80":                  "",
+		"{target: 80, published: 8080}": "8080:80",
+	}
+	for port, expected := range ports {
+		var document yamlv3.Node
+		_ = yamlv3.Unmarshal([]byte(port), &document)
+		converted, _ := convertComposePort(document.Content[0])
+		if converted != expected {
+			t.Errorf("%s: %s", port, converted)
+		}
+	}
+}
+
+func TestSplitImage(t *testing.T) {
+	images := map[string][2]string{
+		"nginx":                         {"nginx", "latest"},
+		"nginx:1.17":                    {"nginx", "1.17"},
+		"localhost:5000/nginx":          {"localhost:5000/nginx", "latest"},
+		"nginx@sha256:0123456789abcdef": {"nginx", ""},
+	}
+	for image, expected := range images {
+		name, tag := splitImage(image)
+		if name != expected[0] || tag != expected[1] {
+			t.Errorf("%s: %s %s", image, name, tag)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package yaml
+
+import (
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ActionAdd    = "+"
+	ActionRemove = "-"
+	ActionChange = "~"
+)
+
+// Change is one difference between two yaml configurations
+type Change struct {
+	// Service is empty for the changes outside services
+	Service string
+	// Path of the changed field inside the service (or the file), empty when the whole service is added or removed
+	Path   string
+	Action string
+	Old    string
+	New    string
+	// Disruptive explains why the change interrupts the traffic, it is empty for zero-downtime changes
+	Disruptive string
+}
+
+func (c Change) String() string {
+	var line string
+	switch c.Action {
+	case ActionAdd:
+		line = c.Action + " " + c.Path + ": " + c.New
+	case ActionRemove:
+		line = c.Action + " " + c.Path + ": " + c.Old
+	default:
+		line = c.Action + " " + c.Path + ": " + c.Old + " → " + c.New
+	}
+	if c.Disruptive != "" {
+		line = line + "   [DISRUPTIVE] " + c.Disruptive
+	}
+	return line
+}
+
+// Diff computes the semantic differences between two yaml configurations, service by service
+func Diff(oldConf YamlConf, newConf YamlConf) (changes []Change) {
+	if oldConf.Master.Driver != "" {
+		changes = append(changes, diffValue("", "master.driver", oldConf.Master.Driver, newConf.Master.Driver)...)
+		changes = append(changes, diffValue("", "master.project-id", oldConf.Master.ProjectId, newConf.Master.ProjectId)...)
+	}
+	changes = append(changes, diffValue("", "notifications", notificationsSummary(oldConf), notificationsSummary(newConf))...)
+	for _, change := range diffValue("", "proxy-logs", proxyLogsSummary(oldConf.ProxyLogs), proxyLogsSummary(newConf.ProxyLogs)) {
+		if len(oldConf.Services) != 0 && oldConf.ProxyLogs.Path != newConf.ProxyLogs.Path {
+			change.Disruptive = "log directory change recreates swapper-proxy with a short interruption"
+		}
+		changes = append(changes, change)
+	}
+	for _, change := range diffValue("", "proxy-stats", proxyStatsSummary(oldConf.ProxyStats), proxyStatsSummary(newConf.ProxyStats)) {
+		if len(oldConf.Services) != 0 && oldConf.ProxyStats.Binding() != newConf.ProxyStats.Binding() {
+			change.Disruptive = "stats port change recreates swapper-proxy with a short interruption"
+		}
+		changes = append(changes, change)
+	}
+
+	oldServices := map[string]Service{}
+	for _, service := range oldConf.Services {
+		oldServices[service.Name] = service
+	}
+	newServices := map[string]Service{}
+	for _, service := range newConf.Services {
+		newServices[service.Name] = service
+	}
+
+	// A new frontend port is not published by the running swapper-proxy, which has to be recreated
+	oldPublished := map[string]bool{}
+	for _, frontend := range oldConf.Frontends {
+		oldPublished[frontend.Published()] = true
+	}
+	disruptive := func(binding string) string {
+		port, err := ParsePort(binding)
+		if len(oldConf.Services) == 0 || err != nil || oldPublished[port.Published()] {
+			return ""
+		}
+		return "new frontend port " + strings.TrimSuffix(port.Published(), "/"+ProtocolTcp) + " recreates swapper-proxy with a short interruption"
+	}
+
+	for _, name := range serviceNames(oldConf, newConf) {
+		oldService, existed := oldServices[name]
+		newService, exists := newServices[name]
+		if existed == false {
+			change := Change{Service: name, Action: ActionAdd, New: strings.Join(newService.Ports, ", ")}
+			for _, port := range newService.Ports {
+				if reason := disruptive(port); reason != "" {
+					change.Disruptive = reason
+				}
+			}
+			changes = append(changes, change)
+			continue
+		}
+		if exists == false {
+			changes = append(changes, Change{Service: name, Action: ActionRemove, Old: strings.Join(oldService.Ports, ", ")})
+			continue
+		}
+
+		for _, change := range diffList(name, "ports", oldService.Ports, newService.Ports) {
+			if change.Action == ActionAdd {
+				change.Disruptive = disruptive(change.New)
+			}
+			changes = append(changes, change)
+		}
+		changes = append(changes, diffValue(name, "proxy", proxySummary(oldService.Proxy), proxySummary(newService.Proxy))...)
+
+		for i := 0; i < len(oldService.Containers) || i < len(newService.Containers); i++ {
+			path := "containers[" + strconv.Itoa(i) + "]"
+			if i >= len(newService.Containers) {
+				changes = append(changes, Change{Service: name, Path: path, Action: ActionRemove, Old: oldService.Containers[i].Image + ":" + oldService.Containers[i].Tag})
+				continue
+			}
+			if i >= len(oldService.Containers) {
+				changes = append(changes, Change{Service: name, Path: path, Action: ActionAdd, New: newService.Containers[i].Image + ":" + newService.Containers[i].Tag})
+				continue
+			}
+			changes = append(changes, diffContainer(name, path, oldService.Containers[i], newService.Containers[i])...)
+		}
+	}
+	return changes
+}
+
+func diffContainer(service string, path string, oldContainer Container, newContainer Container) (changes []Change) {
+	changes = append(changes, diffValue(service, path+".image", oldContainer.Image, newContainer.Image)...)
+	changes = append(changes, diffValue(service, path+".tag", oldContainer.Tag, newContainer.Tag)...)
+	changes = append(changes, diffValue(service, path+".weight", strconv.Itoa(oldContainer.Weight), strconv.Itoa(newContainer.Weight))...)
+	changes = append(changes, diffMap(service, path+".environment", oldContainer.Envs, newContainer.Envs)...)
+	changes = append(changes, diffValue(service, path+".logging.driver", oldContainer.LoggingDriver, newContainer.LoggingDriver)...)
+	changes = append(changes, diffMap(service, path+".logging.options", oldContainer.LoggingOptions, newContainer.LoggingOptions)...)
+	changes = append(changes, diffValue(service, path+".health-cmd", oldContainer.HealthCmd, newContainer.HealthCmd)...)
+	changes = append(changes, diffValue(service, path+".health-interval", oldContainer.HealthInterval, newContainer.HealthInterval)...)
+	changes = append(changes, diffValue(service, path+".health-timeout", oldContainer.HealthTimeout, newContainer.HealthTimeout)...)
+	changes = append(changes, diffValue(service, path+".health-retries", retriesString(oldContainer.HealthRetries), retriesString(newContainer.HealthRetries))...)
+	changes = append(changes, diffList(service, path+".extra_hosts", interfacesToStrings(oldContainer.ExtraHosts), interfacesToStrings(newContainer.ExtraHosts))...)
+	changes = append(changes, diffList(service, path+".files", filesToStrings(oldContainer.Files), filesToStrings(newContainer.Files))...)
+	return changes
+}
+
+func diffValue(service string, path string, oldValue string, newValue string) []Change {
+	if oldValue == newValue {
+		return nil
+	}
+	if oldValue == "" {
+		return []Change{{Service: service, Path: path, Action: ActionAdd, New: newValue}}
+	}
+	if newValue == "" {
+		return []Change{{Service: service, Path: path, Action: ActionRemove, Old: oldValue}}
+	}
+	return []Change{{Service: service, Path: path, Action: ActionChange, Old: oldValue, New: newValue}}
+}
+
+func diffMap(service string, path string, oldMap map[interface{}]interface{}, newMap map[interface{}]interface{}) (changes []Change) {
+	keys := map[string]bool{}
+	oldValues := map[string]string{}
+	newValues := map[string]string{}
+	for k, v := range oldMap {
+		keys[fmt.Sprint(k)] = true
+		oldValues[fmt.Sprint(k)] = fmt.Sprint(v)
+	}
+	for k, v := range newMap {
+		keys[fmt.Sprint(k)] = true
+		newValues[fmt.Sprint(k)] = fmt.Sprint(v)
+	}
+	var sortedKeys []string
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	for _, k := range sortedKeys {
+		oldValue, existed := oldValues[k]
+		newValue, exists := newValues[k]
+		changed, known := compareSecrets(oldValue, newValue)
+		oldValue, newValue = maskSecret(oldValue), maskSecret(newValue)
+		if known == false {
+			newValue = "(secret may have changed)"
+		}
+		if existed == false {
+			changes = append(changes, Change{Service: service, Path: path + "." + k, Action: ActionAdd, New: newValue})
+		} else if exists == false {
+			changes = append(changes, Change{Service: service, Path: path + "." + k, Action: ActionRemove, Old: oldValue})
+		} else if changed {
+			changes = append(changes, Change{Service: service, Path: path + "." + k, Action: ActionChange, Old: oldValue, New: newValue})
+		}
+	}
+	return changes
+}
+
+func diffList(service string, path string, oldList []string, newList []string) (changes []Change) {
+	oldSet := map[string]bool{}
+	for _, item := range oldList {
+		oldSet[item] = true
+	}
+	newSet := map[string]bool{}
+	for _, item := range newList {
+		newSet[item] = true
+	}
+	for _, item := range oldList {
+		if newSet[item] == false {
+			changes = append(changes, Change{Service: service, Path: path, Action: ActionRemove, Old: item})
+		}
+	}
+	for _, item := range newList {
+		if oldSet[item] == false {
+			changes = append(changes, Change{Service: service, Path: path, Action: ActionAdd, New: item})
+		}
+	}
+	return changes
+}
+
+// compareSecrets compares the values of a map, the encrypted ones by the fingerprint of their plaintext as their
+// ciphertext changes on each encryption. The change is unknown when a secret has no fingerprint to compare.
+func compareSecrets(oldValue string, newValue string) (changed bool, known bool) {
+	if oldValue == newValue {
+		return false, true
+	}
+	isSecret := func(value string) bool {
+		return strings.HasPrefix(value, SecretPrefix) || strings.HasPrefix(value, EncryptedPrefix)
+	}
+	if isSecret(oldValue) == false || isSecret(newValue) == false {
+		return true, true
+	}
+	if strings.HasPrefix(oldValue, SecretPrefix) && strings.HasPrefix(newValue, SecretPrefix) {
+		return true, true
+	}
+	oldFingerprint, _ := SplitEncrypted(oldValue)
+	newFingerprint, _ := SplitEncrypted(newValue)
+	if strings.HasPrefix(oldValue, EncryptedPrefix) == false || strings.HasPrefix(newValue, EncryptedPrefix) == false || oldFingerprint == "" || newFingerprint == "" {
+		return true, false
+	}
+	return oldFingerprint != newFingerprint, true
+}
+
+// maskSecret hides the secret: and enc: values
+func maskSecret(value string) string {
+	if strings.HasPrefix(value, SecretPrefix) || strings.HasPrefix(value, EncryptedPrefix) {
+		return "(secret)"
+	}
+	return value
+}
+
+func serviceNames(confs ...YamlConf) (names []string) {
+	seen := map[string]bool{}
+	for _, conf := range confs {
+		for _, service := range conf.Services {
+			if seen[service.Name] == false {
+				seen[service.Name] = true
+				names = append(names, service.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func notificationsSummary(conf YamlConf) string {
+	var types []string
+	if conf.Slack.WebHookUrl != "" {
+		types = append(types, "slack")
+	}
+	for _, notification := range conf.Notifications {
+		types = append(types, notification.Type)
+	}
+	return strings.Join(types, ", ")
+}
+
+// proxyLogsSummary describes where the access logs go
+func proxyLogsSummary(logs ProxyLogs) string {
+	return strings.TrimSpace(strings.Join([]string{logs.Destination, logs.Address, logs.Path, logs.Facility}, " "))
+}
+
+// proxyStatsSummary describes the stats dashboard, without its password
+func proxyStatsSummary(stats ProxyStats) string {
+	if stats.Port == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(stats.Binding().Published(), "/"+ProtocolTcp) + " (" + stats.Username + ")"
+}
+
+func retriesString(retries int) string {
+	if retries == 0 {
+		return ""
+	}
+	return strconv.Itoa(retries)
+}
+
+func interfacesToStrings(values []interface{}) (strs []string) {
+	for _, v := range values {
+		strs = append(strs, fmt.Sprint(v))
+	}
+	return strs
+}
+
+// filesToStrings summarizes the files of a container, without their content
+func filesToStrings(files []File) (strs []string) {
+	for _, file := range files {
+		str := file.Path
+		if file.Source != "" {
+			str = str + " <- " + file.Source
+		} else if file.Secret != "" {
+			str = str + " (secret)"
+		} else {
+			str = str + fmt.Sprintf(" (content %x)", md5.Sum([]byte(file.Content)))
+		}
+		if file.Mode != DefaultFileMode {
+			str = str + fmt.Sprintf(" mode %04o", file.Mode)
+		}
+		strs = append(strs, str)
+	}
+	return strs
+}
@@ -0,0 +1,163 @@
+package yaml
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	oldConf, err := ParseSwapperYaml(`
+version: "1"
+services:
+  api:
+    ports:
+      - 80:80
+    containers:
+      - image: nginx
+        tag: 1.16.0
+        environment:
+          ENV: prod
+          DEBUG: "0"
+      - image: nginx
+        tag: 1.16.0
+  old:
+    ports:
+      - 81:80
+    containers:
+      - image: redis
+        tag: "5"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConf, err := ParseSwapperYaml(`
+version: "1"
+services:
+  api:
+    ports:
+      - 80:80
+      - 443:443
+      - 80:80/udp
+    proxy:
+      balance: source
+    containers:
+      - image: nginx
+        tag: 1.17.0
+        environment:
+          ENV: prod
+          LOG: info
+  new:
+    ports:
+      - 81:80
+    containers:
+      - image: mysql
+        tag: "8"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := Diff(oldConf, newConf)
+	expected := []Change{
+		{Service: "api", Path: "ports", Action: ActionAdd, New: "443:443", Disruptive: "new frontend port 443 recreates swapper-proxy with a short interruption"},
+		{Service: "api", Path: "ports", Action: ActionAdd, New: "80:80/udp", Disruptive: "new frontend port 80/udp recreates swapper-proxy with a short interruption"},
+		{Service: "api", Path: "proxy", Action: ActionAdd, New: "balance=source"},
+		{Service: "api", Path: "containers[0].tag", Action: ActionChange, Old: "1.16.0", New: "1.17.0"},
+		{Service: "api", Path: "containers[0].environment.DEBUG", Action: ActionRemove, Old: "0"},
+		{Service: "api", Path: "containers[0].environment.LOG", Action: ActionAdd, New: "info"},
+		{Service: "api", Path: "containers[1]", Action: ActionRemove, Old: "nginx:1.16.0"},
+		{Service: "new", Action: ActionAdd, New: "81:80"},
+		{Service: "old", Action: ActionRemove, Old: "81:80"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("unexpected changes: %v", changes)
+	}
+
+	if len(Diff(newConf, newConf)) != 0 {
+		t.Fail()
+	}
+
+	// nothing is deployed yet, so no port can interrupt the traffic
+	for _, change := range Diff(YamlConf{}, newConf) {
+		if change.Action != ActionAdd || change.Disruptive != "" {
+			t.Fail()
+		}
+	}
+}
+
+func TestChangeString(t *testing.T) {
+	change := Change{Service: "api", Path: "containers[0].tag", Action: ActionChange, Old: "1.16.0", New: "1.17.0"}
+	if change.String() != "~ containers[0].tag: 1.16.0 → 1.17.0" {
+		t.Fail()
+	}
+	change = Change{Service: "api", Path: "ports", Action: ActionAdd, New: "443:443", Disruptive: "new frontend port"}
+	if change.String() != "+ ports: 443:443   [DISRUPTIVE] new frontend port" {
+		t.Fail()
+	}
+}
+
+func TestDiffMasksSecrets(t *testing.T) {
+	oldConf := YamlConf{Services: []Service{{Name: "api", Containers: []Container{{Image: "nginx", Tag: "1", Envs: map[interface{}]interface{}{"DB_PASSWORD": "enc:b2xk"}}}}}}
+	newConf := YamlConf{Services: []Service{{Name: "api", Containers: []Container{{Image: "nginx", Tag: "1", Envs: map[interface{}]interface{}{"DB_PASSWORD": "secret:p@ss"}}}}}}
+
+	// a plaintext cannot be compared with an encrypted value
+	changes := Diff(oldConf, newConf)
+	expected := []Change{{Service: "api", Path: "containers[0].environment.DB_PASSWORD", Action: ActionChange, Old: "(secret)", New: "(secret may have changed)"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Error(changes)
+	}
+
+	// encrypted values are compared by their fingerprint
+	oldConf.Services[0].Containers[0].Envs["DB_PASSWORD"] = "enc:0123456789abcdef:b2xk"
+	newConf.Services[0].Containers[0].Envs["DB_PASSWORD"] = "enc:0123456789abcdef:bmV3"
+	if changes = Diff(oldConf, newConf); len(changes) != 0 {
+		t.Error(changes)
+	}
+	newConf.Services[0].Containers[0].Envs["DB_PASSWORD"] = "enc:fedcba9876543210:bmV3"
+	expected[0].New = "(secret)"
+	if changes = Diff(oldConf, newConf); !reflect.DeepEqual(changes, expected) {
+		t.Error(changes)
+	}
+}
+
+func TestDiffFiles(t *testing.T) {
+	oldConf := YamlConf{Services: []Service{{Name: "api", Containers: []Container{{Image: "nginx", Tag: "1", Files: []File{
+		{Path: "/etc/nginx/nginx.conf", Content: "worker_processes 1;", Mode: DefaultFileMode},
+		{Path: "/etc/nginx/my-app.key", Secret: "enc:b2xk", Mode: 0400},
+	}}}}}}
+	newConf := YamlConf{Services: []Service{{Name: "api", Containers: []Container{{Image: "nginx", Tag: "1", Files: []File{
+		{Path: "/etc/nginx/nginx.conf", Content: "worker_processes 2;", Mode: DefaultFileMode},
+		{Path: "/etc/nginx/my-app.key", Secret: "enc:bmV3", Mode: 0400},
+	}}}}}}
+
+	// the secret is not compared, its encryption changes on each deploy
+	changes := Diff(oldConf, newConf)
+	expected := []Change{
+		{Service: "api", Path: "containers[0].files", Action: ActionRemove, Old: "/etc/nginx/nginx.conf (content ccb15b69d2f2fc3e6de23b1fc8db8ac2)"},
+		{Service: "api", Path: "containers[0].files", Action: ActionAdd, New: "/etc/nginx/nginx.conf (content df394589b2e070b7ec01c5997050dd3d)"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Error(changes)
+	}
+
+	newConf.Services[0].Containers[0].Files[1].Mode = 0440
+	changes = Diff(oldConf, newConf)
+	if len(changes) != 4 || changes[3].New != "/etc/nginx/my-app.key (secret) mode 0440" {
+		t.Error(changes)
+	}
+}
+
+func TestDiffProxyStats(t *testing.T) {
+	service := Service{Name: "api", Ports: []string{"80:80"}}
+	oldConf := YamlConf{Services: []Service{service}}
+	newConf := YamlConf{Services: []Service{service}, ProxyStats: ProxyStats{Port: 8404, Username: "admin", Password: "s3cr3t"}}
+	expected := []Change{{Path: "proxy-stats", Action: ActionAdd, New: "8404 (admin)", Disruptive: "stats port change recreates swapper-proxy with a short interruption"}}
+	if changes := Diff(oldConf, newConf); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("unexpected changes: %v", changes)
+	}
+	// passwords are never shown
+	newConf.ProxyStats.Password = "changed"
+	if changes := Diff(YamlConf{Services: []Service{service}, ProxyStats: newConf.ProxyStats}, newConf); len(changes) != 0 {
+		t.Error(changes)
+	}
+}
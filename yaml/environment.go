@@ -0,0 +1,170 @@
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	yamlv3 "gopkg.in/yaml.v3"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ResolveEnvironment inlines the env_file of services and containers into their environment (explicit values win,
+// and later files win over earlier ones), and quotes the float and null values of environments so that they are
+// passed to containers as written. Relative env files are read from dir. The input is returned untouched when
+// there is nothing to resolve.
+func ResolveEnvironment(input string, dir string) (output string, err error) {
+	var document yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(input), &document); err != nil {
+		return output, errors.New(fmt.Sprintf(response.ErrorMessages["yaml_schema"], err.Error()))
+	}
+	if len(document.Content) == 0 {
+		return input, nil
+	}
+
+	changed := false
+	services := mappingValue(document.Content[0], "services")
+	for _, service := range mappingPairs(services) {
+		nodes := []*yamlv3.Node{service[1]}
+		if containers := mappingValue(service[1], "containers"); containers != nil && containers.Kind == yamlv3.SequenceNode {
+			nodes = append(nodes, containers.Content...)
+		}
+		for _, node := range nodes {
+			resolved, err := resolveEnvFiles(resolveAlias(node), dir)
+			if err != nil {
+				return output, err
+			}
+			changed = resolved || changed
+			changed = quoteEnvironment(mappingValue(node, "environment")) || changed
+		}
+	}
+	if changed == false {
+		return input, nil
+	}
+
+	var buffer bytes.Buffer
+	encoder := yamlv3.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return output, err
+	}
+	_ = encoder.Close()
+	return buffer.String(), nil
+}
+
+// resolveEnvFiles replaces the env_file of a service or a container by the values it defines, env_file must be an
+// array of file names, as in the schema
+func resolveEnvFiles(node *yamlv3.Node, dir string) (resolved bool, err error) {
+	if node == nil || node.Kind != yamlv3.MappingNode {
+		return false, nil
+	}
+	index := -1
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "env_file" {
+			index = i
+		}
+	}
+	if index == -1 {
+		return false, nil
+	}
+
+	envFiles := resolveAlias(node.Content[index+1])
+	if envFiles.Kind != yamlv3.SequenceNode {
+		return false, envFileError(envFiles, "env_file must be an array, got %s", kindName(envFiles))
+	}
+	var names []string
+	values := map[string]string{}
+	for _, envFile := range envFiles.Content {
+		envFile = resolveAlias(envFile)
+		if envFile.Kind != yamlv3.ScalarNode {
+			return false, envFileError(envFile, "files of env_file must be strings, got %s", kindName(envFile))
+		}
+		file := envFile.Value
+		if filepath.IsAbs(file) == false {
+			file = filepath.Join(dir, file)
+		}
+		vars, err := ReadVarFile(file)
+		if err != nil {
+			return false, err
+		}
+		for _, v := range vars {
+			pair := strings.SplitN(v, "=", 2)
+			if _, exists := values[pair[0]]; exists == false {
+				names = append(names, pair[0])
+			}
+			values[pair[0]] = pair[1]
+		}
+	}
+	node.Content = append(node.Content[:index], node.Content[index+2:]...)
+
+	environment := mappingValue(node, "environment")
+	if environment == nil {
+		environment = &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+		node.Content = append(node.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: "environment"}, environment)
+	}
+	for _, name := range names {
+		if mappingValue(environment, name) != nil {
+			continue
+		}
+		environment.Content = append(environment.Content,
+			&yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: name},
+			&yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: values[name]})
+	}
+	return true, nil
+}
+
+func envFileError(node *yamlv3.Node, format string, args ...interface{}) error {
+	problem := Problem{Line: node.Line, Column: node.Column, Level: LevelError, Message: fmt.Sprintf(format, args...)}
+	return errors.New(fmt.Sprintf(response.ErrorMessages["yaml_schema"], problem.String()))
+}
+
+// quoteEnvironment turns the float and null values of an environment into strings, as written
+func quoteEnvironment(environment *yamlv3.Node) (quoted bool) {
+	for _, pair := range mappingPairs(environment) {
+		value := resolveAlias(pair[1])
+		if value.Kind != yamlv3.ScalarNode {
+			continue
+		}
+		switch value.ShortTag() {
+		case "!!null":
+			value.Value = ""
+		case "!!float":
+		default:
+			continue
+		}
+		value.Tag = "!!str"
+		value.Style = 0
+		quoted = true
+	}
+	return quoted
+}
+
+// mergeEnvironment merges the environment of a service with the one of its container, which wins, and returns
+// string values
+func mergeEnvironment(serviceEnvs map[interface{}]interface{}, containerEnvs map[interface{}]interface{}) (envs map[interface{}]interface{}) {
+	if len(serviceEnvs) == 0 && len(containerEnvs) == 0 {
+		return nil
+	}
+	envs = map[interface{}]interface{}{}
+	for _, source := range []map[interface{}]interface{}{serviceEnvs, containerEnvs} {
+		for k, v := range source {
+			envs[fmt.Sprint(k)] = envString(v)
+		}
+	}
+	return envs
+}
+
+func envString(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
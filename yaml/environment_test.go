@@ -0,0 +1,79 @@
+package yaml
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveEnvironment(t *testing.T) {
+	cleanYaml, err := PrepareSwapperYaml("tests/v1/valid.4.yml", []string{"TAG=1.0.2", "SCALE=1.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(cleanYaml, "env_file") {
+		t.Errorf("env files should be inlined: %s", cleanYaml)
+	}
+
+	yamlConf, err := ParseSwapperYaml(cleanYaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containers := yamlConf.Services[0].Containers
+	expected := map[interface{}]interface{}{
+		"RATIO":     "1.50",
+		"EMPTY":     "",
+		"TAG":       "1.0.2",
+		"SCALE":     "1.10",
+		"LOG_LEVEL": "debug",
+		"REGION":    "europe-west1",
+		"DB_HOST":   "db-2.internal",
+	}
+	if !reflect.DeepEqual(containers[0].Envs, expected) {
+		t.Error(containers[0].Envs)
+	}
+	expected = map[interface{}]interface{}{
+		"RATIO":     "2",
+		"EMPTY":     "",
+		"TAG":       "1.0.2",
+		"SCALE":     "1.10",
+		"LOG_LEVEL": "info",
+		"REGION":    "europe-west1",
+	}
+	if !reflect.DeepEqual(containers[1].Envs, expected) {
+		t.Error(containers[1].Envs)
+	}
+
+	// nothing to resolve, the yaml is untouched
+	input := "version: '1'\nservices:\n  api:\n    environment:\n      ENV: prod\n"
+	output, err := ResolveEnvironment(input, "tests")
+	if err != nil || output != input {
+		t.Fail()
+	}
+
+	_, err = ResolveEnvironment("services:\n  api:\n    env_file:\n      - unknown.env\n", "tests")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["file_not_exist"], "tests/unknown.env") {
+		t.Error(err)
+	}
+
+	// env_file is never dropped silently
+	_, err = ResolveEnvironment("services:\n  api:\n    env_file: vars.env\n", "tests")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["yaml_schema"], "3:15: error: env_file must be an array, got str \"vars.env\"") {
+		t.Error(err)
+	}
+	_, err = ResolveEnvironment("services:\n  api:\n    env_file:\n      - path: vars.env\n", "tests")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["yaml_schema"], "4:9: error: files of env_file must be strings, got an object") {
+		t.Error(err)
+	}
+}
+
+func TestEnvString(t *testing.T) {
+	values := map[interface{}]string{nil: "", "a": "a", 12: "12", true: "true", 1.5: "1.5", 1e21: "1000000000000000000000"}
+	for v, expected := range values {
+		if envString(v) != expected {
+			t.Errorf("%v: %s", v, envString(v))
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	includeRegexp  = regexp.MustCompile(`^include\s*:`)
+	templateRegexp = regexp.MustCompile(`(?m)^x-[^:]*:`)
+)
+
+// fragment is a yaml file of a composition, and the line of the composition before its first line
+type fragment struct {
+	file   string
+	input  string
+	offset int
+}
+
+// composition is a yaml file and the files it includes, in a single yaml text where each file is an item of a
+// sequence, so that the anchors of included files can be used by the next ones
+type composition struct {
+	fragments []fragment
+	text      string
+}
+
+// ResolveIncludes merges the files included by a yaml file into it, and expands the x- templates and the merge keys
+// (<<) that use them, so that the output is self-contained. The input is returned untouched when it includes nothing
+// and has no template.
+func ResolveIncludes(sourceFile string, input string) (output string, err error) {
+	if HasIncludes(input) == false && templateRegexp.MatchString(input) == false {
+		return input, nil
+	}
+	c, err := compose(sourceFile, input)
+	if err != nil {
+		return output, err
+	}
+	root, err := c.parse(c.text)
+	if err != nil {
+		return output, err
+	}
+
+	expanded := expandNode(root)
+	for i := 0; i+1 < len(expanded.Content); {
+		if strings.HasPrefix(expanded.Content[i].Value, "x-") {
+			expanded.Content = append(expanded.Content[:i], expanded.Content[i+2:]...)
+			continue
+		}
+		i += 2
+	}
+
+	var buffer bytes.Buffer
+	encoder := yamlv3.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(expanded); err != nil {
+		return output, err
+	}
+	_ = encoder.Close()
+	return buffer.String(), nil
+}
+
+// HasIncludes tells whether a yaml file has an include section
+func HasIncludes(input string) bool {
+	for _, line := range strings.Split(input, "\n") {
+		if includeRegexp.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// compose reads the files included by a yaml file, recursively. Included files come before the files including them,
+// and a file included twice is only read once.
+func compose(sourceFile string, input string) (c composition, err error) {
+	var fragments []fragment
+	if err := collectFragments(sourceFile, input, map[string]bool{}, map[string]bool{}, &fragments); err != nil {
+		return c, err
+	}
+
+	var lines []string
+	for _, f := range fragments {
+		f.offset = len(lines) + 1
+		lines = append(lines, "-")
+		for _, line := range strings.Split(f.input, "\n") {
+			// a document start would end the sequence
+			if strings.TrimRight(line, " ") == "---" {
+				line = ""
+			}
+			lines = append(lines, "  "+line)
+		}
+		c.fragments = append(c.fragments, f)
+	}
+	c.text = strings.Join(lines, "\n") + "\n"
+	return c, nil
+}
+
+func collectFragments(file string, input string, reading map[string]bool, read map[string]bool, fragments *[]fragment) error {
+	absolute, _ := filepath.Abs(file)
+	reading[absolute] = true
+	defer delete(reading, absolute)
+
+	includes, input, err := splitIncludes(input)
+	if err != nil {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["yaml_include"], file, err.Error()))
+	}
+	for _, include := range includes {
+		if filepath.IsAbs(include) == false {
+			include = filepath.Join(filepath.Dir(file), include)
+		}
+		includeAbsolute, _ := filepath.Abs(include)
+		if reading[includeAbsolute] {
+			return errors.New(fmt.Sprintf(response.ErrorMessages["yaml_include"], file, include+" includes "+file))
+		}
+		if read[includeAbsolute] {
+			continue
+		}
+		read[includeAbsolute] = true
+		content, ioErr := ioutil.ReadFile(include)
+		if ioErr != nil {
+			return errors.New(fmt.Sprintf(response.ErrorMessages["file_not_exist"], include))
+		}
+		if err := collectFragments(include, string(content), reading, read, fragments); err != nil {
+			return err
+		}
+	}
+	*fragments = append(*fragments, fragment{file: file, input: input})
+	return nil
+}
+
+// splitIncludes reads the include section of a yaml file, and blanks it so that the lines of the file do not move
+func splitIncludes(input string) (includes []string, output string, err error) {
+	lines := strings.Split(input, "\n")
+	for i, line := range lines {
+		if includeRegexp.MatchString(line) == false {
+			continue
+		}
+		end := i + 1
+		for end < len(lines) && (strings.TrimSpace(lines[end]) == "" || strings.IndexAny(lines[end][:1], " \t-#") == 0) {
+			end++
+		}
+
+		var section struct {
+			Include []string `yaml:"include"`
+		}
+		if err := yamlv3.Unmarshal([]byte(strings.Join(lines[i:end], "\n")), &section); err != nil {
+			return includes, input, errors.New(strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+		for j := i; j < end; j++ {
+			lines[j] = ""
+		}
+		return section.Include, strings.Join(lines, "\n"), nil
+	}
+	return includes, input, nil
+}
+
+// parse parses the text of a composition (with its variables replaced or not), and merges its files in a single
+// mapping
+func (c composition) parse(text string) (root *yamlv3.Node, err error) {
+	root, problem := c.merge(text)
+	if problem == nil {
+		return root, nil
+	}
+	file, line := c.locate(problem.Line)
+	if line == 0 {
+		return nil, errors.New(fmt.Sprintf(response.ErrorMessages["yaml_include"], c.fragments[len(c.fragments)-1].file, problem.Message))
+	}
+	return nil, errors.New(fmt.Sprintf(response.ErrorMessages["yaml_include"], file, "line "+strconv.Itoa(line)+": "+problem.Message))
+}
+
+// merge parses the text of a composition, and merges its files in a single mapping. Services are merged by name, and
+// the other keys of a file win over the ones of the files it includes. Problems are at their line of the composition.
+func (c composition) merge(text string) (root *yamlv3.Node, problem *Problem) {
+	var document yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(text), &document); err != nil {
+		problem := Problem{Level: LevelError, Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+		if matches := yamlErrorRegexp.FindStringSubmatch(err.Error()); matches != nil {
+			problem.Line, _ = strconv.Atoi(matches[1])
+			problem.Column = 1
+			problem.Message = matches[2]
+		}
+		return nil, &problem
+	}
+
+	root = &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+	for i, item := range document.Content[0].Content {
+		if item.Kind == yamlv3.ScalarNode && item.ShortTag() == "!!null" {
+			continue
+		}
+		if item.Kind != yamlv3.MappingNode {
+			return nil, &Problem{Line: c.fragments[i].offset + 1, Column: 1, Level: LevelError, Message: "file must be an object, got " + kindName(item)}
+		}
+		for j := 0; j+1 < len(item.Content); j += 2 {
+			key, value := item.Content[j], item.Content[j+1]
+			index := keyIndex(root, key.Value)
+			switch {
+			case index == -1:
+				root.Content = append(root.Content, key, value)
+			case key.Value == "services" && root.Content[index+1].Kind == yamlv3.MappingNode && value.Kind == yamlv3.MappingNode:
+				services := &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map", Line: value.Line, Column: value.Column}
+				services.Content = append(services.Content, root.Content[index+1].Content...)
+				for k := 0; k+1 < len(value.Content); k += 2 {
+					if service := keyIndex(services, value.Content[k].Value); service != -1 {
+						services.Content[service+1] = value.Content[k+1]
+					} else {
+						services.Content = append(services.Content, value.Content[k], value.Content[k+1])
+					}
+				}
+				root.Content[index+1] = services
+			default:
+				root.Content[index], root.Content[index+1] = key, value
+			}
+		}
+	}
+	// the problems of the merged mapping are reported on the including file
+	last := document.Content[0].Content[len(document.Content[0].Content)-1]
+	root.Line, root.Column = last.Line, last.Column
+	return root, nil
+}
+
+// locate returns the file and the line of a line of the composition, an empty file and 0 out of the files
+func (c composition) locate(line int) (file string, fileLine int) {
+	for _, f := range c.fragments {
+		if line > f.offset {
+			file, fileLine = f.file, line-f.offset
+		}
+	}
+	return file, fileLine
+}
+
+// expandNode returns a copy of a node where aliases are replaced by what they reference, and merge keys by the pairs
+// they merge, so that the anchors can be removed
+func expandNode(node *yamlv3.Node) *yamlv3.Node {
+	node = resolveAlias(node)
+	expanded := *node
+	expanded.Anchor = ""
+	expanded.Content = nil
+	if node.Kind == yamlv3.MappingNode {
+		for _, pair := range mappingPairs(node) {
+			expanded.Content = append(expanded.Content, expandNode(pair[0]), expandNode(pair[1]))
+		}
+		return &expanded
+	}
+	for _, child := range node.Content {
+		expanded.Content = append(expanded.Content, expandNode(child))
+	}
+	return &expanded
+}
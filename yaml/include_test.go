@@ -0,0 +1,83 @@
+package yaml
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"strings"
+	"testing"
+)
+
+func TestResolveIncludes(t *testing.T) {
+	cleanYaml, err := PrepareSwapperYaml("tests/include/valid.yml", []string{"TAG=1.17.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	yamlConf, err := ParseSwapperYaml(cleanYaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(cleanYaml, "x-logging") || strings.Contains(cleanYaml, "include") || len(yamlConf.Notifications) != 1 || len(yamlConf.Services) != 2 {
+		t.Fatal(cleanYaml)
+	}
+	for _, service := range yamlConf.Services {
+		container := service.Containers[0]
+		if container.LoggingDriver != "gcplogs" || container.LoggingOptions["max-size"] != "10m" {
+			t.Error(container)
+		}
+		// explicit keys win over the templates
+		if service.Name == "my-app" && (container.HealthCmd == "" || container.HealthRetries != 5) {
+			t.Error(container)
+		}
+	}
+
+	// templates without include
+	input := "version: '1'\nx-nginx: &nginx\n  image: nginx\n  tag: 1.17.0\nservices:\n  api:\n    ports:\n      - 80:80\n    containers:\n      - <<: *nginx\n        weight: 10\n"
+	output, err := ResolveIncludes("default.yml", input)
+	if err != nil || output != "version: '1'\nservices:\n  api:\n    ports:\n      - 80:80\n    containers:\n      - weight: 10\n        image: nginx\n        tag: 1.17.0\n" {
+		t.Error(output, err)
+	}
+	if problems := ValidateString(input, []string{}); len(problems) != 0 {
+		t.Error(problems)
+	}
+
+	input = "version: '1'\nservices: {}\n"
+	if output, _ := ResolveIncludes("default.yml", input); output != input {
+		t.Error(output)
+	}
+
+	_, err = ResolveIncludes("tests/include/loop.yml", "include:\n  - loop.yml\n")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["yaml_include"], "tests/include/loop.yml", "tests/include/loop.yml includes tests/include/loop.yml") {
+		t.Error(err)
+	}
+
+	_, err = ResolveIncludes("tests/include/valid.yml", "include:\n  - unknown.yml\n")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["file_not_exist"], "tests/include/unknown.yml") {
+		t.Error(err)
+	}
+
+	// anchors of a file are not known by the files it includes
+	_, err = ResolveIncludes("tests/include/valid.yml", "include:\n  - common.yml\nservices:\n  api:\n    <<: *api\n")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["yaml_include"], "tests/include/valid.yml", "unknown anchor 'api' referenced") {
+		t.Error(err)
+	}
+}
+
+func TestValidateIncludes(t *testing.T) {
+	problems, err := Validate("tests/include/invalid.yml", []string{})
+	if err != nil || len(problems) != 1 {
+		t.Fatal(problems, err)
+	}
+	if problems[0].File != "tests/include/invalid.common.yml" || problems[0].Line != 3 || problems[0].Column != 7 {
+		t.Error(problems[0])
+	}
+
+	problems, _ = Validate("tests/include/valid.yml", []string{})
+	if len(problems) != 1 || problems[0].File != "" || problems[0].Line != 13 || strings.HasPrefix(problems[0].Message, "missing variable TAG") == false {
+		t.Error(problems)
+	}
+
+	problems, _ = Validate("tests/include/syntax.yml", []string{})
+	if len(problems) != 1 || problems[0].File != "tests/include/syntax.common.yml" || problems[0].Line != 4 || problems[0].Message != "did not find expected node content" {
+		t.Error(problems)
+	}
+}
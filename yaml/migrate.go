@@ -0,0 +1,143 @@
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	yamlv3 "gopkg.in/yaml.v3"
+	"strconv"
+)
+
+// healthKeys are the v1 health fields of containers, and their name in the healthcheck of v2
+var healthKeys = [][2]string{{"health-cmd", "cmd"}, {"health-interval", "interval"}, {"health-timeout", "timeout"}, {"health-retries", "retries"}}
+
+// MigrateV1 rewrites a v1 yaml file into v2, keeping its comments and variables
+func MigrateV1(input string) (output string, err error) {
+	var document yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(input), &document); err != nil {
+		return output, errors.New(fmt.Sprintf(response.ErrorMessages["yaml_schema"], err.Error()))
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yamlv3.MappingNode {
+		return output, errors.New(response.ErrorMessages["yaml_invalid"])
+	}
+	root := document.Content[0]
+
+	version := mappingValue(root, "version")
+	if scalarValue(version) == "2" {
+		return output, errors.New(response.ErrorMessages["yaml_migrated"])
+	}
+	if scalarValue(version) != "1" {
+		return output, errors.New(response.ErrorMessages["yaml_version"])
+	}
+	version.Value = "2"
+
+	if master := mappingValue(root, "master"); master != nil {
+		renameKey(master, "project-id", "project_id")
+		renameKey(master, "credentials-file", "credentials_file")
+	}
+
+	migrateSlack(root)
+	renameKey(root, "proxy-stats", "proxy_stats")
+	renameKey(root, "proxy-logs", "proxy_logs")
+
+	for _, service := range mappingPairs(mappingValue(root, "services")) {
+		if proxy := mappingValue(service[1], "proxy"); proxy != nil {
+			renameKey(proxy, "send-proxy", "send_proxy")
+		}
+		renameKey(resolveAlias(service[1]), "proxy-health", "proxy_health")
+		renameKey(resolveAlias(service[1]), "rate-limit", "rate_limit")
+		containers := mappingValue(service[1], "containers")
+		if containers == nil || containers.Kind != yamlv3.SequenceNode {
+			continue
+		}
+		for _, container := range containers.Content {
+			migrateHealth(resolveAlias(container))
+		}
+	}
+
+	var buffer bytes.Buffer
+	encoder := yamlv3.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return output, err
+	}
+	_ = encoder.Close()
+	return buffer.String(), nil
+}
+
+// migrateSlack moves the deprecated slack section into a notification of slack type
+func migrateSlack(root *yamlv3.Node) {
+	index := keyIndex(root, "slack")
+	if index == -1 {
+		return
+	}
+	slackKey, slack := root.Content[index], resolveAlias(root.Content[index+1])
+	root.Content = append(root.Content[:index], root.Content[index+2:]...)
+	if mappingValue(slack, "webhook-url") == nil {
+		return
+	}
+
+	notification := &yamlv3.Node{Kind: yamlv3.MappingNode, Content: []*yamlv3.Node{
+		{Kind: yamlv3.ScalarNode, Value: "type"}, {Kind: yamlv3.ScalarNode, Value: "slack"},
+		{Kind: yamlv3.ScalarNode, Value: "url"}, mappingValue(slack, "webhook-url"),
+	}}
+	if channel := mappingValue(slack, "channel"); channel != nil {
+		notification.Content = append(notification.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Value: "channel"}, channel)
+	}
+
+	if notifications := mappingValue(root, "notifications"); notifications != nil && notifications.Kind == yamlv3.SequenceNode {
+		notifications.Content = append([]*yamlv3.Node{notification}, notifications.Content...)
+		return
+	}
+	notifications := &yamlv3.Node{Kind: yamlv3.SequenceNode, Content: []*yamlv3.Node{notification}}
+	pair := []*yamlv3.Node{{Kind: yamlv3.ScalarNode, Value: "notifications", HeadComment: slackKey.HeadComment}, notifications}
+	root.Content = append(root.Content[:index], append(pair, root.Content[index:]...)...)
+}
+
+// migrateHealth groups the health fields of a container into its healthcheck
+func migrateHealth(container *yamlv3.Node) {
+	healthcheck := &yamlv3.Node{Kind: yamlv3.MappingNode}
+	position := -1
+	for _, keys := range healthKeys {
+		index := keyIndex(container, keys[0])
+		if index == -1 {
+			continue
+		}
+		value := container.Content[index+1]
+		if keys[1] == "retries" && value.ShortTag() == "!!str" {
+			if _, err := strconv.Atoi(value.Value); err == nil {
+				value.Tag = "!!int"
+				value.Style = 0
+			}
+		}
+		key := *container.Content[index]
+		key.Value = keys[1]
+		healthcheck.Content = append(healthcheck.Content, &key, value)
+		container.Content = append(container.Content[:index], container.Content[index+2:]...)
+		if position == -1 || index < position {
+			position = index
+		}
+	}
+	if position == -1 {
+		return
+	}
+	pair := []*yamlv3.Node{{Kind: yamlv3.ScalarNode, Value: "healthcheck"}, healthcheck}
+	container.Content = append(container.Content[:position], append(pair, container.Content[position:]...)...)
+}
+
+func renameKey(node *yamlv3.Node, oldKey string, newKey string) {
+	if index := keyIndex(node, oldKey); index != -1 {
+		node.Content[index].Value = newKey
+	}
+}
+
+// keyIndex returns the index of a key in the content of a mapping, -1 if it is missing
+func keyIndex(node *yamlv3.Node, key string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
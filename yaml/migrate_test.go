@@ -0,0 +1,73 @@
+package yaml
+
+import (
+	"github.com/sachamorard/swapper/response"
+	"strings"
+	"testing"
+)
+
+func TestMigrateV1(t *testing.T) {
+	input := `version: '1'
+
+master:
+  driver: gcp
+  project-id: my-project
+
+# deploy notifications
+slack:
+  webhook-url: https://hooks.slack.com/services/XXX/YYY/ZZZ
+  channel: '#deploy'
+
+services:
+  my-app:
+    ports:
+      - 80:80
+    containers:
+      - image: nginx
+        tag: ${TAG}
+        # check nginx answers
+        health-cmd: curl localhost
+        health-retries: "3"
+`
+	output, err := MigrateV1(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `version: '2'
+master:
+  driver: gcp
+  project_id: my-project
+# deploy notifications
+notifications:
+  - type: slack
+    url: https://hooks.slack.com/services/XXX/YYY/ZZZ
+    channel: '#deploy'
+services:
+  my-app:
+    ports:
+      - 80:80
+    containers:
+      - image: nginx
+        tag: ${TAG}
+        healthcheck:
+          # check nginx answers
+          cmd: curl localhost
+          retries: 3
+`
+	if output != expected {
+		t.Error(output)
+	}
+	if _, err := DecodeV2(strings.Replace(output, "${TAG}", "1.17.0", 1)); err != nil {
+		t.Error(err)
+	}
+
+	_, err = MigrateV1(output)
+	if err == nil || err.Error() != response.ErrorMessages["yaml_migrated"] {
+		t.Error(err)
+	}
+
+	_, err = MigrateV1("version: '3'\nservices: {}")
+	if err == nil || err.Error() != response.ErrorMessages["yaml_version"] {
+		t.Error(err)
+	}
+}
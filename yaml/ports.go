@@ -0,0 +1,127 @@
+package yaml
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	ProtocolTcp = "tcp"
+	ProtocolUdp = "udp"
+)
+
+// Port is a port binding of a service: [address:]listen[-end]:container[-end][/protocol]
+type Port struct {
+	// Address is the address of the node the port is published on, all the addresses when empty
+	Address string
+	Listen  int
+	// ListenEnd is the last listen port of a range, Listen when it is a single port
+	ListenEnd int
+	// Bind is the container port of Listen, the next listen ports are bound to the next container ports
+	Bind     int
+	Protocol string
+}
+
+// ParsePort parses a port binding of a service, the error describes what is wrong with it
+func ParsePort(value string) (port Port, err error) {
+	if portRegexp.MatchString(value) == false {
+		return port, errors.New("must look like \"[address:]listen:container[/udp]\"")
+	}
+	port.Protocol = ProtocolTcp
+	if i := strings.Index(value, "/"); i != -1 {
+		value, port.Protocol = value[:i], value[i+1:]
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) == 3 {
+		port.Address, parts = parts[0], parts[1:]
+		if net.ParseIP(port.Address) == nil {
+			return port, errors.New("has an invalid address " + port.Address)
+		}
+	}
+
+	listen, err := portRange(parts[0])
+	if err != nil {
+		return port, err
+	}
+	bind, err := portRange(parts[1])
+	if err != nil {
+		return port, err
+	}
+	if listen[1]-listen[0] != bind[1]-bind[0] {
+		return port, errors.New("has listen and container ranges of different sizes")
+	}
+	port.Listen, port.ListenEnd, port.Bind = listen[0], listen[1], bind[0]
+	return port, nil
+}
+
+func portRange(value string) (ports [2]int, err error) {
+	bounds := strings.SplitN(value, "-", 2)
+	ports[0], _ = strconv.Atoi(bounds[0])
+	ports[1] = ports[0]
+	if len(bounds) == 2 {
+		ports[1], _ = strconv.Atoi(bounds[1])
+	}
+	for _, port := range ports {
+		if port < 1 || port > 65535 {
+			return ports, errors.New("has an invalid port number " + value)
+		}
+	}
+	if ports[0] > ports[1] {
+		return ports, errors.New("has an invalid port range " + value)
+	}
+	return ports, nil
+}
+
+// Range returns the listen port(s) of the binding, like "53" or "8000-8010"
+func (p Port) Range() string {
+	if p.ListenEnd == p.Listen {
+		return strconv.Itoa(p.Listen)
+	}
+	return strconv.Itoa(p.Listen) + "-" + strconv.Itoa(p.ListenEnd)
+}
+
+// BindRange returns the container port(s) of the binding
+func (p Port) BindRange() string {
+	if p.ListenEnd == p.Listen {
+		return strconv.Itoa(p.Bind)
+	}
+	return strconv.Itoa(p.Bind) + "-" + strconv.Itoa(p.Bind+p.ListenEnd-p.Listen)
+}
+
+// Published returns what the proxy publishes on the node for the binding, like "127.0.0.1:8080/tcp". Nodes recreate
+// the proxy when the published ports change.
+func (p Port) Published() string {
+	published := p.Range() + "/" + p.Protocol
+	if p.Address != "" {
+		published = p.Address + ":" + published
+	}
+	return published
+}
+
+// Overlaps tells whether two bindings listen on a same port of a same address
+func (p Port) Overlaps(other Port) bool {
+	if p.Protocol != other.Protocol || p.Listen > other.ListenEnd || other.Listen > p.ListenEnd {
+		return false
+	}
+	return p.Address == other.Address || allAddresses(p.Address) || allAddresses(other.Address)
+}
+
+func allAddresses(address string) bool {
+	return address == "" || address == "0.0.0.0"
+}
+
+// frontendNames returns the names of the frontend and the backend of a binding in the proxy, plain tcp ports keep the
+// names of the first swapper versions
+func frontendNames(p Port) (frontend string, backend string) {
+	id := p.Range()
+	if p.Address != "" {
+		id = p.Address + "_" + id
+	}
+	frontend, backend = "frontend_"+id, "backend_"+id+"_"+p.BindRange()
+	if p.Protocol != ProtocolTcp {
+		frontend, backend = frontend+"_"+p.Protocol, backend+"_"+p.Protocol
+	}
+	return frontend, backend
+}
@@ -0,0 +1,88 @@
+package yaml
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"testing"
+)
+
+func TestParsePort(t *testing.T) {
+	valid := map[string]Port{
+		"80:8080":                      {Listen: 80, ListenEnd: 80, Bind: 8080, Protocol: ProtocolTcp},
+		"53:53/udp":                    {Listen: 53, ListenEnd: 53, Bind: 53, Protocol: ProtocolUdp},
+		"127.0.0.1:8080:80/tcp":        {Address: "127.0.0.1", Listen: 8080, ListenEnd: 8080, Bind: 80, Protocol: ProtocolTcp},
+		"8000-8010:9000-9010":          {Listen: 8000, ListenEnd: 8010, Bind: 9000, Protocol: ProtocolTcp},
+		"10.0.0.1:514-515:514-515/udp": {Address: "10.0.0.1", Listen: 514, ListenEnd: 515, Bind: 514, Protocol: ProtocolUdp},
+	}
+	for value, expected := range valid {
+		port, err := ParsePort(value)
+		if err != nil || port != expected {
+			t.Errorf("%s: %v %v", value, port, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"80":              "must look like \"[address:]listen:container[/udp]\"",
+		"80:80/sctp":      "must look like \"[address:]listen:container[/udp]\"",
+		"999.0.0.1:80:80": "has an invalid address 999.0.0.1",
+		"0:80":            "has an invalid port number 0",
+		"80:70000":        "has an invalid port number 70000",
+		"8010-8000:80-90": "has an invalid port range 8010-8000",
+		"8000-8010:80":    "has listen and container ranges of different sizes",
+	}
+	for value, expected := range invalid {
+		if _, err := ParsePort(value); err == nil || err.Error() != expected {
+			t.Errorf("%s: %v", value, err)
+		}
+	}
+}
+
+func TestPortOverlaps(t *testing.T) {
+	overlaps := map[[2]string]bool{
+		{"80:80", "80:8080"}:                      true,
+		{"80:80", "80:80/udp"}:                    false,
+		{"127.0.0.1:80:80", "80:80"}:              true,
+		{"127.0.0.1:80:80", "0.0.0.0:80:80"}:      true,
+		{"127.0.0.1:80:80", "10.0.0.1:80:80"}:     false,
+		{"8000-8010:8000-8010", "8010:80"}:        true,
+		{"8000-8010:8000-8010", "8011-8020:1-10"}: false,
+	}
+	for ports, expected := range overlaps {
+		first, _ := ParsePort(ports[0])
+		second, _ := ParsePort(ports[1])
+		if first.Overlaps(second) != expected || second.Overlaps(first) != expected {
+			t.Errorf("%s %s", ports[0], ports[1])
+		}
+	}
+}
+
+func TestBindPorts(t *testing.T) {
+	yamlConf, err := ParseSwapperYaml("version: '1'\nservices:\n  dns:\n    ports:\n      - 53:53/udp\n      - 53:53\n      - 127.0.0.1:8000-8010:9000-9010\n    containers:\n      - image: coredns/coredns\n        tag: 1.6.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := [][3]string{
+		{"frontend_53_udp", "backend_53_53_udp", "53/udp"},
+		{"frontend_53", "backend_53_53", "53/tcp"},
+		{"frontend_127.0.0.1_8000-8010", "backend_127.0.0.1_8000-8010_9000-9010", "127.0.0.1:8000-8010/tcp"},
+	}
+	for i, frontend := range yamlConf.Frontends {
+		if frontend.Name != names[i][0] || frontend.BackendName != names[i][1] || frontend.Published() != names[i][2] {
+			t.Error(frontend)
+		}
+	}
+
+	_, err = ParseSwapperYaml("version: '1'\nservices:\n  dns:\n    ports:\n      - 8000-8010:8000-8010\n      - 127.0.0.1:8005:80\n    containers:\n      - image: coredns/coredns\n        tag: 1.6.9")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["port_conflict"], "127.0.0.1:8005") {
+		t.Error(err)
+	}
+
+	problems := ValidateString("version: '1'\nservices:\n  dns:\n    ports:\n      - 53:53/udp\n      - 53:5353/udp\n      - 54-55:53\n    containers:\n      - image: coredns/coredns\n        tag: 1.6.9", []string{})
+	expected := []Problem{
+		{Line: 6, Column: 9, Level: LevelError, Message: "port 53/udp of service \"dns\" is already bound at line 5"},
+		{Line: 7, Column: 9, Level: LevelError, Message: "services.dns.ports[2] has listen and container ranges of different sizes, got \"54-55:53\""},
+	}
+	if fmt.Sprint(problems) != fmt.Sprint(expected) {
+		t.Error(problems)
+	}
+}
@@ -0,0 +1,500 @@
+package yaml
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BalanceAlgorithms lists how swapper-proxy can spread the connections of a service over its containers
+var BalanceAlgorithms = map[string]bool{"roundrobin": true, "leastconn": true, "source": true, "uri": true}
+
+// ProxyModes lists how swapper-proxy reads the traffic of a service, http parses the requests and logs them
+var ProxyModes = map[string]bool{"tcp": true, "http": true}
+
+// LogDestinations lists where swapper-proxy sends its access logs: docker logs, a syslog server or a file of the node
+var LogDestinations = map[string]bool{"stdout": true, "syslog": true, "file": true}
+
+// LogFacilities lists the syslog facilities understood by both haproxy and nginx
+var LogFacilities = map[string]bool{"user": true, "daemon": true, "local0": true, "local1": true, "local2": true, "local3": true, "local4": true, "local5": true, "local6": true, "local7": true}
+
+// StickyTypes lists how swapper-proxy keeps the clients of a service on the same container
+var StickyTypes = map[string]bool{"source": true, "cookie": true}
+
+// HealthMethods lists the http methods of the health checks of swapper-proxy
+var HealthMethods = map[string]bool{"GET": true, "HEAD": true, "OPTIONS": true}
+
+const (
+	DefaultBalance         = "roundrobin"
+	DefaultMaxConn         = 800
+	DefaultHealthMethod    = "GET"
+	DefaultHealthExpect    = 200
+	DefaultStickyCookie    = "SWAPPERID"
+	DefaultStickyExpire    = "30m"
+	DefaultStickyDrain     = "30s"
+	DefaultLogFacility     = "local0"
+	DefaultRateLimitPeriod = "10s"
+	// MaxStickyDrain bounds how long a node keeps the old containers of a swap
+	MaxStickyDrain = time.Hour
+)
+
+var (
+	stickyCookieRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	syslogRegexp       = regexp.MustCompile(`^[A-Za-z0-9.-]+(:[0-9]{1,5})?$`)
+)
+
+// ProxyOptions tune how swapper-proxy serves a service, empty values keep the defaults of swapper-proxy
+type ProxyOptions struct {
+	// Mode is tcp or http, empty for tcp unless an option needs http
+	Mode string
+	// Balance is the algorithm, uri hashes the path of http requests
+	Balance        string
+	ConnectTimeout string
+	ClientTimeout  string
+	ServerTimeout  string
+	// MaxConn is the maximum number of concurrent connections of each frontend of the service
+	MaxConn       int
+	CheckInterval string
+	CheckRise     int
+	CheckFall     int
+	// SendProxy sends the PROXY protocol header to containers, with the address of clients
+	SendProxy bool
+	// Health replaces the layer 4 checks of the containers by http requests when its path is set
+	Health    ProxyHealth
+	Sticky    Sticky
+	RateLimit RateLimit
+	// Allow and Deny are ip addresses or cidr ranges of clients, only the allowed clients are accepted when Allow is
+	// set and the denied ones never are
+	Allow []string
+	Deny  []string
+}
+
+// RateLimit limits the traffic of each client ip of a service, zero values are not limited
+type RateLimit struct {
+	// Connections is the maximum number of new connections of a client per period
+	Connections int
+	// Requests is the maximum number of http requests of a client per period, the next ones are answered 429
+	Requests int
+	// Concurrent is the maximum number of simultaneous connections of a client
+	Concurrent int
+	Period     string
+}
+
+// ProxyLogs are the access logs of swapper-proxy, disabled when there is no destination
+type ProxyLogs struct {
+	Destination string
+	// Address is the host[:port] of the syslog server (syslog destination)
+	Address string
+	// Path is the directory of the node receiving the log files (file destination)
+	Path     string
+	Facility string
+}
+
+// ProxyStats is the stats dashboard of swapper-proxy, disabled when its port is 0
+type ProxyStats struct {
+	// Address is the address of the node the dashboard is published on, all the addresses when empty
+	Address  string
+	Port     int
+	Username string
+	Password string
+}
+
+// Binding returns the port published by swapper-proxy for the dashboard
+func (s ProxyStats) Binding() Port {
+	return Port{Address: s.Address, Listen: s.Port, ListenEnd: s.Port, Bind: s.Port, Protocol: ProtocolTcp}
+}
+
+// Sticky keeps each client on the same container, source ip stick tables for tcp and cookies for http
+type Sticky struct {
+	Type string
+	// Cookie is the name of the cookie inserted by the proxy (cookie type)
+	Cookie string
+	// Expire is how long the stick table remembers a client (source type)
+	Expire string
+	// Drain is how long the containers of the previous deployment keep their sticky clients after a swap
+	Drain string
+}
+
+// ProxyHealth is the http health check of the containers of a service by swapper-proxy, independent of the
+// health-cmd run by docker
+type ProxyHealth struct {
+	Method string
+	Path   string
+	// Expect is the expected status of responses
+	Expect   int
+	Host     string
+	Interval string
+}
+
+func interpretProxy(proxyYml *Yaml) (proxy ProxyOptions) {
+	proxy.Mode, _ = proxyYml.Get("mode").String()
+	proxy.Balance, _ = proxyYml.Get("balance").String()
+	proxy.ConnectTimeout, _ = proxyYml.Get("timeouts").Get("connect").String()
+	proxy.ClientTimeout, _ = proxyYml.Get("timeouts").Get("client").String()
+	proxy.ServerTimeout, _ = proxyYml.Get("timeouts").Get("server").String()
+	proxy.MaxConn, _ = proxyYml.Get("maxconn").Int()
+	proxy.CheckInterval, _ = proxyYml.Get("check").Get("interval").String()
+	proxy.CheckRise, _ = proxyYml.Get("check").Get("rise").Int()
+	proxy.CheckFall, _ = proxyYml.Get("check").Get("fall").Int()
+	proxy.SendProxy, _ = proxyYml.Get("send-proxy").Bool()
+	return proxy
+}
+
+func interpretProxyLogs(logsYml *Yaml) (logs ProxyLogs) {
+	logs.Destination, _ = logsYml.Get("destination").String()
+	logs.Address, _ = logsYml.Get("address").String()
+	logs.Path, _ = logsYml.Get("path").String()
+	logs.Facility, _ = logsYml.Get("facility").String()
+	return logs
+}
+
+// checkProxyLogs checks the access logs of swapper-proxy
+func checkProxyLogs(logs ProxyLogs) error {
+	invalid := func(message string) error {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["proxy_logs_invalid"], message))
+	}
+	if logs == (ProxyLogs{}) {
+		return nil
+	}
+	if LogDestinations[logs.Destination] != true {
+		return invalid("destination must be one of " + strings.Join(mapKeys(LogDestinations), ", "))
+	}
+	if (logs.Destination == "syslog") != (logs.Address != "") || logs.Address != "" && syslogRegexp.MatchString(logs.Address) == false {
+		return invalid("address must look like host[:port], with the syslog destination only")
+	}
+	if (logs.Destination == "file") != (logs.Path != "") || logs.Path != "" && (strings.HasPrefix(logs.Path, "/") == false || strings.ContainsAny(logs.Path, " \t\r\n':")) {
+		return invalid("path must be an absolute directory of the node without spaces, with the file destination only")
+	}
+	if logs.Facility != "" && LogFacilities[logs.Facility] != true {
+		return invalid("facility must be one of " + strings.Join(mapKeys(LogFacilities), ", "))
+	}
+	return nil
+}
+
+func interpretProxyStats(statsYml *Yaml) (stats ProxyStats) {
+	stats.Address, _ = statsYml.Get("address").String()
+	stats.Port, _ = statsYml.Get("port").Int()
+	stats.Username, _ = statsYml.Get("username").String()
+	stats.Password, _ = statsYml.Get("password").String()
+	return stats
+}
+
+// checkProxyStats checks the stats dashboard of swapper-proxy, whose port cannot be a port of the services
+func checkProxyStats(stats ProxyStats, frontends []Frontend) error {
+	invalid := func(message string) error {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["proxy_stats_invalid"], message))
+	}
+	if stats == (ProxyStats{}) {
+		return nil
+	}
+	if stats.Port < 1 || stats.Port > 65535 {
+		return invalid("port must be a port number")
+	}
+	if stats.Address != "" && net.ParseIP(stats.Address) == nil {
+		return invalid("address must be an ip address of the node")
+	}
+	if stats.Username == "" || stats.Password == "" {
+		return invalid("username and password are required")
+	}
+	if strings.ContainsAny(stats.Username+stats.Password, " \t\r\n'") || strings.Contains(stats.Username, ":") {
+		return invalid("username and password cannot contain spaces or quotes, nor the username a colon")
+	}
+	for _, frontend := range frontends {
+		if stats.Binding().Overlaps(frontend.Port) {
+			return invalid("port " + strconv.Itoa(stats.Port) + " is already bound by service " + frontend.ServiceName)
+		}
+	}
+	return nil
+}
+
+func interpretSticky(stickyYml *Yaml) (sticky Sticky) {
+	sticky.Type, _ = stickyYml.Get("type").String()
+	sticky.Cookie, _ = stickyYml.Get("cookie").String()
+	sticky.Expire, _ = stickyYml.Get("expire").String()
+	sticky.Drain, _ = stickyYml.Get("drain").String()
+	return sticky
+}
+
+func interpretRateLimit(rateLimitYml *Yaml) (rateLimit RateLimit) {
+	rateLimit.Connections, _ = rateLimitYml.Get("connections").Int()
+	rateLimit.Requests, _ = rateLimitYml.Get("requests").Int()
+	rateLimit.Concurrent, _ = rateLimitYml.Get("concurrent").Int()
+	rateLimit.Period, _ = rateLimitYml.Get("period").String()
+	return rateLimit
+}
+
+// interpretSources reads a list of ip addresses or cidr ranges, like allow and deny
+func interpretSources(sourcesYml *Yaml) (sources []string) {
+	sourcesLen, _ := sourcesYml.GetArraySize()
+	for i := 0; i < sourcesLen; i++ {
+		source, _ := sourcesYml.GetIndex(i).String()
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+func interpretProxyHealth(healthYml *Yaml) (health ProxyHealth) {
+	health.Method, _ = healthYml.Get("method").String()
+	health.Path, _ = healthYml.Get("path").String()
+	health.Expect, _ = healthYml.Get("expect").Int()
+	health.Host, _ = healthYml.Get("host").String()
+	health.Interval, _ = healthYml.Get("interval").String()
+	return health
+}
+
+// checkProxy checks the proxy options of a service, for its ports
+func checkProxy(proxy ProxyOptions, serviceName string, ports []string) error {
+	invalid := func(message string) error {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["proxy_invalid"], serviceName, message))
+	}
+	if proxy.Mode != "" && ProxyModes[proxy.Mode] != true {
+		return invalid("mode must be one of " + strings.Join(mapKeys(ProxyModes), ", "))
+	}
+	if proxy.Balance != "" && BalanceAlgorithms[proxy.Balance] != true {
+		return invalid("balance must be one of " + strings.Join(mapKeys(BalanceAlgorithms), ", "))
+	}
+	if proxy.Mode == "tcp" && (proxy.Balance == "uri" || proxy.Sticky.Type == "cookie") {
+		return invalid("balance uri and sticky cookies need http, they cannot be used with mode tcp")
+	}
+	if proxy.Mode == "tcp" && proxy.RateLimit.Requests != 0 {
+		return invalid("rate-limit.requests counts http requests, it cannot be used with mode tcp")
+	}
+	durations := []struct{ name, value string }{
+		{"timeouts.connect", proxy.ConnectTimeout}, {"timeouts.client", proxy.ClientTimeout}, {"timeouts.server", proxy.ServerTimeout}, {"check.interval", proxy.CheckInterval},
+	}
+	for _, duration := range durations {
+		if d, err := time.ParseDuration(duration.value); duration.value != "" && (err != nil || d < time.Millisecond) {
+			return invalid(duration.name + " must be a duration of 1ms or more, like 5s")
+		}
+	}
+	integers := []struct {
+		name  string
+		value int
+	}{{"maxconn", proxy.MaxConn}, {"check.rise", proxy.CheckRise}, {"check.fall", proxy.CheckFall}}
+	for _, integer := range integers {
+		if integer.value < 0 {
+			return invalid(integer.name + " must be a positive integer")
+		}
+	}
+	if err := checkProxyHealth(proxy); err != "" {
+		return invalid(err)
+	}
+	if err := checkSticky(proxy.Sticky); err != "" {
+		return invalid(err)
+	}
+	if err := checkRateLimit(proxy.RateLimit); err != "" {
+		return invalid(err)
+	}
+	if err := checkSources("allow", proxy.Allow); err != "" {
+		return invalid(err)
+	}
+	if err := checkSources("deny", proxy.Deny); err != "" {
+		return invalid(err)
+	}
+	for _, p := range ports {
+		port, err := ParsePort(p)
+		if err != nil || port.Protocol == ProtocolTcp {
+			continue
+		}
+		if proxy.Mode == "http" {
+			return invalid("mode http cannot be used with " + port.Protocol + " ports")
+		}
+		if proxy.Balance == "uri" {
+			return invalid("balance uri hashes http requests, it cannot be used with " + port.Protocol + " ports")
+		}
+		if proxy.SendProxy {
+			return invalid("send-proxy cannot be used with " + port.Protocol + " ports")
+		}
+		if proxy.Sticky.Type == "cookie" {
+			return invalid("sticky cookies need http, they cannot be used with " + port.Protocol + " ports")
+		}
+		if proxy.RateLimit != (RateLimit{}) {
+			return invalid("rate-limit cannot be used with " + port.Protocol + " ports")
+		}
+	}
+	return nil
+}
+
+// checkProxyHealth returns what is wrong with the http health check of a service, empty when it is valid
+func checkProxyHealth(proxy ProxyOptions) string {
+	health := proxy.Health
+	if health == (ProxyHealth{}) {
+		return ""
+	}
+	if strings.HasPrefix(health.Path, "/") == false || unsafeConfValue(health.Path) {
+		return "proxy-health.path must be an absolute path without spaces, quotes, backslashes or #, like /status"
+	}
+	if health.Method != "" && HealthMethods[health.Method] != true {
+		return "proxy-health.method must be one of " + strings.Join(mapKeys(HealthMethods), ", ")
+	}
+	if health.Expect != 0 && (health.Expect < 100 || health.Expect > 599) {
+		return "proxy-health.expect must be an http status, like 200"
+	}
+	if unsafeConfValue(health.Host) {
+		return "proxy-health.host must not contain spaces, quotes, backslashes or #"
+	}
+	if d, err := time.ParseDuration(health.Interval); health.Interval != "" && (err != nil || d < time.Millisecond) {
+		return "proxy-health.interval must be a duration of 1ms or more, like 5s"
+	}
+	if health.Interval != "" && proxy.CheckInterval != "" {
+		return "proxy-health.interval and check.interval cannot be both set"
+	}
+	return ""
+}
+
+// unsafeConfValue tells if a value would break the haproxy line it is written on: spaces separate arguments, quotes
+// and backslashes are interpreted, and # starts a comment
+func unsafeConfValue(value string) bool {
+	for _, r := range value {
+		if r <= ' ' || r == 0x7f || strings.ContainsRune(`'"\#`, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSticky returns what is wrong with the sticky sessions of a service, empty when they are valid
+func checkSticky(sticky Sticky) string {
+	if sticky == (Sticky{}) {
+		return ""
+	}
+	if StickyTypes[sticky.Type] != true {
+		return "sticky.type must be one of " + strings.Join(mapKeys(StickyTypes), ", ")
+	}
+	if sticky.Cookie != "" && (sticky.Type != "cookie" || stickyCookieRegexp.MatchString(sticky.Cookie) == false) {
+		return "sticky.cookie must be a cookie name of letters, digits, _ and -, with the cookie type"
+	}
+	if d, err := time.ParseDuration(sticky.Expire); sticky.Expire != "" && (sticky.Type != "source" || err != nil || d < time.Second) {
+		return "sticky.expire must be a duration of 1s or more, like 30m, with the source type"
+	}
+	if d, err := time.ParseDuration(sticky.Drain); sticky.Drain != "" && (err != nil || d < 0 || d > MaxStickyDrain) {
+		return "sticky.drain must be a duration of 1h or less, like 30s, or 0s to stop the old containers at once"
+	}
+	return ""
+}
+
+// checkRateLimit returns what is wrong with the rate limit of a service, empty when it is valid
+func checkRateLimit(rateLimit RateLimit) string {
+	if rateLimit == (RateLimit{}) {
+		return ""
+	}
+	if rateLimit.Connections < 0 || rateLimit.Requests < 0 || rateLimit.Concurrent < 0 {
+		return "rate-limit.connections, requests and concurrent must be positive integers"
+	}
+	if rateLimit.Connections == 0 && rateLimit.Requests == 0 && rateLimit.Concurrent == 0 {
+		return "rate-limit needs connections, requests or concurrent"
+	}
+	if d, err := time.ParseDuration(rateLimit.Period); rateLimit.Period != "" && (err != nil || d < time.Second) {
+		return "rate-limit.period must be a duration of 1s or more, like 10s"
+	}
+	return ""
+}
+
+// checkSources returns what is wrong with a list of client ips, empty when it is valid
+func checkSources(name string, sources []string) string {
+	for _, source := range sources {
+		if _, _, err := net.ParseCIDR(source); err != nil && net.ParseIP(source) == nil {
+			return name + " must be a list of ip addresses or cidr ranges, like 10.0.0.0/8, not " + source
+		}
+	}
+	return ""
+}
+
+// HttpMode tells whether swapper-proxy reads the http requests of the service, to log them, hash their uri, insert
+// cookies or count them
+func (p ProxyOptions) HttpMode() bool {
+	return p.Mode == "http" || p.Balance == "uri" || p.Sticky.Type == "cookie" || p.RateLimit.Requests != 0
+}
+
+// DrainDuration returns how long the old containers of a sticky service keep their clients after a swap
+func (p ProxyOptions) DrainDuration() time.Duration {
+	if p.Sticky.Type == "" {
+		return 0
+	}
+	drain := p.Sticky.Drain
+	if drain == "" {
+		drain = DefaultStickyDrain
+	}
+	d, _ := time.ParseDuration(drain)
+	return d
+}
+
+// Milliseconds converts a duration of the proxy options for haproxy, which does not read durations like 1m30s
+func Milliseconds(duration string) string {
+	d, _ := time.ParseDuration(duration)
+	return strconv.FormatInt(int64(d/time.Millisecond), 10)
+}
+
+// proxySummary describes the options that differ from the defaults, for diffs
+func proxySummary(proxy ProxyOptions) string {
+	options := map[string]string{
+		"mode":             proxy.Mode,
+		"balance":          proxy.Balance,
+		"timeouts.connect": proxy.ConnectTimeout,
+		"timeouts.client":  proxy.ClientTimeout,
+		"timeouts.server":  proxy.ServerTimeout,
+		"check.interval":   proxy.CheckInterval,
+	}
+	if proxy.MaxConn != 0 {
+		options["maxconn"] = strconv.Itoa(proxy.MaxConn)
+	}
+	if proxy.CheckRise != 0 {
+		options["check.rise"] = strconv.Itoa(proxy.CheckRise)
+	}
+	if proxy.CheckFall != 0 {
+		options["check.fall"] = strconv.Itoa(proxy.CheckFall)
+	}
+	if proxy.SendProxy {
+		options["send-proxy"] = "true"
+	}
+	if sticky := proxy.Sticky; sticky.Type != "" {
+		options["sticky"] = strings.TrimSpace(strings.Join([]string{sticky.Type, sticky.Cookie}, " "))
+		if sticky.Expire != "" {
+			options["sticky"] += " expire " + sticky.Expire
+		}
+		if sticky.Drain != "" {
+			options["sticky"] += " drain " + sticky.Drain
+		}
+	}
+	if health := proxy.Health; health.Path != "" {
+		options["proxy-health"] = strings.TrimSpace(strings.Join([]string{health.Method, health.Path, health.Host}, " "))
+		if health.Expect != 0 {
+			options["proxy-health"] += " " + strconv.Itoa(health.Expect)
+		}
+		if health.Interval != "" {
+			options["proxy-health"] += " every " + health.Interval
+		}
+	}
+	if rateLimit := proxy.RateLimit; rateLimit != (RateLimit{}) {
+		var limits []string
+		for _, limit := range []struct {
+			name  string
+			value int
+		}{{"connections", rateLimit.Connections}, {"requests", rateLimit.Requests}, {"concurrent", rateLimit.Concurrent}} {
+			if limit.value != 0 {
+				limits = append(limits, strconv.Itoa(limit.value)+" "+limit.name)
+			}
+		}
+		options["rate-limit"] = strings.Join(limits, " ")
+		if rateLimit.Period != "" {
+			options["rate-limit"] += " per " + rateLimit.Period
+		}
+	}
+	options["allow"] = strings.Join(proxy.Allow, " ")
+	options["deny"] = strings.Join(proxy.Deny, " ")
+
+	var summary []string
+	for name, value := range options {
+		if value != "" {
+			summary = append(summary, name+"="+value)
+		}
+	}
+	sort.Strings(summary)
+	return strings.Join(summary, ", ")
+}
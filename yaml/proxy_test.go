@@ -0,0 +1,176 @@
+package yaml
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"reflect"
+	"testing"
+)
+
+func TestInterpretProxy(t *testing.T) {
+	yamlConf, err := ParseSwapperYaml("version: '1'\nservices:\n  api:\n    ports:\n      - 80:80\n      - 443:443\n    proxy:\n      balance: uri\n      timeouts:\n        client: 30s\n      maxconn: 2000\n      check:\n        rise: 2\n      send-proxy: true\n    proxy-health:\n      path: /status\n      expect: 204\n    containers:\n      - image: nginx\n        tag: 1.17.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ProxyOptions{Balance: "uri", ClientTimeout: "30s", MaxConn: 2000, CheckRise: 2, SendProxy: true, Health: ProxyHealth{Path: "/status", Expect: 204}}
+	if reflect.DeepEqual(yamlConf.Services[0].Proxy, expected) == false || reflect.DeepEqual(yamlConf.Frontends[0].Proxy, expected) == false || reflect.DeepEqual(yamlConf.Frontends[1].Proxy, expected) == false {
+		t.Error(yamlConf.Services[0].Proxy, yamlConf.Frontends)
+	}
+
+	_, err = ParseSwapperYaml("version: '2'\nservices:\n  api:\n    ports:\n      - 53:53/udp\n    proxy:\n      balance: uri\n    containers:\n      - image: nginx\n        tag: 1.17.0")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["proxy_invalid"], "api", "balance uri hashes http requests, it cannot be used with udp ports") {
+		t.Error(err)
+	}
+}
+
+func TestCheckProxy(t *testing.T) {
+	invalid := map[string]ProxyOptions{
+		"balance must be one of leastconn, roundrobin, source, uri":                                         {Balance: "random"},
+		"timeouts.connect must be a duration of 1ms or more, like 5s":                                       {ConnectTimeout: "5"},
+		"check.interval must be a duration of 1ms or more, like 5s":                                         {CheckInterval: "10us"},
+		"maxconn must be a positive integer":                                                                {MaxConn: -1},
+		"check.fall must be a positive integer":                                                             {CheckFall: -3},
+		"send-proxy cannot be used with udp ports":                                                          {SendProxy: true},
+		"proxy-health.path must be an absolute path without spaces, quotes, backslashes or #, like /status": {Health: ProxyHealth{Path: "status"}},
+		"proxy-health.method must be one of GET, HEAD, OPTIONS":                                             {Health: ProxyHealth{Method: "POST", Path: "/"}},
+		"proxy-health.expect must be an http status, like 200":                                              {Health: ProxyHealth{Path: "/", Expect: 20}},
+		"proxy-health.host must not contain spaces, quotes, backslashes or #":                               {Health: ProxyHealth{Path: "/", Host: "a b"}},
+		"sticky.type must be one of cookie, source":                                                         {Sticky: Sticky{Cookie: "ID"}},
+		"sticky.cookie must be a cookie name of letters, digits, _ and -, with the cookie type":             {Sticky: Sticky{Type: "source", Cookie: "ID"}},
+		"sticky.expire must be a duration of 1s or more, like 30m, with the source type":                    {Sticky: Sticky{Type: "cookie", Expire: "30m"}},
+		"sticky.drain must be a duration of 1h or less, like 30s, or 0s to stop the old containers at once": {Sticky: Sticky{Type: "source", Drain: "61m"}},
+		"sticky cookies need http, they cannot be used with udp ports":                                      {Sticky: Sticky{Type: "cookie"}},
+		"mode must be one of http, tcp":                                                                     {Mode: "udp"},
+		"balance uri and sticky cookies need http, they cannot be used with mode tcp":                       {Mode: "tcp", Sticky: Sticky{Type: "cookie"}},
+		"mode http cannot be used with udp ports":                                                           {Mode: "http"},
+		"rate-limit.connections, requests and concurrent must be positive integers":                         {RateLimit: RateLimit{Connections: -1}},
+		"rate-limit needs connections, requests or concurrent":                                              {RateLimit: RateLimit{Period: "1m"}},
+		"rate-limit.period must be a duration of 1s or more, like 10s":                                      {RateLimit: RateLimit{Concurrent: 5, Period: "500ms"}},
+		"rate-limit.requests counts http requests, it cannot be used with mode tcp":                         {Mode: "tcp", RateLimit: RateLimit{Requests: 100}},
+		"rate-limit cannot be used with udp ports":                                                          {RateLimit: RateLimit{Connections: 20}},
+		"allow must be a list of ip addresses or cidr ranges, like 10.0.0.0/8, not 10.0.0.0/33":             {Allow: []string{"10.0.0.0/8", "10.0.0.0/33"}},
+		"deny must be a list of ip addresses or cidr ranges, like 10.0.0.0/8, not example.com":              {Deny: []string{"example.com"}},
+		"proxy-health.interval and check.interval cannot be both set":                                       {CheckInterval: "1s", Health: ProxyHealth{Path: "/", Interval: "2s"}},
+	}
+	for message, proxy := range invalid {
+		err := checkProxy(proxy, "api", []string{"80:80", "53:53/udp"})
+		if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["proxy_invalid"], "api", message) {
+			t.Errorf("%s: %v", message, err)
+		}
+	}
+
+	// the path and host are written in the haproxy conf
+	for _, value := range []string{"/a'b", `/a"b`, `/a\b`, "/a#b", "/a\x00b", "/a\tb", "/a\x7fb"} {
+		if err := checkProxy(ProxyOptions{Health: ProxyHealth{Path: value}}, "api", []string{"80:80"}); err == nil {
+			t.Errorf("path %q", value)
+		}
+		if err := checkProxy(ProxyOptions{Health: ProxyHealth{Path: "/", Host: value[1:]}}, "api", []string{"80:80"}); err == nil {
+			t.Errorf("host %q", value[1:])
+		}
+	}
+
+	valid := ProxyOptions{Sticky: Sticky{Type: "source", Expire: "1h", Drain: "0s"}}
+	if err := checkProxy(valid, "api", []string{"80:80", "53:53/udp"}); err != nil || valid.HttpMode() || valid.DrainDuration() != 0 {
+		t.Error(err)
+	}
+	valid = ProxyOptions{Balance: "leastconn", ConnectTimeout: "1s", ServerTimeout: "1m30s", MaxConn: 100, CheckInterval: "500ms", CheckRise: 1, CheckFall: 1}
+	if err := checkProxy(valid, "api", []string{"80:80", "53:53/udp"}); err != nil {
+		t.Error(err)
+	}
+	valid = ProxyOptions{RateLimit: RateLimit{Requests: 100, Period: "1m"}, Allow: []string{"10.0.0.0/8", "::1"}, Deny: []string{"10.1.2.3"}}
+	if err := checkProxy(valid, "api", []string{"80:80"}); err != nil || valid.HttpMode() == false {
+		t.Error(err)
+	}
+}
+
+func TestProxySummary(t *testing.T) {
+	if proxySummary(ProxyOptions{}) != "" {
+		t.Fail()
+	}
+	summary := proxySummary(ProxyOptions{Balance: "source", ServerTimeout: "1m", CheckFall: 3, SendProxy: true})
+	if summary != "balance=source, check.fall=3, send-proxy=true, timeouts.server=1m" {
+		t.Error(summary)
+	}
+	summary = proxySummary(ProxyOptions{Health: ProxyHealth{Path: "/status", Host: "api.example.com", Expect: 204, Interval: "2s"}})
+	if summary != "proxy-health=/status api.example.com 204 every 2s" {
+		t.Error(summary)
+	}
+	summary = proxySummary(ProxyOptions{RateLimit: RateLimit{Connections: 20, Concurrent: 5, Period: "1m"}, Deny: []string{"10.1.2.3", "10.1.2.4"}})
+	if summary != "deny=10.1.2.3 10.1.2.4, rate-limit=20 connections 5 concurrent per 1m" {
+		t.Error(summary)
+	}
+	if Milliseconds("1m30s") != "90000" || Milliseconds("500ms") != "500" {
+		t.Fail()
+	}
+}
+
+func TestInterpretProxyStats(t *testing.T) {
+	services := "services:\n  api:\n    ports:\n      - 80:80\n    containers:\n      - image: nginx\n        tag: 1.17.0\n"
+	yamlConf, err := ParseSwapperYaml("version: '1'\nproxy-stats:\n  address: 127.0.0.1\n  port: 8404\n  username: admin\n  password: s3cr3t\n" + services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if yamlConf.ProxyStats != (ProxyStats{Address: "127.0.0.1", Port: 8404, Username: "admin", Password: "s3cr3t"}) {
+		t.Error(yamlConf.ProxyStats)
+	}
+	yamlConf, err = ParseSwapperYaml("version: '2'\nproxy_stats:\n  port: 8404\n  username: admin\n  password: s3cr3t\n" + services)
+	if err != nil || yamlConf.ProxyStats.Binding().Published() != "8404/tcp" {
+		t.Error(err, yamlConf.ProxyStats)
+	}
+
+	invalid := map[string]ProxyStats{
+		"port must be a port number":                                                      {Port: 70000, Username: "admin", Password: "s3cr3t"},
+		"address must be an ip address of the node":                                       {Address: "localhost", Port: 8404, Username: "admin", Password: "s3cr3t"},
+		"username and password are required":                                              {Port: 8404, Username: "admin"},
+		"port 80 is already bound by service api":                                         {Port: 80, Username: "admin", Password: "s3cr3t"},
+		"username and password cannot contain spaces or quotes, nor the username a colon": {Port: 8404, Username: "ad:min", Password: "s3cr3t"},
+	}
+	for message, stats := range invalid {
+		err := checkProxyStats(stats, yamlConf.Frontends)
+		if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["proxy_stats_invalid"], message) {
+			t.Errorf("%s: %v", message, err)
+		}
+	}
+}
+
+func TestInterpretProxyLogs(t *testing.T) {
+	services := "services:\n  api:\n    ports:\n      - 80:80\n    containers:\n      - image: nginx\n        tag: 1.17.0\n"
+	yamlConf, err := ParseSwapperYaml("version: '1'\nproxy-logs:\n  destination: syslog\n  address: logs.example.com:514\n  facility: local3\n" + services)
+	if err != nil || yamlConf.ProxyLogs != (ProxyLogs{Destination: "syslog", Address: "logs.example.com:514", Facility: "local3"}) {
+		t.Error(err, yamlConf.ProxyLogs)
+	}
+	yamlConf, err = ParseSwapperYaml("version: '2'\nproxy_logs:\n  destination: file\n  path: /var/log/swapper\n" + services)
+	if err != nil || yamlConf.ProxyLogs != (ProxyLogs{Destination: "file", Path: "/var/log/swapper"}) {
+		t.Error(err, yamlConf.ProxyLogs)
+	}
+
+	invalid := map[string]ProxyLogs{
+		"destination must be one of file, stdout, syslog":                                                      {Destination: "kafka"},
+		"address must look like host[:port], with the syslog destination only":                                 {Destination: "syslog"},
+		"path must be an absolute directory of the node without spaces, with the file destination only":        {Destination: "stdout", Path: "/var/log"},
+		"facility must be one of daemon, local0, local1, local2, local3, local4, local5, local6, local7, user": {Destination: "stdout", Facility: "mail"},
+	}
+	for message, logs := range invalid {
+		err := checkProxyLogs(logs)
+		if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["proxy_logs_invalid"], message) {
+			t.Errorf("%s: %v", message, err)
+		}
+	}
+}
+
+func TestInterpretRateLimit(t *testing.T) {
+	expected := ProxyOptions{RateLimit: RateLimit{Connections: 20, Requests: 100, Period: "1m"}, Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.1.2.3"}}
+	yamlConf, err := ParseSwapperYaml("version: '1'\nservices:\n  api:\n    ports:\n      - 80:80\n    rate-limit:\n      connections: 20\n      requests: 100\n      period: 1m\n    allow:\n      - 10.0.0.0/8\n    deny:\n      - 10.1.2.3\n    containers:\n      - image: nginx\n        tag: 1.17.0")
+	if err != nil || reflect.DeepEqual(yamlConf.Frontends[0].Proxy, expected) == false {
+		t.Error(err, yamlConf.Frontends)
+	}
+	yamlConf, err = ParseSwapperYaml("version: '2'\nservices:\n  api:\n    ports:\n      - 80:80\n    rate_limit:\n      connections: 20\n      requests: 100\n      period: 1m\n    allow:\n      - 10.0.0.0/8\n    deny:\n      - 10.1.2.3\n    containers:\n      - image: nginx\n        tag: 1.17.0")
+	if err != nil || reflect.DeepEqual(yamlConf.Frontends[0].Proxy, expected) == false {
+		t.Error(err, yamlConf.Frontends)
+	}
+
+	_, err = ParseSwapperYaml("version: '1'\nservices:\n  dns:\n    ports:\n      - 53:53/udp\n    rate-limit:\n      concurrent: 5\n    containers:\n      - image: coredns/coredns\n        tag: 1.6.9")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["proxy_invalid"], "dns", "rate-limit cannot be used with udp ports") {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,151 @@
+package yaml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	yamlv3 "gopkg.in/yaml.v3"
+	"regexp"
+	"strings"
+)
+
+const (
+	// SecretPrefix marks a plaintext value that swapper deploy encrypts with the public key
+	SecretPrefix = "secret:"
+	// EncryptedPrefix marks a value encrypted with the public key, that only nodes holding the private key decrypt
+	EncryptedPrefix = "enc:"
+)
+
+// EncryptedRegexp matches the enc: values, with the fingerprint of their plaintext before the ciphertext when they
+// have one
+var EncryptedRegexp = regexp.MustCompile(regexp.QuoteMeta(EncryptedPrefix) + `(?:([a-f0-9]{16}):)?([A-Za-z0-9+/=]+)`)
+
+// SplitEncrypted returns the fingerprint and the ciphertext of an enc: value, the fingerprint is empty when the value
+// has none
+func SplitEncrypted(value string) (fingerprint string, ciphertext string) {
+	matches := EncryptedRegexp.FindStringSubmatch(value)
+	if matches == nil || matches[0] != value {
+		return "", strings.TrimPrefix(value, EncryptedPrefix)
+	}
+	return matches[1], matches[2]
+}
+
+// EvalCommands evaluates the $() expressions of a yaml configuration, in the fields where nodes evaluate them
+// (environment, logging options and extra_hosts of containers), and returns the effective yaml
+func EvalCommands(input string, eval func(string) (string, error)) (output string, err error) {
+	return replaceContainerScalars(input, commandFields, func(value string) bool {
+		return strings.Contains(value, "$(")
+	}, eval)
+}
+
+// EncryptSecrets replaces the secret: values of the containers environment, and the secret of their files which are
+// not encrypted yet, by their encrypted enc: value. A file secret is encrypted already when its enc: value decodes, a
+// plaintext which only starts with enc: is encrypted too
+func EncryptSecrets(input string, encrypt func(string) (string, error)) (output string, err error) {
+	output, err = replaceContainerScalars(input, secretFields, func(value string) bool {
+		return strings.HasPrefix(value, SecretPrefix)
+	}, func(value string) (string, error) {
+		return encrypt(strings.TrimPrefix(value, SecretPrefix))
+	})
+	if err != nil {
+		return output, err
+	}
+	return replaceContainerScalars(output, secretFileFields, func(value string) bool {
+		return encrypted(value) == false
+	}, func(value string) (string, error) {
+		return encrypt(strings.TrimPrefix(value, SecretPrefix))
+	})
+}
+
+func encrypted(value string) bool {
+	if strings.HasPrefix(value, EncryptedPrefix) == false {
+		return false
+	}
+	_, ciphertext := SplitEncrypted(value)
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	return err == nil && len(data) > 0
+}
+
+func commandFields(container *yamlv3.Node) (scalars []*yamlv3.Node) {
+	for _, env := range mappingPairs(mappingValue(container, "environment")) {
+		scalars = append(scalars, resolveAlias(env[1]))
+	}
+	logging := mappingValue(container, "logging")
+	for _, option := range mappingPairs(mappingValue(logging, "options")) {
+		scalars = append(scalars, resolveAlias(option[1]))
+	}
+	if extraHosts := mappingValue(container, "extra_hosts"); extraHosts != nil && extraHosts.Kind == yamlv3.SequenceNode {
+		for _, extraHost := range extraHosts.Content {
+			scalars = append(scalars, resolveAlias(extraHost))
+		}
+	}
+	return scalars
+}
+
+func secretFields(container *yamlv3.Node) (scalars []*yamlv3.Node) {
+	for _, env := range mappingPairs(mappingValue(container, "environment")) {
+		scalars = append(scalars, resolveAlias(env[1]))
+	}
+	return scalars
+}
+
+func secretFileFields(container *yamlv3.Node) (scalars []*yamlv3.Node) {
+	if files := mappingValue(container, "files"); files != nil && files.Kind == yamlv3.SequenceNode {
+		for _, file := range files.Content {
+			scalars = append(scalars, mappingValue(file, "secret"))
+		}
+	}
+	return scalars
+}
+
+// replaceContainerScalars replaces the matching scalars of the given fields of each container, and returns the
+// input untouched when nothing matches
+func replaceContainerScalars(input string, fields func(container *yamlv3.Node) []*yamlv3.Node, match func(string) bool, replace func(string) (string, error)) (output string, err error) {
+	var document yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(input), &document); err != nil {
+		return output, errors.New(fmt.Sprintf(response.ErrorMessages["yaml_schema"], err.Error()))
+	}
+	if len(document.Content) == 0 {
+		return input, nil
+	}
+
+	var scalars []*yamlv3.Node
+	services := mappingValue(document.Content[0], "services")
+	for _, service := range mappingPairs(services) {
+		// the environment of the service is merged into its containers
+		scalars = append(scalars, fields(resolveAlias(service[1]))...)
+		containers := mappingValue(service[1], "containers")
+		if containers == nil || containers.Kind != yamlv3.SequenceNode {
+			continue
+		}
+		for _, container := range containers.Content {
+			scalars = append(scalars, fields(resolveAlias(container))...)
+		}
+	}
+
+	replaced := map[*yamlv3.Node]bool{}
+	for _, scalar := range scalars {
+		if scalar == nil || scalar.Kind != yamlv3.ScalarNode || replaced[scalar] || match(scalar.Value) == false {
+			continue
+		}
+		replaced[scalar] = true
+		scalar.Value, err = replace(scalar.Value)
+		if err != nil {
+			return output, err
+		}
+	}
+	if len(replaced) == 0 {
+		return input, nil
+	}
+
+	var buffer bytes.Buffer
+	encoder := yamlv3.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return output, err
+	}
+	_ = encoder.Close()
+	return buffer.String(), nil
+}
@@ -0,0 +1,94 @@
+package yaml
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestEvalCommands(t *testing.T) {
+	input, _ := ioutil.ReadFile("../doc/yml-examples/7.with.command.yml")
+	var evaluated []string
+	eval := func(value string) (string, error) {
+		evaluated = append(evaluated, value)
+		return strings.Replace(value, "$(hostname)", "node-1", -1), nil
+	}
+
+	output, err := EvalCommands(string(input), eval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evaluated) != 4 || strings.Contains(output, "fluentd-address: node-1:24224") == false {
+		t.Errorf("unexpected output: %s", output)
+	}
+	if _, err := ParseSwapperYaml(output); err != nil {
+		t.Fail()
+	}
+
+	// $() outside of the fields evaluated by nodes are left as is
+	input = []byte("version: '1'\nservices:\n  web:\n    ports:\n      - 80:80\n    containers:\n      - image: nginx\n        tag: $(hostname)\n")
+	output, _ = EvalCommands(string(input), eval)
+	if strings.Contains(output, "tag: $(hostname)") == false {
+		t.Fail()
+	}
+
+	_, err = EvalCommands("version: '1'\nservices:\n  web:\n    containers:\n      - extra_hosts:\n          - $(false)\n", func(value string) (string, error) {
+		return value, errors.New("failed")
+	})
+	if err == nil || err.Error() != "failed" {
+		t.Fail()
+	}
+}
+
+func TestEncryptSecrets(t *testing.T) {
+	input, _ := ioutil.ReadFile("../doc/yml-examples/9.with.secrets.yml")
+	cleanYaml, _ := ReplaceVars(string(input), []string{"DB_PASSWORD=p@ss"})
+	output, err := EncryptSecrets(cleanYaml, func(plaintext string) (string, error) {
+		return EncryptedPrefix + strings.ToUpper(plaintext), nil
+	})
+	if err != nil || strings.Contains(output, "DB_PASSWORD: enc:P@SS") == false || strings.Contains(output, "p@ss") {
+		t.Errorf("unexpected output: %s", output)
+	}
+
+	// the secret of files is encrypted, unless it is already
+	input, _ = ioutil.ReadFile("../doc/yml-examples/10.with.files.yml")
+	cleanYaml, _ = ReplaceVars(string(input), []string{"TLS_KEY=my-key"})
+	output, err = EncryptSecrets(cleanYaml, func(plaintext string) (string, error) {
+		return EncryptedPrefix + strings.ToUpper(plaintext), nil
+	})
+	if err != nil || strings.Contains(output, "secret: enc:MY-KEY") == false {
+		t.Errorf("unexpected output: %s", output)
+	}
+	cleanYaml, _ = ReplaceVars(string(input), []string{"TLS_KEY=enc:a2V5"})
+	output, _ = EncryptSecrets(cleanYaml, func(plaintext string) (string, error) {
+		return "", errors.New("already encrypted")
+	})
+	if output != cleanYaml {
+		t.Errorf("unexpected output: %s", output)
+	}
+	cleanYaml, _ = ReplaceVars(string(input), []string{"TLS_KEY=enc:my key"})
+	output, err = EncryptSecrets(cleanYaml, func(plaintext string) (string, error) {
+		return EncryptedPrefix + strings.ToUpper(plaintext), nil
+	})
+	if err != nil || strings.Contains(output, "secret: enc:ENC:MY KEY") == false {
+		t.Errorf("unexpected output: %s", output)
+	}
+
+	// the environment of services too
+	output, _ = EncryptSecrets("version: '1'\nservices:\n  api:\n    environment:\n      DB_PASSWORD: secret:p@ss\n", func(plaintext string) (string, error) {
+		return EncryptedPrefix + strings.ToUpper(plaintext), nil
+	})
+	if strings.Contains(output, "DB_PASSWORD: enc:P@SS") == false {
+		t.Errorf("unexpected output: %s", output)
+	}
+
+	// nothing to encrypt, the yaml is untouched
+	input, _ = ioutil.ReadFile("tests/v1/valid.2.yml")
+	output, _ = EncryptSecrets(string(input), func(plaintext string) (string, error) {
+		return "", errors.New("no secret")
+	})
+	if output != string(input) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,467 @@
+package yaml
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/sachamorard/swapper/response"
+	"sort"
+)
+
+// Field describes what a key of the swapper yaml may contain. The v1 definitions below are the
+// reference for the validator, and InterpretV1 reads the documents through them.
+type Field struct {
+	Type        string
+	Format      string
+	Description string
+	Required    bool
+	Enum        []string
+	// Fields lists the known keys of an object
+	Fields map[string]*Field
+	// Items describes the values of an array, or of an object with free keys (like environment)
+	Items *Field
+	// IntString accepts an integer written as a string (like health-retries: "2")
+	IntString bool
+	// Templates accepts the x- keys of an object, ignored but usable as anchors
+	Templates bool
+}
+
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeObject  = "object"
+	TypeArray   = "array"
+	TypeScalar  = "scalar"
+
+	FormatDuration = "duration"
+	FormatPort     = "port"
+	FormatMode     = "mode"
+
+	durationPattern = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+	modePattern     = `^0?[0-7]{3}$`
+)
+
+var (
+	notificationsField = &Field{
+		Type:        TypeArray,
+		Description: "Notification sinks",
+		Items: &Field{
+			Type: TypeObject,
+			Fields: map[string]*Field{
+				"type":     {Type: TypeString, Required: true, Enum: mapKeys(NotificationTypes)},
+				"url":      {Type: TypeString, Description: "Webhook url (all types but smtp)"},
+				"channel":  {Type: TypeString},
+				"headers":  {Type: TypeObject, Items: &Field{Type: TypeString}},
+				"events":   {Type: TypeArray, Items: &Field{Type: TypeString, Enum: mapKeys(NotificationEvents)}},
+				"retries":  {Type: TypeInteger},
+				"backoff":  {Type: TypeString, Format: FormatDuration},
+				"template": {Type: TypeString, Description: "Go text/template of the message"},
+				"server":   {Type: TypeString, Description: "SMTP server (smtp type)"},
+				"port":     {Type: TypeInteger, Description: "SMTP port (smtp type)"},
+				"starttls": {Type: TypeBoolean},
+				"username": {Type: TypeString},
+				"password": {Type: TypeString},
+				"from":     {Type: TypeString},
+				"recipients": {
+					Type:        TypeObject,
+					Description: "Recipients per event (smtp type)",
+					Fields: map[string]*Field{
+						"deploy":       {Type: TypeArray, Items: &Field{Type: TypeString}},
+						"node-updated": {Type: TypeArray, Items: &Field{Type: TypeString}},
+						"node-failed":  {Type: TypeArray, Items: &Field{Type: TypeString}},
+						"rollback":     {Type: TypeArray, Items: &Field{Type: TypeString}},
+					},
+				},
+			},
+		},
+	}
+	portsField       = &Field{Type: TypeArray, Required: true, Description: "Bindings \"[address:]listen:container[/udp]\", listen and container may be ranges like 8000-8010", Items: &Field{Type: TypeString, Format: FormatPort}}
+	environmentField = &Field{Type: TypeObject, Items: &Field{Type: TypeScalar}}
+	envFileField     = &Field{Type: TypeArray, Description: "Env files inlined by swapper deploy, relative to the yaml file", Items: &Field{Type: TypeString}}
+	loggingField     = &Field{
+		Type: TypeObject,
+		Fields: map[string]*Field{
+			"driver":  {Type: TypeString},
+			"options": {Type: TypeObject, Items: &Field{Type: TypeString}},
+		},
+	}
+	extraHostsField = &Field{Type: TypeArray, Items: &Field{Type: TypeString}}
+	includeField    = &Field{Type: TypeArray, Description: "Yaml files merged into this one by swapper deploy, relative to the yaml file", Items: &Field{Type: TypeString}}
+	filesField      = &Field{
+		Type:        TypeArray,
+		Description: "Files mounted read-only into the container",
+		Items: &Field{
+			Type: TypeObject,
+			Fields: map[string]*Field{
+				"path":    {Type: TypeString, Required: true, Description: "Absolute path in the container"},
+				"content": {Type: TypeString, Description: "Literal content"},
+				"secret":  {Type: TypeString, Description: "Content encrypted by swapper deploy (secret: or enc: value)"},
+				"source":  {Type: TypeString, Description: "Path of the file on the node"},
+				"mode":    {Type: TypeString, Format: FormatMode, Description: "Octal permissions, quoted [default: \"0444\"]"},
+			},
+		},
+	}
+)
+
+// proxyHealthField describes the http health check of the containers of a service by the proxy
+var proxyHealthField = &Field{
+	Type:        TypeObject,
+	Description: "Http health check of the containers by swapper-proxy, instead of layer 4 checks",
+	Fields: map[string]*Field{
+		"method":   {Type: TypeString, Enum: mapKeys(HealthMethods), Description: "[default: GET]"},
+		"path":     {Type: TypeString, Required: true, Description: "Path of the request, like /status"},
+		"expect":   {Type: TypeInteger, Description: "Expected http status [default: 200]"},
+		"host":     {Type: TypeString, Description: "Host header of the request"},
+		"interval": {Type: TypeString, Format: FormatDuration},
+	},
+}
+
+// proxyLogsField describes the access logs of swapper-proxy
+var proxyLogsField = &Field{
+	Type:        TypeObject,
+	Description: "Access logs of swapper-proxy, tcp or http format depending on the mode of services",
+	Fields: map[string]*Field{
+		"destination": {Type: TypeString, Required: true, Enum: mapKeys(LogDestinations), Description: "docker logs of swapper-proxy, a syslog server or files of the node"},
+		"address":     {Type: TypeString, Description: "host[:port] of the syslog server (syslog destination)"},
+		"path":        {Type: TypeString, Description: "Directory of the node receiving the log files (file destination)"},
+		"facility":    {Type: TypeString, Enum: mapKeys(LogFacilities), Description: "[default: local0]"},
+	},
+}
+
+// proxyStatsField describes the stats dashboard of swapper-proxy
+var proxyStatsField = &Field{
+	Type:        TypeObject,
+	Description: "Stats dashboard of swapper-proxy, with basic authentication",
+	Fields: map[string]*Field{
+		"port":     {Type: TypeInteger, Required: true},
+		"address":  {Type: TypeString, Description: "Address of the node the dashboard is published on [default: all]"},
+		"username": {Type: TypeString, Required: true},
+		"password": {Type: TypeString, Required: true, Description: "Better set with a variable, like ${STATS_PASSWORD}"},
+	},
+}
+
+// rateLimitField describes the rate limit of each client ip of a service
+var rateLimitField = &Field{
+	Type:        TypeObject,
+	Description: "Limits of each client ip, tcp ports only",
+	Fields: map[string]*Field{
+		"connections": {Type: TypeInteger, Description: "New connections per period"},
+		"requests":    {Type: TypeInteger, Description: "Http requests per period, answered 429 beyond (http)"},
+		"concurrent":  {Type: TypeInteger, Description: "Simultaneous connections"},
+		"period":      {Type: TypeString, Format: FormatDuration, Description: "[default: 10s]"},
+	},
+}
+
+// sourcesField describes a list of client ips, like allow and deny
+func sourcesField(description string) *Field {
+	return &Field{Type: TypeArray, Description: description, Items: &Field{Type: TypeString}}
+}
+
+// stickyField describes the sticky sessions of a service
+var stickyField = &Field{
+	Type:        TypeObject,
+	Description: "Keep each client on the same container",
+	Fields: map[string]*Field{
+		"type":   {Type: TypeString, Required: true, Enum: mapKeys(StickyTypes), Description: "source ip stick table, or cookie inserted by the proxy (http)"},
+		"cookie": {Type: TypeString, Description: "Name of the cookie [default: SWAPPERID]"},
+		"expire": {Type: TypeString, Format: FormatDuration, Description: "How long a source ip is remembered [default: 30m]"},
+		"drain":  {Type: TypeString, Format: FormatDuration, Description: "How long old containers keep their clients after a swap, 1h at most [default: 30s]"},
+	},
+}
+
+// proxyField describes the proxy options of a service, sendProxy is the name of the PROXY protocol key of the version
+func proxyField(sendProxy string) *Field {
+	return &Field{
+		Type:        TypeObject,
+		Description: "Options of swapper-proxy for the ports of the service",
+		Fields: map[string]*Field{
+			"mode":    {Type: TypeString, Enum: mapKeys(ProxyModes), Description: "http parses and logs the requests [default: tcp]"},
+			"balance": {Type: TypeString, Enum: mapKeys(BalanceAlgorithms), Description: "Load balancing algorithm, uri for http only [default: roundrobin]"},
+			"timeouts": {
+				Type: TypeObject,
+				Fields: map[string]*Field{
+					"connect": {Type: TypeString, Format: FormatDuration},
+					"client":  {Type: TypeString, Format: FormatDuration},
+					"server":  {Type: TypeString, Format: FormatDuration},
+				},
+			},
+			"maxconn": {Type: TypeInteger, Description: "Maximum concurrent connections per port [default: 800]"},
+			"check": {
+				Type:        TypeObject,
+				Description: "Health checks of the containers by the proxy",
+				Fields: map[string]*Field{
+					"interval": {Type: TypeString, Format: FormatDuration},
+					"rise":     {Type: TypeInteger},
+					"fall":     {Type: TypeInteger},
+				},
+			},
+			sendProxy: {Type: TypeBoolean, Description: "Send the PROXY protocol header to the containers"},
+		},
+	}
+}
+
+var V1Schema = &Field{
+	Type:      TypeObject,
+	Templates: true,
+	Fields: map[string]*Field{
+		"include":  includeField,
+		"version":  {Type: TypeString, Required: true, Enum: []string{"1"}, Description: "Version of the swapper yaml format"},
+		"hash":     {Type: TypeString, Description: "Set by masters, hash of the deployed configuration"},
+		"time":     {Type: TypeInteger, Description: "Set by masters, deployment time in nanoseconds"},
+		"deployer": {Type: TypeString, Description: "Set by swapper deploy, who deployed the configuration"},
+		"masters":  {Type: TypeArray, Description: "Set by masters, hostnames of the masters", Items: &Field{Type: TypeString}},
+		"master": {
+			Type:        TypeObject,
+			Description: "Where the configuration is stored",
+			Fields: map[string]*Field{
+				"driver":           {Type: TypeString, Enum: []string{"local", "gcp"}, Description: "local masters or Google Cloud Storage"},
+				"project-id":       {Type: TypeString, Description: "GCP project of the bucket (gcp driver)"},
+				"credentials-file": {Type: TypeString, Description: "GCP credentials file (gcp driver)"},
+			},
+		},
+		"slack": {
+			Type:        TypeObject,
+			Description: "Slack notifications (deprecated, use notifications)",
+			Fields: map[string]*Field{
+				"webhook-url": {Type: TypeString},
+				"channel":     {Type: TypeString},
+			},
+		},
+		"notifications": notificationsField,
+		"proxy-stats":   proxyStatsField,
+		"proxy-logs":    proxyLogsField,
+		"services": {
+			Type:        TypeObject,
+			Required:    true,
+			Description: "Services, by name",
+			Items: &Field{
+				Type: TypeObject,
+				Fields: map[string]*Field{
+					"ports":        portsField,
+					"environment":  {Type: TypeObject, Description: "Environment of all the containers of the service", Items: &Field{Type: TypeScalar}},
+					"env_file":     envFileField,
+					"proxy":        proxyField("send-proxy"),
+					"proxy-health": proxyHealthField,
+					"sticky":       stickyField,
+					"rate-limit":   rateLimitField,
+					"allow":        sourcesField("Only accept these ip addresses or cidr ranges of clients"),
+					"deny":         sourcesField("Reject these ip addresses or cidr ranges of clients"),
+					"containers": {
+						Type: TypeArray,
+						Items: &Field{
+							Type: TypeObject,
+							Fields: map[string]*Field{
+								"image":           {Type: TypeString, Required: true},
+								"tag":             {Type: TypeString, Required: true},
+								"weight":          {Type: TypeInteger, Description: "Load balancing weight [default: 100]"},
+								"environment":     environmentField,
+								"env_file":        envFileField,
+								"logging":         loggingField,
+								"health-cmd":      {Type: TypeString},
+								"health-interval": {Type: TypeString, Format: FormatDuration},
+								"health-timeout":  {Type: TypeString, Format: FormatDuration},
+								"health-retries":  {Type: TypeInteger, IntString: true},
+								"extra_hosts":     extraHostsField,
+								"files":           filesField,
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+// V2Schema describes the fields of the V2 structs, for swapper validate and the JSON Schema
+var V2Schema = &Field{
+	Type:      TypeObject,
+	Templates: true,
+	Fields: map[string]*Field{
+		"include":  includeField,
+		"version":  {Type: TypeString, Required: true, Enum: []string{"2"}, Description: "Version of the swapper yaml format"},
+		"hash":     V1Schema.Fields["hash"],
+		"time":     V1Schema.Fields["time"],
+		"deployer": V1Schema.Fields["deployer"],
+		"masters":  V1Schema.Fields["masters"],
+		"master": {
+			Type:        TypeObject,
+			Description: "Where the configuration is stored",
+			Fields: map[string]*Field{
+				"driver":           {Type: TypeString, Enum: []string{"local", "gcp"}, Description: "local masters or Google Cloud Storage"},
+				"project_id":       {Type: TypeString, Description: "GCP project of the bucket (gcp driver)"},
+				"credentials_file": {Type: TypeString, Description: "GCP credentials file (gcp driver)"},
+			},
+		},
+		"notifications": notificationsField,
+		"proxy_stats":   proxyStatsField,
+		"proxy_logs":    proxyLogsField,
+		"services": {
+			Type:        TypeObject,
+			Required:    true,
+			Description: "Services, by name",
+			Items: &Field{
+				Type: TypeObject,
+				Fields: map[string]*Field{
+					"ports":        portsField,
+					"environment":  {Type: TypeObject, Description: "Environment of all the containers of the service", Items: &Field{Type: TypeScalar}},
+					"env_file":     envFileField,
+					"proxy":        proxyField("send_proxy"),
+					"proxy_health": proxyHealthField,
+					"sticky":       stickyField,
+					"rate_limit":   rateLimitField,
+					"allow":        sourcesField("Only accept these ip addresses or cidr ranges of clients"),
+					"deny":         sourcesField("Reject these ip addresses or cidr ranges of clients"),
+					"containers": {
+						Type: TypeArray,
+						Items: &Field{
+							Type: TypeObject,
+							Fields: map[string]*Field{
+								"image":       {Type: TypeString, Required: true},
+								"tag":         {Type: TypeString, Required: true},
+								"weight":      {Type: TypeInteger, Description: "Load balancing weight [default: 100]"},
+								"environment": environmentField,
+								"env_file":    envFileField,
+								"logging":     loggingField,
+								"healthcheck": {
+									Type: TypeObject,
+									Fields: map[string]*Field{
+										"cmd":      {Type: TypeString, Required: true},
+										"interval": {Type: TypeString, Format: FormatDuration},
+										"timeout":  {Type: TypeString, Format: FormatDuration},
+										"retries":  {Type: TypeInteger},
+									},
+								},
+								"extra_hosts": extraHostsField,
+								"files":       filesField,
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+// Schema returns the definitions of a version of the swapper yaml format, nil for unknown versions
+func Schema(version string) *Field {
+	switch version {
+	case "1":
+		return V1Schema
+	case "2":
+		return V2Schema
+	}
+	return nil
+}
+
+// JSONSchema generates the JSON Schema (draft-07) of a swapper yaml format from its definitions
+func JSONSchema(version string) ([]byte, error) {
+	if Schema(version) == nil {
+		return nil, errors.New(response.ErrorMessages["yaml_version"])
+	}
+	schema := Schema(version).jsonSchema()
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["$id"] = "https://raw.githubusercontent.com/SachaMorard/swapper/master/doc/swapper.v" + version + ".schema.json"
+	schema["title"] = "Swapper yaml v" + version
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func (f *Field) jsonSchema() map[string]interface{} {
+	schema := map[string]interface{}{}
+	if f.Description != "" {
+		schema["description"] = f.Description
+	}
+
+	switch f.Type {
+	case TypeObject:
+		schema["type"] = TypeObject
+		if f.Fields != nil {
+			properties := map[string]interface{}{}
+			var required []string
+			for _, name := range f.FieldNames() {
+				properties[name] = f.Fields[name].jsonSchema()
+				if f.Fields[name].Required {
+					required = append(required, name)
+				}
+			}
+			schema["properties"] = properties
+			schema["additionalProperties"] = false
+			if f.Templates {
+				schema["patternProperties"] = map[string]interface{}{"^x-": map[string]interface{}{}}
+			}
+			if len(required) > 0 {
+				schema["required"] = required
+			}
+		} else if f.Items != nil {
+			schema["additionalProperties"] = f.Items.jsonSchema()
+		}
+	case TypeArray:
+		schema["type"] = TypeArray
+		if f.Items != nil {
+			schema["items"] = f.Items.jsonSchema()
+		}
+	case TypeScalar:
+		schema["type"] = []string{"string", "number", "boolean", "null"}
+	case TypeInteger:
+		schema["type"] = TypeInteger
+		if f.IntString {
+			schema["type"] = []string{TypeInteger, TypeString}
+			schema["pattern"] = "^[0-9]+$"
+		}
+	default:
+		schema["type"] = f.Type
+	}
+
+	if len(f.Enum) > 0 {
+		schema["enum"] = f.Enum
+	}
+	switch f.Format {
+	case FormatDuration:
+		schema["pattern"] = durationPattern
+	case FormatPort:
+		schema["pattern"] = portRegexp.String()
+	case FormatMode:
+		schema["pattern"] = modePattern
+	}
+	return schema
+}
+
+// child returns the field of a key of an object, nil when the object does not declare it
+func (f *Field) child(key string) *Field {
+	if f.Type != TypeObject {
+		return nil
+	}
+	if f.Fields != nil {
+		return f.Fields[key]
+	}
+	return f.Items
+}
+
+// readableAs tells if the values of a field can be read as a type
+func (f *Field) readableAs(typ string) bool {
+	switch {
+	case f.Type == typ:
+		return true
+	case typ == TypeString && f.IntString:
+		return true
+	case f.Type == TypeScalar && (typ == TypeString || typ == TypeInteger || typ == TypeBoolean):
+		return true
+	}
+	return false
+}
+
+// FieldNames returns the sorted known keys of an object field
+func (f *Field) FieldNames() (names []string) {
+	for name := range f.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func mapKeys(m map[string]bool) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
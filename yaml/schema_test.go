@@ -0,0 +1,130 @@
+package yaml
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	for _, version := range []string{"1", "2"} {
+		schema, err := JSONSchema(version)
+		if err != nil {
+			t.Fail()
+		}
+
+		var decoded map[string]interface{}
+		if json.Unmarshal(schema, &decoded) != nil || decoded["additionalProperties"] != false {
+			t.Fail()
+		}
+
+		// the published schemas must be regenerated with: swapper schema --version N > doc/swapper.vN.schema.json
+		published, _ := ioutil.ReadFile("../doc/swapper.v" + version + ".schema.json")
+		if string(published) != string(schema)+"\n" {
+			t.Errorf("doc/swapper.v%s.schema.json is outdated", version)
+		}
+	}
+
+	if _, err := JSONSchema("3"); err == nil {
+		t.Fail()
+	}
+}
+
+// InterpretV1 reads v1 documents through V1Schema, which panics on the keys and the types it does not declare
+func TestV1SchemaReader(t *testing.T) {
+	var document interface{}
+	_ = yaml.Unmarshal([]byte("services:\n  api:\n    containers:\n      - health-retries: '2'\n"), &document)
+	swapperYaml := &Yaml{data: document, field: V1Schema}
+	container := swapperYaml.GetPath("services", "api", "containers").GetIndex(0)
+	if retries, err := container.Get("health-retries").String(); err != nil || retries != "2" {
+		t.Error(retries, err)
+	}
+
+	for path, read := range map[string]func(){
+		"services.api.containers[0].healthcmd is read but not declared":    func() { _, _ = container.Get("healthcmd").String() },
+		"services.api.containers[0].image is read as integer but declared": func() { _, _ = container.Get("image").Int() },
+		"version.x is read but not declared":                               func() { swapperYaml.Get("version").Get("x") },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || strings.Contains(fmt.Sprint(r), path) == false {
+					t.Error(path, r)
+				}
+			}()
+			read()
+		}()
+	}
+}
+
+// The structs converted from compose files are written as v1 documents
+func TestV1SchemaCoversCompose(t *testing.T) {
+	checkStruct(t, "V1Schema", "", reflect.TypeOf(convertedYaml{}), V1Schema)
+	checkStruct(t, "V1Schema", "services.", reflect.TypeOf(convertedService{}), V1Schema.Fields["services"].Items)
+}
+
+// checkStruct compares the yaml tags and the types of the fields of a struct with a schema
+func checkStruct(t *testing.T, schema string, path string, typ reflect.Type, field *Field) {
+	switch typ.Kind() {
+	case reflect.Ptr:
+		checkStruct(t, schema, path, typ.Elem(), field)
+	case reflect.Slice, reflect.Map:
+		if field.Items != nil {
+			checkStruct(t, schema, path, typ.Elem(), field.Items)
+		}
+	case reflect.Struct:
+		if typ == reflect.TypeOf(yamlv3.Node{}) {
+			return
+		}
+		for i := 0; i < typ.NumField(); i++ {
+			if typ.Field(i).PkgPath != "" {
+				continue
+			}
+			name := strings.Split(typ.Field(i).Tag.Get("yaml"), ",")[0]
+			child, declared := field.Fields[name]
+			if declared == false {
+				t.Errorf("field %s%s is not declared in %s", path, name, schema)
+				continue
+			}
+			if kind := yamlType(typ.Field(i).Type); kind != "" && child.readableAs(kind) == false {
+				t.Errorf("field %s%s is a %s, it is declared as %s in %s", path, name, kind, child.Type, schema)
+			}
+			checkStruct(t, schema, path+name+".", typ.Field(i).Type, child)
+		}
+	}
+}
+
+// yamlType returns the schema type of a go type, empty for a yaml node which holds any type
+func yamlType(typ reflect.Type) string {
+	switch typ.Kind() {
+	case reflect.Ptr:
+		return yamlType(typ.Elem())
+	case reflect.String:
+		return TypeString
+	case reflect.Int, reflect.Int64:
+		return TypeInteger
+	case reflect.Bool:
+		return TypeBoolean
+	case reflect.Slice:
+		return TypeArray
+	case reflect.Map:
+		return TypeObject
+	case reflect.Struct:
+		if typ == reflect.TypeOf(yamlv3.Node{}) {
+			return ""
+		}
+		return TypeObject
+	}
+	return ""
+}
+
+func TestParseSwapperYamlSchema(t *testing.T) {
+	_, err := ParseSwapperYaml("version: '1'\nservices:\n  api:\n    ports:\n      - 80:80\n    containers:\n      - image: nginx\n        tag: 1.17.0\n        healthcmd: curl localhost")
+	if err == nil || strings.Contains(err.Error(), "9:9: error: unknown field \"healthcmd\"") == false {
+		t.Error(err)
+	}
+}
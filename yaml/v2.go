@@ -0,0 +1,330 @@
+package yaml
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	yamlv3 "gopkg.in/yaml.v3"
+	"sort"
+	"strings"
+)
+
+// V2 is the version 2 of the swapper yaml format. Unlike v1, it is decoded into typed structs, and unknown fields are
+// rejected while decoding
+type V2 struct {
+	Version       string               `yaml:"version"`
+	Hash          string               `yaml:"hash,omitempty"`
+	Time          int64                `yaml:"time,omitempty"`
+	Deployer      string               `yaml:"deployer,omitempty"`
+	Masters       []string             `yaml:"masters,omitempty"`
+	Master        *V2Master            `yaml:"master,omitempty"`
+	Notifications []V2Notification     `yaml:"notifications,omitempty"`
+	ProxyStats    *V2ProxyStats        `yaml:"proxy_stats,omitempty"`
+	ProxyLogs     *V2ProxyLogs         `yaml:"proxy_logs,omitempty"`
+	Services      map[string]V2Service `yaml:"services"`
+	// serviceNames keeps the declaration order of the services, like v1
+	serviceNames []string
+}
+
+type V2ProxyLogs struct {
+	Destination string `yaml:"destination"`
+	Address     string `yaml:"address,omitempty"`
+	Path        string `yaml:"path,omitempty"`
+	Facility    string `yaml:"facility,omitempty"`
+}
+
+type V2ProxyStats struct {
+	Address  string `yaml:"address,omitempty"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type V2Master struct {
+	Driver          string `yaml:"driver,omitempty"`
+	ProjectId       string `yaml:"project_id,omitempty"`
+	CredentialsFile string `yaml:"credentials_file,omitempty"`
+}
+
+type V2Notification struct {
+	Type       string              `yaml:"type"`
+	Url        string              `yaml:"url,omitempty"`
+	Channel    string              `yaml:"channel,omitempty"`
+	Headers    map[string]string   `yaml:"headers,omitempty"`
+	Events     []string            `yaml:"events,omitempty"`
+	Retries    int                 `yaml:"retries,omitempty"`
+	Backoff    string              `yaml:"backoff,omitempty"`
+	Template   string              `yaml:"template,omitempty"`
+	Server     string              `yaml:"server,omitempty"`
+	Port       int                 `yaml:"port,omitempty"`
+	StartTLS   bool                `yaml:"starttls,omitempty"`
+	Username   string              `yaml:"username,omitempty"`
+	Password   string              `yaml:"password,omitempty"`
+	From       string              `yaml:"from,omitempty"`
+	Recipients map[string][]string `yaml:"recipients,omitempty"`
+}
+
+type V2Service struct {
+	Ports       []string          `yaml:"ports"`
+	EnvFile     []string          `yaml:"env_file,omitempty"`
+	Environment map[string]string `yaml:"environment,omitempty"`
+	Proxy       *V2Proxy          `yaml:"proxy,omitempty"`
+	ProxyHealth *V2ProxyHealth    `yaml:"proxy_health,omitempty"`
+	Sticky      *V2Sticky         `yaml:"sticky,omitempty"`
+	RateLimit   *V2RateLimit      `yaml:"rate_limit,omitempty"`
+	Allow       []string          `yaml:"allow,omitempty"`
+	Deny        []string          `yaml:"deny,omitempty"`
+	Containers  []V2Container     `yaml:"containers"`
+}
+
+type V2Proxy struct {
+	Mode      string      `yaml:"mode,omitempty"`
+	Balance   string      `yaml:"balance,omitempty"`
+	Timeouts  *V2Timeouts `yaml:"timeouts,omitempty"`
+	MaxConn   int         `yaml:"maxconn,omitempty"`
+	Check     *V2Check    `yaml:"check,omitempty"`
+	SendProxy bool        `yaml:"send_proxy,omitempty"`
+}
+
+type V2ProxyHealth struct {
+	Method   string `yaml:"method,omitempty"`
+	Path     string `yaml:"path"`
+	Expect   int    `yaml:"expect,omitempty"`
+	Host     string `yaml:"host,omitempty"`
+	Interval string `yaml:"interval,omitempty"`
+}
+
+type V2Sticky struct {
+	Type   string `yaml:"type"`
+	Cookie string `yaml:"cookie,omitempty"`
+	Expire string `yaml:"expire,omitempty"`
+	Drain  string `yaml:"drain,omitempty"`
+}
+
+type V2RateLimit struct {
+	Connections int    `yaml:"connections,omitempty"`
+	Requests    int    `yaml:"requests,omitempty"`
+	Concurrent  int    `yaml:"concurrent,omitempty"`
+	Period      string `yaml:"period,omitempty"`
+}
+
+type V2Timeouts struct {
+	Connect string `yaml:"connect,omitempty"`
+	Client  string `yaml:"client,omitempty"`
+	Server  string `yaml:"server,omitempty"`
+}
+
+type V2Check struct {
+	Interval string `yaml:"interval,omitempty"`
+	Rise     int    `yaml:"rise,omitempty"`
+	Fall     int    `yaml:"fall,omitempty"`
+}
+
+type V2Container struct {
+	Image       string            `yaml:"image"`
+	Tag         string            `yaml:"tag"`
+	Weight      int               `yaml:"weight,omitempty"`
+	EnvFile     []string          `yaml:"env_file,omitempty"`
+	Environment map[string]string `yaml:"environment,omitempty"`
+	Logging     *V2Logging        `yaml:"logging,omitempty"`
+	Healthcheck *V2Healthcheck    `yaml:"healthcheck,omitempty"`
+	ExtraHosts  []string          `yaml:"extra_hosts,omitempty"`
+	Files       []V2File          `yaml:"files,omitempty"`
+}
+
+type V2Logging struct {
+	Driver  string            `yaml:"driver,omitempty"`
+	Options map[string]string `yaml:"options,omitempty"`
+}
+
+type V2Healthcheck struct {
+	Cmd      string `yaml:"cmd"`
+	Interval string `yaml:"interval,omitempty"`
+	Timeout  string `yaml:"timeout,omitempty"`
+	Retries  int    `yaml:"retries,omitempty"`
+}
+
+type V2File struct {
+	Path    string `yaml:"path"`
+	Content string `yaml:"content,omitempty"`
+	Secret  string `yaml:"secret,omitempty"`
+	Source  string `yaml:"source,omitempty"`
+	Mode    string `yaml:"mode,omitempty"`
+}
+
+// DecodeV2 decodes a v2 yaml configuration, unknown fields and wrong types are errors
+func DecodeV2(yamlStr string) (v2 V2, err error) {
+	decoder := yamlv3.NewDecoder(strings.NewReader(yamlStr))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&v2); err != nil {
+		return v2, errors.New(fmt.Sprintf(response.ErrorMessages["yaml_schema"], strings.TrimPrefix(err.Error(), "yaml: ")))
+	}
+	v2.serviceNames = declaredServiceNames(yamlStr)
+	return v2, nil
+}
+
+// declaredServiceNames returns the names of the services of a yaml file in their declaration order
+func declaredServiceNames(yamlStr string) (serviceNames []string) {
+	var document yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(yamlStr), &document); err == nil && len(document.Content) > 0 {
+		for _, service := range mappingPairs(mappingValue(document.Content[0], "services")) {
+			serviceNames = append(serviceNames, service[0].Value)
+		}
+	}
+	return serviceNames
+}
+
+// InterpretV2 converts a v2 yaml configuration into the configuration applied by masters and nodes
+func InterpretV2(v2 V2) (yamlConf YamlConf, err error) {
+	yamlConf.Hash = v2.Hash
+	yamlConf.Time = v2.Time
+	yamlConf.Deployer = v2.Deployer
+	yamlConf.Masters = v2.Masters
+
+	yamlConf.Master.Driver = "local"
+	if v2.Master != nil && v2.Master.Driver == "gcp" {
+		if v2.Master.ProjectId == "" {
+			return yamlConf, errors.New(fmt.Sprintf(response.ErrorMessages["master_field_needed"], "project_id"))
+		}
+		yamlConf.Master = Master{Driver: "gcp", ProjectId: v2.Master.ProjectId, CredentialsFile: v2.Master.CredentialsFile}
+	}
+
+	for i, n := range v2.Notifications {
+		notification := Notification{Type: n.Type, Url: n.Url, Channel: n.Channel, Headers: n.Headers, Events: n.Events, Retries: n.Retries, Backoff: n.Backoff, Template: n.Template}
+		if n.Type == "smtp" {
+			notification.Url = ""
+			notification.Smtp = Smtp{Server: n.Server, Port: n.Port, StartTLS: n.StartTLS, Username: n.Username, Password: n.Password, From: n.From, Recipients: n.Recipients}
+			if notification.Smtp.Port == 0 {
+				notification.Smtp.Port = 25
+			}
+		}
+		if err := checkNotification(notification, i); err != nil {
+			return yamlConf, err
+		}
+		yamlConf.Notifications = append(yamlConf.Notifications, notification)
+	}
+
+	// services keep their declaration order, the ones of a V2 which was not decoded are sorted by name
+	serviceNames := v2.serviceNames
+	if len(serviceNames) != len(v2.Services) {
+		serviceNames = nil
+		for serviceName := range v2.Services {
+			serviceNames = append(serviceNames, serviceName)
+		}
+		sort.Strings(serviceNames)
+	}
+
+	for _, serviceName := range serviceNames {
+		service, err := interpretV2Service(serviceName, v2.Services[serviceName])
+		if err != nil {
+			return yamlConf, err
+		}
+		frontends, err := bindPorts(service, service.Ports, yamlConf.Frontends)
+		if err != nil {
+			return yamlConf, err
+		}
+		yamlConf.Services = append(yamlConf.Services, service)
+		yamlConf.Frontends = append(yamlConf.Frontends, frontends...)
+	}
+
+	if logs := v2.ProxyLogs; logs != nil {
+		yamlConf.ProxyLogs = ProxyLogs{Destination: logs.Destination, Address: logs.Address, Path: logs.Path, Facility: logs.Facility}
+		if err := checkProxyLogs(yamlConf.ProxyLogs); err != nil {
+			return yamlConf, err
+		}
+	}
+	if stats := v2.ProxyStats; stats != nil {
+		yamlConf.ProxyStats = ProxyStats{Address: stats.Address, Port: stats.Port, Username: stats.Username, Password: stats.Password}
+	}
+	return yamlConf, checkProxyStats(yamlConf.ProxyStats, yamlConf.Frontends)
+}
+
+func interpretV2Service(serviceName string, v2Service V2Service) (service Service, err error) {
+	service.Name = serviceName
+	service.Ports = v2Service.Ports
+	if proxy := v2Service.Proxy; proxy != nil {
+		service.Proxy = ProxyOptions{Mode: proxy.Mode, Balance: proxy.Balance, MaxConn: proxy.MaxConn, SendProxy: proxy.SendProxy}
+		if proxy.Timeouts != nil {
+			service.Proxy.ConnectTimeout = proxy.Timeouts.Connect
+			service.Proxy.ClientTimeout = proxy.Timeouts.Client
+			service.Proxy.ServerTimeout = proxy.Timeouts.Server
+		}
+		if proxy.Check != nil {
+			service.Proxy.CheckInterval = proxy.Check.Interval
+			service.Proxy.CheckRise = proxy.Check.Rise
+			service.Proxy.CheckFall = proxy.Check.Fall
+		}
+	}
+	if health := v2Service.ProxyHealth; health != nil {
+		service.Proxy.Health = ProxyHealth{Method: health.Method, Path: health.Path, Expect: health.Expect, Host: health.Host, Interval: health.Interval}
+	}
+	if sticky := v2Service.Sticky; sticky != nil {
+		service.Proxy.Sticky = Sticky{Type: sticky.Type, Cookie: sticky.Cookie, Expire: sticky.Expire, Drain: sticky.Drain}
+	}
+	if rateLimit := v2Service.RateLimit; rateLimit != nil {
+		service.Proxy.RateLimit = RateLimit{Connections: rateLimit.Connections, Requests: rateLimit.Requests, Concurrent: rateLimit.Concurrent, Period: rateLimit.Period}
+	}
+	service.Proxy.Allow = v2Service.Allow
+	service.Proxy.Deny = v2Service.Deny
+	if err := checkProxy(service.Proxy, serviceName, service.Ports); err != nil {
+		return service, err
+	}
+	for i, v2Container := range v2Service.Containers {
+		container := Container{
+			Name:   serviceName,
+			Index:  i,
+			Image:  v2Container.Image,
+			Tag:    v2Container.Tag,
+			Weight: v2Container.Weight,
+			Envs:   mergeEnvironment(stringsToInterfaces(v2Service.Environment), stringsToInterfaces(v2Container.Environment)),
+		}
+		if container.Image == "" {
+			return service, errors.New(fmt.Sprintf(response.ErrorMessages["service_field_needed"], "image", serviceName))
+		}
+		if container.Tag == "" {
+			return service, errors.New(fmt.Sprintf(response.ErrorMessages["service_field_needed"], "tag", serviceName))
+		}
+		if container.Weight == 0 {
+			container.Weight = 100
+		}
+		if v2Container.Logging != nil {
+			container.LoggingDriver = v2Container.Logging.Driver
+			container.LoggingOptions = stringsToInterfaces(v2Container.Logging.Options)
+		}
+		if v2Container.Healthcheck != nil {
+			container.HealthCmd = v2Container.Healthcheck.Cmd
+			container.HealthInterval = v2Container.Healthcheck.Interval
+			container.HealthTimeout = v2Container.Healthcheck.Timeout
+			container.HealthRetries = v2Container.Healthcheck.Retries
+		}
+		for _, extraHost := range v2Container.ExtraHosts {
+			container.ExtraHosts = append(container.ExtraHosts, extraHost)
+		}
+		for j, v2File := range v2Container.Files {
+			file := File{Path: v2File.Path, Content: v2File.Content, Secret: v2File.Secret, Source: v2File.Source}
+			sources := 0
+			for _, source := range []string{v2File.Content, v2File.Secret, v2File.Source} {
+				if source != "" {
+					sources++
+				}
+			}
+			if err := checkFile(&file, sources, v2File.Mode, j, serviceName); err != nil {
+				return service, err
+			}
+			container.Files = append(container.Files, file)
+		}
+		service.Containers = append(service.Containers, container)
+	}
+	return service, nil
+}
+
+func stringsToInterfaces(m map[string]string) map[interface{}]interface{} {
+	if m == nil {
+		return nil
+	}
+	converted := map[interface{}]interface{}{}
+	for k, v := range m {
+		converted[k] = v
+	}
+	return converted
+}
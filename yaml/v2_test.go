@@ -0,0 +1,78 @@
+package yaml
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSwapperYamlV2(t *testing.T) {
+	input, _ := ioutil.ReadFile("tests/v2/valid.yml")
+	yamlConf, err := ParseSwapperYaml(string(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(yamlConf.Services) != 2 || yamlConf.Services[0].Name != "my-app" || len(yamlConf.Frontends) != 2 {
+		t.Fatal(yamlConf)
+	}
+	container := yamlConf.Services[0].Containers[0]
+	if container.HealthCmd != "curl --silent --fail localhost:80/status || exit 1" || container.HealthInterval != "5s" || container.HealthRetries != 2 {
+		t.Error(container)
+	}
+	// floats are kept as written
+	if container.Envs["RATIO"] != "1.50" || container.LoggingOptions["max-size"] != "10m" {
+		t.Error(container.Envs, container.LoggingOptions)
+	}
+	if yamlConf.Services[0].Containers[1].Weight != 20 || yamlConf.Services[1].Containers[0].Weight != 100 {
+		t.Fail()
+	}
+	if len(yamlConf.Notifications) != 1 || yamlConf.Notifications[0].Channel != "#deploy" || yamlConf.Master.Driver != "local" {
+		t.Error(yamlConf.Notifications)
+	}
+
+	input, _ = ioutil.ReadFile("tests/v2/invalid.yml")
+	_, err = ParseSwapperYaml(string(input))
+	if err == nil || strings.Contains(err.Error(), "line 10: field health-cmd not found in type yaml.V2Container") == false {
+		t.Error(err)
+	}
+
+	_, err = ParseSwapperYaml("version: '2'\nservices:\n  api:\n    ports:\n      - 80:80\n    containers:\n      - image: nginx\n        tag: 1.17.0\n        weight: heavy")
+	if err == nil || strings.Contains(err.Error(), "cannot unmarshal !!str `heavy` into int") == false {
+		t.Error(err)
+	}
+
+	_, err = ParseSwapperYaml("version: '2'\nmaster:\n  driver: gcp\nservices:\n  api:\n    ports:\n      - 80:80\n    containers:\n      - image: nginx\n        tag: 1.17.0")
+	if err == nil || strings.Contains(err.Error(), "project_id") == false {
+		t.Error(err)
+	}
+}
+
+// A v1 file and its migration are interpreted the same way, services and frontends keep their order
+func TestInterpretV2(t *testing.T) {
+	for _, file := range []string{"tests/v1/valid.2.yml", "../doc/yml-examples/3.multiple.backends.yml", "../doc/yml-examples/14.with.udp.ports.yml"} {
+		input, _ := ioutil.ReadFile(file)
+		v1 := strings.Replace(strings.Replace(string(input), "${TAG}", "1.17.0", -1), "${ENV}", "prod", -1)
+		v2, err := MigrateV1(v1)
+		if err != nil {
+			t.Fatal(file, err)
+		}
+
+		v1Conf, err := ParseSwapperYaml(v1)
+		if err != nil {
+			t.Fatal(file, err)
+		}
+		v2Conf, err := ParseSwapperYaml(v2)
+		if err != nil {
+			t.Fatal(file, err)
+		}
+		if !reflect.DeepEqual(v1Conf.Services, v2Conf.Services) || !reflect.DeepEqual(v1Conf.Frontends, v2Conf.Frontends) {
+			t.Errorf("%s differs in v2: %v %v", file, v1Conf.Services, v2Conf.Services)
+		}
+	}
+}
+
+// Every field of the V2 structs has to be declared in V2Schema, at the same place
+func TestV2SchemaCoversV2(t *testing.T) {
+	checkStruct(t, "V2Schema", "", reflect.TypeOf(V2{}), V2Schema)
+}
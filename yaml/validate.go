@@ -0,0 +1,537 @@
+package yaml
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	LevelError   = "error"
+	LevelWarning = "warning"
+)
+
+// Problem is something wrong (or suspicious) found in a yaml file
+type Problem struct {
+	// File is set when the problem is in an included file
+	File    string
+	Line    int
+	Column  int
+	Level   string
+	Message string
+}
+
+func (p Problem) String() string {
+	return strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column) + ": " + p.Level + ": " + p.Message
+}
+
+var (
+	portRegexp      = regexp.MustCompile(`^([0-9]{1,3}(\.[0-9]{1,3}){3}:)?[0-9]+(-[0-9]+)?:[0-9]+(-[0-9]+)?(/(tcp|udp))?$`)
+	modeRegexp      = regexp.MustCompile(modePattern)
+	yamlErrorRegexp = regexp.MustCompile(`line ([0-9]+): (.*)`)
+)
+
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) add(node *yamlv3.Node, level string, format string, a ...interface{}) {
+	v.problems = append(v.problems, Problem{Line: node.Line, Column: node.Column, Level: level, Message: fmt.Sprintf(format, a...)})
+}
+
+// Validate checks a swapper yaml file offline and returns all the problems found, with their position
+func Validate(sourceFile string, vars []string) (problems []Problem, err error) {
+	input, ioErr := ioutil.ReadFile(sourceFile)
+	if ioErr != nil {
+		return problems, errors.New(fmt.Sprintf(response.ErrorMessages["file_not_exist"], sourceFile))
+	}
+	if HasIncludes(string(input)) == false {
+		return ValidateString(string(input), vars), nil
+	}
+
+	c, err := compose(sourceFile, string(input))
+	if err != nil {
+		return problems, err
+	}
+	problems = validateText(c.text, vars, c.merge)
+	for i := range problems {
+		file, line := c.locate(problems[i].Line)
+		if file != sourceFile && file != "" {
+			problems[i].File = file
+		}
+		problems[i].Line = line
+		if line == 0 {
+			problems[i].Line, problems[i].Column = 1, 1
+		}
+	}
+	return problems, nil
+}
+
+// SchemaErrors checks a yaml configuration against its schema, and returns an error listing all the violations
+func SchemaErrors(input string) error {
+	var messages []string
+	for _, problem := range validateDocument(input) {
+		if problem.Level == LevelError {
+			messages = append(messages, "  "+problem.String())
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["yaml_schema"], strings.Join(messages, "\n")))
+	}
+	return nil
+}
+
+// ValidateString checks the content of a swapper yaml file
+func ValidateString(input string, vars []string) (problems []Problem) {
+	return validateText(input, vars, parseDocument)
+}
+
+// validateText checks a yaml text parsed by parse, once its variables are replaced
+func validateText(input string, vars []string, parse func(input string) (*yamlv3.Node, *Problem)) (problems []Problem) {
+	// Missing variables are reported where they are used in the original file
+	replaced, missing := ReplaceVars(input, vars)
+	for _, variable := range missing {
+		message := "missing variable " + variable.Name + ", use --var " + variable.Name + "=<value>"
+		if variable.Message != "" {
+			message = "missing variable " + variable.Name + ": " + variable.Message
+		}
+		for i, line := range strings.Split(input, "\n") {
+			for _, index := range varRegexp.FindAllStringIndex(line, -1) {
+				if line[index[0]:index[1]] == variable.Expression {
+					problems = append(problems, Problem{Line: i + 1, Column: index[0] + 1, Level: LevelError, Message: message})
+				}
+			}
+		}
+	}
+
+	if root, problem := parse(replaced); problem != nil {
+		problems = append(problems, *problem)
+	} else {
+		problems = append(problems, validateRoot(root)...)
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Line != problems[j].Line {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].Column < problems[j].Column
+	})
+	return problems
+}
+
+// validateDocument checks a yaml document against the schema, and what the schema cannot express
+func validateDocument(input string) []Problem {
+	root, problem := parseDocument(input)
+	if problem != nil {
+		return []Problem{*problem}
+	}
+	return validateRoot(root)
+}
+
+// parseDocument parses a yaml document, a parse error is returned as a problem
+func parseDocument(input string) (*yamlv3.Node, *Problem) {
+	var document yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(input), &document); err != nil {
+		problem := Problem{Line: 1, Column: 1, Level: LevelError, Message: err.Error()}
+		if matches := yamlErrorRegexp.FindStringSubmatch(err.Error()); matches != nil {
+			problem.Line, _ = strconv.Atoi(matches[1])
+			problem.Message = matches[2]
+		}
+		return nil, &problem
+	}
+	if len(document.Content) == 0 {
+		return nil, &Problem{Line: 1, Column: 1, Level: LevelError, Message: "file is empty"}
+	}
+	return document.Content[0], nil
+}
+
+// validateRoot checks the root of a yaml document against its schema
+func validateRoot(root *yamlv3.Node) []Problem {
+	v := &validator{}
+	schema := Schema(scalarValue(mappingValue(root, "version")))
+	if schema == nil {
+		schema = V1Schema
+	}
+	v.checkField(root, schema, "")
+	v.checkSemantics(root)
+	return v.problems
+}
+
+// checkField checks a node against its definition
+func (v *validator) checkField(node *yamlv3.Node, field *Field, path string) {
+	node = resolveAlias(node)
+
+	// unresolved variables are already reported
+	if node.Kind == yamlv3.ScalarNode && varRegexp.MatchString(node.Value) {
+		return
+	}
+
+	switch field.Type {
+	case TypeObject:
+		if node.Kind != yamlv3.MappingNode {
+			v.add(node, LevelError, "%s must be an object, got %s", describe(path), kindName(node))
+			return
+		}
+		seen := map[string]bool{}
+		for _, pair := range mappingPairs(node) {
+			key, value := pair[0], pair[1]
+			seen[key.Value] = true
+			if field.Templates && strings.HasPrefix(key.Value, "x-") {
+				continue
+			}
+			if field.Fields != nil {
+				child, known := field.Fields[key.Value]
+				if known == false {
+					message := "unknown field \"" + key.Value + "\" in " + describe(path)
+					if suggestion := suggest(key.Value, field.FieldNames()); suggestion != "" {
+						message = message + " (did you mean \"" + suggestion + "\"?)"
+					}
+					v.add(key, LevelError, "%s", message)
+					continue
+				}
+				v.checkField(value, child, join(path, key.Value))
+			} else if field.Items != nil {
+				v.checkField(value, field.Items, join(path, key.Value))
+			}
+		}
+		for _, name := range field.FieldNames() {
+			if field.Fields[name].Required && seen[name] == false {
+				v.add(node, LevelError, "missing required field \"%s\" in %s", name, describe(path))
+			}
+		}
+
+	case TypeArray:
+		if node.Kind != yamlv3.SequenceNode {
+			v.add(node, LevelError, "%s must be an array, got %s", describe(path), kindName(node))
+			return
+		}
+		for i, item := range node.Content {
+			v.checkField(item, field.Items, path+"["+strconv.Itoa(i)+"]")
+		}
+
+	default:
+		if node.Kind != yamlv3.ScalarNode {
+			v.add(node, LevelError, "%s must be %s, got %s", describe(path), article(field.Type), kindName(node))
+			return
+		}
+		if v.checkScalarType(node, field, path) == false {
+			return
+		}
+		if len(field.Enum) > 0 && contains(field.Enum, node.Value) == false {
+			v.add(node, LevelError, "%s must be one of %s, got \"%s\"", describe(path), strings.Join(field.Enum, ", "), node.Value)
+			return
+		}
+		switch field.Format {
+		case FormatDuration:
+			if _, err := time.ParseDuration(node.Value); err != nil {
+				v.add(node, LevelError, "%s is not a valid duration (like 5s, 1m30s), got \"%s\"", describe(path), node.Value)
+			}
+		case FormatPort:
+			v.checkPort(node, path)
+		case FormatMode:
+			if modeRegexp.MatchString(node.Value) == false {
+				v.add(node, LevelError, "%s must be octal permissions (like \"0400\"), got \"%s\"", describe(path), node.Value)
+			}
+		}
+	}
+}
+
+func (v *validator) checkScalarType(node *yamlv3.Node, field *Field, path string) bool {
+	tag := node.ShortTag()
+	valid := true
+	switch field.Type {
+	case TypeString:
+		valid = tag == "!!str"
+	case TypeInteger:
+		valid = tag == "!!int"
+		if valid == false && field.IntString && tag == "!!str" {
+			_, err := strconv.Atoi(node.Value)
+			valid = err == nil
+		}
+	case TypeBoolean:
+		valid = tag == "!!bool"
+	}
+	if valid == false {
+		v.add(node, LevelError, "%s must be %s, got %s \"%s\"", describe(path), article(field.Type), strings.TrimPrefix(tag, "!!"), node.Value)
+	}
+	return valid
+}
+
+func (v *validator) checkPort(node *yamlv3.Node, path string) {
+	if node.Value == "" {
+		v.add(node, LevelError, "%s cannot be an empty string", describe(path))
+		return
+	}
+	if _, err := ParsePort(node.Value); err != nil {
+		v.add(node, LevelError, "%s %s, got \"%s\"", describe(path), err.Error(), node.Value)
+	}
+}
+
+// checkSemantics checks what the schema cannot express
+func (v *validator) checkSemantics(root *yamlv3.Node) {
+	if root.Kind != yamlv3.MappingNode {
+		return
+	}
+
+	projectId := "project-id"
+	if scalarValue(mappingValue(root, "version")) == "2" {
+		projectId = "project_id"
+	}
+	master := mappingValue(root, "master")
+	if master != nil && scalarValue(mappingValue(master, "driver")) == "gcp" && mappingValue(master, projectId) == nil {
+		v.add(master, LevelError, "missing required field \"%s\" in master (gcp driver)", projectId)
+	}
+
+	if notifications := mappingValue(root, "notifications"); notifications != nil && notifications.Kind == yamlv3.SequenceNode {
+		for i, notification := range notifications.Content {
+			notification = resolveAlias(notification)
+			path := "notifications[" + strconv.Itoa(i) + "]"
+			if notification.Kind != yamlv3.MappingNode {
+				continue
+			}
+			if scalarValue(mappingValue(notification, "type")) == "smtp" {
+				for _, name := range []string{"server", "from", "recipients"} {
+					if mappingValue(notification, name) == nil {
+						v.add(notification, LevelError, "missing required field \"%s\" in %s (smtp type)", name, path)
+					}
+				}
+			} else if mappingValue(notification, "url") == nil {
+				v.add(notification, LevelError, "missing required field \"url\" in %s", path)
+			}
+			if tmpl := mappingValue(notification, "template"); tmpl != nil {
+				if _, err := template.New("notification").Parse(tmpl.Value); err != nil {
+					v.add(tmpl, LevelError, "%s.template is invalid: %s", path, err.Error())
+				}
+			}
+		}
+	}
+
+	services := mappingValue(root, "services")
+	if services == nil || services.Kind != yamlv3.MappingNode {
+		return
+	}
+	var bound []*yamlv3.Node
+	for _, pair := range mappingPairs(services) {
+		serviceName, service := pair[0].Value, pair[1]
+		if service.Kind != yamlv3.MappingNode {
+			continue
+		}
+
+		if ports := mappingValue(service, "ports"); ports != nil && ports.Kind == yamlv3.SequenceNode {
+			for _, port := range ports.Content {
+				parsed, err := ParsePort(port.Value)
+				if port.Kind != yamlv3.ScalarNode || err != nil {
+					continue
+				}
+				conflict := false
+				for _, previous := range bound {
+					if previousPort, _ := ParsePort(previous.Value); conflict == false && parsed.Overlaps(previousPort) {
+						v.add(port, LevelError, "port %s of service \"%s\" is already bound at line %d", strings.TrimSuffix(parsed.Published(), "/"+ProtocolTcp), serviceName, previous.Line)
+						conflict = true
+					}
+				}
+				if conflict == false {
+					bound = append(bound, port)
+				}
+			}
+		}
+
+		containers := mappingValue(service, "containers")
+		if containers == nil || containers.Kind != yamlv3.SequenceNode || len(containers.Content) == 0 {
+			v.add(pair[0], LevelWarning, "service \"%s\" has no containers", serviceName)
+			continue
+		}
+		for _, container := range containers.Content {
+			container = resolveAlias(container)
+			if container.Kind != yamlv3.MappingNode {
+				continue
+			}
+			if tag := mappingValue(container, "tag"); tag != nil && tag.Value == "latest" {
+				v.add(tag, LevelWarning, "tag \"latest\" of service \"%s\" is mutable, nodes may run different versions", serviceName)
+			}
+			v.checkFiles(mappingValue(container, "files"), serviceName)
+		}
+	}
+}
+
+func (v *validator) checkFiles(files *yamlv3.Node, serviceName string) {
+	files = resolveAlias(files)
+	if files == nil || files.Kind != yamlv3.SequenceNode {
+		return
+	}
+	for i, file := range files.Content {
+		file = resolveAlias(file)
+		if file.Kind != yamlv3.MappingNode {
+			continue
+		}
+		if path := mappingValue(file, "path"); path != nil && strings.HasPrefix(path.Value, "/") == false {
+			v.add(path, LevelError, "path of file #%d of service \"%s\" must be absolute, got \"%s\"", i, serviceName, path.Value)
+		}
+		sources := 0
+		for _, name := range []string{"content", "secret", "source"} {
+			if mappingValue(file, name) != nil {
+				sources++
+			}
+		}
+		if sources != 1 {
+			v.add(file, LevelError, "file #%d of service \"%s\" needs exactly one of content, secret or source", i, serviceName)
+		}
+	}
+}
+
+// mappingPairs returns the key/value nodes of a mapping, with merge keys (<<) expanded
+func mappingPairs(node *yamlv3.Node) (pairs [][2]*yamlv3.Node) {
+	node = resolveAlias(node)
+	if node == nil {
+		return nil
+	}
+	var merged [][2]*yamlv3.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.ShortTag() == "!!merge" {
+			value = resolveAlias(value)
+			sources := []*yamlv3.Node{value}
+			if value.Kind == yamlv3.SequenceNode {
+				sources = value.Content
+			}
+			for _, source := range sources {
+				if resolveAlias(source).Kind == yamlv3.MappingNode {
+					merged = append(merged, mappingPairs(source)...)
+				}
+			}
+			continue
+		}
+		pairs = append(pairs, [2]*yamlv3.Node{key, value})
+	}
+
+	// explicit keys win over merged ones
+	for _, m := range merged {
+		overridden := false
+		for _, p := range pairs {
+			if p[0].Value == m[0].Value {
+				overridden = true
+			}
+		}
+		if overridden == false {
+			pairs = append(pairs, m)
+		}
+	}
+	return pairs
+}
+
+func mappingValue(node *yamlv3.Node, key string) *yamlv3.Node {
+	node = resolveAlias(node)
+	if node == nil || node.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for _, pair := range mappingPairs(node) {
+		if pair[0].Value == key {
+			return resolveAlias(pair[1])
+		}
+	}
+	return nil
+}
+
+func scalarValue(node *yamlv3.Node) string {
+	if node == nil || node.Kind != yamlv3.ScalarNode {
+		return ""
+	}
+	return node.Value
+}
+
+func resolveAlias(node *yamlv3.Node) *yamlv3.Node {
+	for node != nil && node.Kind == yamlv3.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+func kindName(node *yamlv3.Node) string {
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		return "an object"
+	case yamlv3.SequenceNode:
+		return "an array"
+	}
+	return strings.TrimPrefix(node.ShortTag(), "!!") + " \"" + node.Value + "\""
+}
+
+func article(typeName string) string {
+	if strings.ContainsAny(typeName[:1], "aeiou") {
+		return "an " + typeName
+	}
+	return "a " + typeName
+}
+
+func describe(path string) string {
+	if path == "" {
+		return "the file"
+	}
+	return path
+}
+
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// suggest returns the closest known name of a misspelled key
+func suggest(name string, known []string) (suggestion string) {
+	best := 3
+	for _, candidate := range known {
+		distance := levenshtein(strings.ToLower(name), candidate)
+		if distance < best {
+			best = distance
+			suggestion = candidate
+		}
+	}
+	return suggestion
+}
+
+func levenshtein(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, value := range values[1:] {
+		if value < m {
+			m = value
+		}
+	}
+	return m
+}
@@ -0,0 +1,78 @@
+package yaml
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	_, err := Validate("tests/v1/swappers.valid.yml", []string{})
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["file_not_exist"], "tests/v1/swappers.valid.yml") {
+		t.Fail()
+	}
+
+	problems, err := Validate("tests/v1/valid.2.yml", []string{"TAG=1.0.1", "ENV=prod"})
+	if err != nil || len(problems) != 0 {
+		t.Fail()
+	}
+
+	problems, _ = Validate("tests/v1/invalid.13.yml", []string{})
+	expected := []Problem{
+		{Line: 9, Column: 14, Level: LevelWarning, Message: "tag \"latest\" of service \"api\" is mutable, nodes may run different versions"},
+		{Line: 10, Column: 9, Level: LevelError, Message: "unknown field \"healthcmd\" in services.api.containers[0] (did you mean \"health-cmd\"?)"},
+		{Line: 11, Column: 26, Level: LevelError, Message: "services.api.containers[0].health-interval is not a valid duration (like 5s, 1m30s), got \"5 seconds\""},
+		{Line: 12, Column: 17, Level: LevelError, Message: "services.api.containers[0].weight must be an integer, got str \"a\""},
+		{Line: 15, Column: 16, Level: LevelError, Message: "missing variable TAG, use --var TAG=<value>"},
+		{Line: 18, Column: 9, Level: LevelError, Message: "port 80 of service \"web\" is already bound at line 6"},
+		{Line: 19, Column: 9, Level: LevelError, Message: "services.web.ports[1] must look like \"[address:]listen:container[/udp]\", got \"80dq:80\""},
+		{Line: 21, Column: 9, Level: LevelError, Message: "missing required field \"tag\" in services.web.containers[0]"},
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Error(problems)
+	}
+
+	problems, _ = Validate("tests/v1/invalid.14.yml", []string{})
+	expected = []Problem{
+		{Line: 11, Column: 13, Level: LevelError, Message: "file #0 of service \"my-app\" needs exactly one of content, secret or source"},
+		{Line: 14, Column: 19, Level: LevelError, Message: "path of file #1 of service \"my-app\" must be absolute, got \"nginx.key\""},
+		{Line: 16, Column: 19, Level: LevelError, Message: "services.my-app.containers[0].files[1].mode must be octal permissions (like \"0400\"), got \"999\""},
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Error(problems)
+	}
+}
+
+func TestValidateString(t *testing.T) {
+	problems := ValidateString("version: '1'\nservices: [", []string{})
+	if len(problems) != 1 || problems[0].Level != LevelError || problems[0].Line != 2 {
+		t.Error(problems)
+	}
+
+	problems = ValidateString("version: '3'\nservices:\n  api:\n    containers: []", []string{})
+	if len(problems) != 3 {
+		t.Error(problems)
+	}
+
+	problems = ValidateString("version: '2'\nservices:\n  api:\n    containers: []", []string{})
+	if len(problems) != 2 {
+		t.Error(problems)
+	}
+
+	problems = ValidateString("version: '1'\nnotifications:\n  - type: smtp\n    server: smtp.example.com\nservices: {}", []string{})
+	expected := []Problem{
+		{Line: 3, Column: 5, Level: LevelError, Message: "missing required field \"from\" in notifications[0] (smtp type)"},
+		{Line: 3, Column: 5, Level: LevelError, Message: "missing required field \"recipients\" in notifications[0] (smtp type)"},
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Error(problems)
+	}
+}
+
+func TestProblemString(t *testing.T) {
+	problem := Problem{Line: 3, Column: 5, Level: LevelWarning, Message: "careful"}
+	if problem.String() != "3:5: warning: careful" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,174 @@
+package yaml
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// varRegexp matches ${NAME}, ${NAME:-default} and ${NAME:?message}, and their escaped form $${...}
+	varRegexp     = regexp.MustCompile(`\$?\$\{([a-zA-Z0-9_-]+)(?:(:-|:\?)([^}]*))?\}`)
+	envLineRegexp = regexp.MustCompile(`^(?:export\s+)?([a-zA-Z0-9_-]+)\s*=(.*)$`)
+)
+
+// Variable is a ${} expression of a yaml file
+type Variable struct {
+	// Expression is the variable as written in the file, like ${TAG:-latest}
+	Expression string
+	Name       string
+	// Default is used when the variable is not defined or empty, with the ${NAME:-default} syntax
+	Default    string
+	HasDefault bool
+	// Required variables cannot be empty, with the ${NAME:?message} syntax. Message explains why.
+	Required bool
+	Message  string
+}
+
+func parseVariable(expression string) Variable {
+	matches := varRegexp.FindStringSubmatch(expression)
+	variable := Variable{Expression: expression, Name: matches[1]}
+	switch matches[2] {
+	case ":-":
+		variable.Default = matches[3]
+		variable.HasDefault = true
+	case ":?":
+		variable.Required = true
+		variable.Message = matches[3]
+	}
+	return variable
+}
+
+// ReplaceVars replaces the ${} variables of the input by their value, and returns the variables left undefined.
+// Vars are NAME=VALUE strings, a name defined twice takes the last value and NAME= defines an empty value, which
+// ${NAME:-default} and ${NAME:?message} treat as undefined.
+// $${...} is kept as a literal ${...}
+func ReplaceVars(input string, vars []string) (output string, missing []Variable) {
+	varMap := make(map[string]string)
+	for _, e := range vars {
+		keyValue := strings.SplitN(e, "=", 2)
+		if len(keyValue) == 2 {
+			varMap[keyValue[0]] = keyValue[1]
+		}
+	}
+
+	alreadyDone := map[string]bool{}
+	output = varRegexp.ReplaceAllStringFunc(input, func(expression string) string {
+		if strings.HasPrefix(expression, "$$") {
+			return expression[1:]
+		}
+		variable := parseVariable(expression)
+		// like a shell, an empty value is unset for ${NAME:-default} and ${NAME:?message}
+		if value, ok := varMap[variable.Name]; ok && (value != "" || (variable.HasDefault == false && variable.Required == false)) {
+			return value
+		}
+		if variable.HasDefault {
+			return variable.Default
+		}
+		if alreadyDone[variable.Expression] != true {
+			missing = append(missing, variable)
+			alreadyDone[variable.Expression] = true
+		}
+		return expression
+	})
+	return output, missing
+}
+
+// MissingVarsMessage explains which variables are missing and how to define them
+func MissingVarsMessage(missing []Variable) string {
+	var messages []string
+	var varnames []string
+	var examples []string
+	alreadyDone := map[string]bool{}
+	for _, variable := range missing {
+		if variable.Message != "" {
+			messages = append(messages, fmt.Sprintf(response.ErrorMessages["var_required"], variable.Name, variable.Message))
+			continue
+		}
+		if alreadyDone[variable.Name] != true {
+			varnames = append(varnames, variable.Name)
+			examples = append(examples, "--var "+variable.Name+"=<value>")
+			alreadyDone[variable.Name] = true
+		}
+	}
+	if len(varnames) > 0 {
+		messages = append(messages, fmt.Sprintf(response.ErrorMessages["var_missing"], strings.Join(varnames, " "), strings.Join(examples, " ")))
+	}
+	return strings.Join(messages, "")
+}
+
+// LoadVars gathers the variables of a command, by increasing priority: the process environment (if envVars),
+// the variables files (.env or .yml), then the NAME=VALUE vars
+func LoadVars(vars []string, varFiles []string, envVars bool) (allVars []string, err error) {
+	if envVars {
+		allVars = append(allVars, os.Environ()...)
+	}
+	for _, varFile := range varFiles {
+		fileVars, err := ReadVarFile(varFile)
+		if err != nil {
+			return allVars, err
+		}
+		allVars = append(allVars, fileVars...)
+	}
+	return append(allVars, vars...), nil
+}
+
+// ReadVarFile reads the NAME=VALUE variables of a .env file, or of a .yml file (a map of names to scalar values)
+func ReadVarFile(varFile string) (vars []string, err error) {
+	input, ioErr := ioutil.ReadFile(varFile)
+	if ioErr != nil {
+		return vars, errors.New(fmt.Sprintf(response.ErrorMessages["file_not_exist"], varFile))
+	}
+
+	extension := filepath.Ext(varFile)
+	if extension == ".yml" || extension == ".yaml" {
+		var values map[string]interface{}
+		if err := yaml.Unmarshal(input, &values); err != nil {
+			return vars, errors.New(fmt.Sprintf(response.ErrorMessages["var_file_invalid"], varFile, err.Error()))
+		}
+		var names []string
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			switch values[name].(type) {
+			case map[interface{}]interface{}, []interface{}:
+				return vars, errors.New(fmt.Sprintf(response.ErrorMessages["var_file_invalid"], varFile, name+" is not a scalar"))
+			case nil:
+				vars = append(vars, name+"=")
+			default:
+				vars = append(vars, name+"="+fmt.Sprint(values[name]))
+			}
+		}
+		return vars, nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(input)))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		matches := envLineRegexp.FindStringSubmatch(text)
+		if matches == nil {
+			return vars, errors.New(fmt.Sprintf(response.ErrorMessages["var_file_invalid"], varFile, fmt.Sprintf("line %d is not NAME=VALUE", line)))
+		}
+		value := strings.TrimSpace(matches[2])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars = append(vars, matches[1]+"="+value)
+	}
+	return vars, nil
+}
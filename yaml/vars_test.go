@@ -0,0 +1,101 @@
+package yaml
+
+import (
+	"fmt"
+	"github.com/sachamorard/swapper/response"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplaceVars(t *testing.T) {
+	input := "tag: ${TAG:-latest}\nenv: ${ENV:?the environment to deploy to}\ndsn: ${DSN}\nliteral: $${HOME}\nliteral2: $${TAG:-1}"
+	output, missing := ReplaceVars(input, []string{"ENV=prod", "DSN=user:pass@db/app?timeout=5s&retry=1"})
+	if output != "tag: latest\nenv: prod\ndsn: user:pass@db/app?timeout=5s&retry=1\nliteral: ${HOME}\nliteral2: ${TAG:-1}" || len(missing) != 0 {
+		t.Errorf("unexpected output: %s", output)
+	}
+
+	output, _ = ReplaceVars(input, []string{"TAG=1.0.1", "TAG=1.0.2", "ENV=prod", "DSN="})
+	if strings.HasPrefix(output, "tag: 1.0.2\n") == false || strings.Contains(output, "\ndsn: \n") == false {
+		t.Fail()
+	}
+
+	_, missing = ReplaceVars(input, []string{})
+	expected := []Variable{
+		{Expression: "${ENV:?the environment to deploy to}", Name: "ENV", Required: true, Message: "the environment to deploy to"},
+		{Expression: "${DSN}", Name: "DSN"},
+	}
+	if !reflect.DeepEqual(missing, expected) {
+		t.Errorf("unexpected missing variables: %v", missing)
+	}
+	message := fmt.Sprintf(response.ErrorMessages["var_required"], "ENV", "the environment to deploy to") + fmt.Sprintf(response.ErrorMessages["var_missing"], "DSN", "--var DSN=<value>")
+	if MissingVarsMessage(missing) != message {
+		t.Fail()
+	}
+
+	// an empty value gets the default, and is missing when required
+	output, missing = ReplaceVars(input, []string{"TAG=", "ENV=", "DSN="})
+	if strings.HasPrefix(output, "tag: latest\n") == false || strings.Contains(output, "\ndsn: \n") == false || !reflect.DeepEqual(missing, expected[:1]) {
+		t.Error(output, missing)
+	}
+}
+
+func TestReadVarFile(t *testing.T) {
+	vars, err := ReadVarFile("tests/vars.env")
+	if err != nil || !reflect.DeepEqual(vars, []string{"ENV=prod", "TAG=1.0.2", "DSN=user:pass@tcp(db:3306)/app?timeout=5s"}) {
+		t.Errorf("unexpected vars: %v", vars)
+	}
+
+	vars, err = ReadVarFile("tests/vars.yml")
+	if err != nil || !reflect.DeepEqual(vars, []string{"ENV=staging", "REPLICAS=2", "TAG=1.0.3"}) {
+		t.Errorf("unexpected vars: %v", vars)
+	}
+
+	_, err = ReadVarFile("tests/v1/valid.yml")
+	if err == nil {
+		t.Fail()
+	}
+
+	_, err = ReadVarFile("tests/v1/valid.3.yml.env")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["file_not_exist"], "tests/v1/valid.3.yml.env") {
+		t.Fail()
+	}
+}
+
+func TestLoadVars(t *testing.T) {
+	_ = os.Setenv("SWAPPER_TEST_ENV", "from-env")
+	_ = os.Setenv("TAG", "from-env")
+	defer os.Unsetenv("SWAPPER_TEST_ENV")
+	defer os.Unsetenv("TAG")
+
+	vars, err := LoadVars([]string{"ENV=from-var"}, []string{"tests/vars.env", "tests/vars.yml"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, missing := ReplaceVars("${SWAPPER_TEST_ENV} ${TAG} ${ENV} ${DSN}", vars)
+	if output != "from-env 1.0.3 from-var user:pass@tcp(db:3306)/app?timeout=5s" || len(missing) != 0 {
+		t.Errorf("unexpected output: %s", output)
+	}
+
+	vars, _ = LoadVars([]string{}, []string{}, false)
+	if _, missing = ReplaceVars("${SWAPPER_TEST_ENV}", vars); len(missing) != 1 {
+		t.Fail()
+	}
+
+	if _, err = LoadVars([]string{}, []string{"tests/unknown.env"}, false); err == nil {
+		t.Fail()
+	}
+}
+
+func TestValidateRequiredVars(t *testing.T) {
+	input := "version: '1'\nservices:\n  api:\n    ports:\n      - 80:80\n    containers:\n      - image: nginx\n        tag: ${TAG:-latest}\n        environment:\n          ENV: ${ENV:?the environment to deploy to}\n"
+	problems := ValidateString(input, []string{})
+	expected := []Problem{
+		{Line: 8, Column: 14, Level: LevelWarning, Message: "tag \"latest\" of service \"api\" is mutable, nodes may run different versions"},
+		{Line: 10, Column: 16, Level: LevelError, Message: "missing variable ENV: the environment to deploy to"},
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Error(problems)
+	}
+}
@@ -6,13 +6,30 @@ import (
 	"github.com/sachamorard/swapper/response"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
-	"regexp"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 )
 
+var (
+	// NotificationTypes lists the sinks a notification can be sent to
+	NotificationTypes = map[string]bool{"slack": true, "webhook": true, "teams": true, "mattermost": true, "smtp": true}
+
+	// NotificationEvents lists the events a notification can be filtered on
+	NotificationEvents = map[string]bool{"deploy": true, "node-updated": true, "node-failed": true, "rollback": true}
+)
+
+// Yaml reads an untyped yaml document. Through V1Schema, only the keys and the types the schema declares can be read:
+// the schema is the definition of the v1 format, which the reader cannot drift from
 type Yaml struct {
 	data interface{}
+	// field describes the data, nil to read it without schema. path is its place in the document.
+	field *Field
+	path  string
 }
 
 type Container struct {
@@ -29,20 +46,38 @@ type Container struct {
 	HealthRetries int
 	HealthTimeout string
 	ExtraHosts []interface{}
+	Files []File
+}
+
+// DefaultFileMode is the mode of the files mounted into containers, when not set
+const DefaultFileMode os.FileMode = 0444
+
+// File is materialized by the node and mounted read-only into the container
+type File struct {
+	// Path of the file inside the container
+	Path string
+	// Content is a literal content
+	Content string
+	// Secret is an encrypted content (enc:), decrypted by the node
+	Secret string
+	// Source is a path on the node
+	Source string
+	Mode os.FileMode
 }
 
 type Service struct {
 	Name string
 	Ports []string
+	Proxy ProxyOptions
 	Containers []Container
 }
 
 type Frontend struct {
 	Name string
-	Listen int
-	Bind int
+	Port
 	BackendName string
 	ServiceName string
+	Proxy ProxyOptions
 	Containers []Container
 }
 
@@ -51,14 +86,49 @@ type Slack struct {
 	Channel string
 }
 
+type Notification struct {
+	Type string
+	Url string
+	Channel string
+	Headers map[string]string
+	Events []string
+	Retries int
+	Backoff string
+	Template string
+	Smtp Smtp
+}
+
+type Smtp struct {
+	Server string
+	Port int
+	StartTLS bool
+	Username string
+	Password string
+	From string
+	Recipients map[string][]string
+}
+
+type ImageChange struct {
+	Service string
+	Index int
+	OldImage string
+	OldTag string
+	NewImage string
+	NewTag string
+}
+
 type YamlConf struct {
 	Frontends []Frontend
 	Services []Service
 	Hash string
 	Time int64
+	Deployer string
 	Masters []string
 	Slack Slack
+	Notifications []Notification
 	Master Master
+	ProxyStats ProxyStats
+	ProxyLogs ProxyLogs
 }
 
 type Master struct {
@@ -74,6 +144,13 @@ func PrepareSwapperYaml(sourceFile string, vars []string) (cleanYaml string, err
 		return cleanYaml, errors.New(fmt.Sprintf(response.ErrorMessages["file_not_exist"], sourceFile))
 	}
 
+	// Merge included files and expand templates, masters receive a self-contained file
+	included, err := ResolveIncludes(sourceFile, string(input))
+	if err != nil {
+		return cleanYaml, err
+	}
+	input = []byte(included)
+
 	// Unmarshal Yaml to remove comments and clean it
 	var val interface{}
 	errUnmarshal := yaml.Unmarshal([]byte(string(input)), &val)
@@ -81,71 +158,75 @@ func PrepareSwapperYaml(sourceFile string, vars []string) (cleanYaml string, err
 		return cleanYaml, errors.New(response.ErrorMessages["yaml_invalid"])
 	}
 
+	// Inline env files, relative to the yaml file
+	resolved, err := ResolveEnvironment(string(input), filepath.Dir(sourceFile))
+	if err != nil {
+		return cleanYaml, err
+	}
+	if resolved != string(input) {
+		val = nil
+		_ = yaml.Unmarshal([]byte(resolved), &val)
+	}
+
 	d, errMarshal := yaml.Marshal(&val)
 	if errMarshal != nil {
 		return cleanYaml, errors.New(response.ErrorMessages["yaml_invalid"])
 	}
 	cleanYaml = string(d)
 
-	// Transform vars strings to map
-	varMap := make(map[string]string)
-	var keyValue []string
-	for _, e := range vars {
-		if e != "" {
-			keyValue = strings.Split(e, "=")
-			varMap[keyValue[0]] = keyValue[1]
-		}
-	}
-
 	// Replace variables if exist
-	re := regexp.MustCompile(`\${[a-zA-Z0-9_-]+}`)
-	matches := re.FindAllString(cleanYaml, -1)
-	var varname string
-	for _, p := range matches {
-		varname = strings.Replace(strings.Replace(p, "${", "", 1), "}", "", -1)
-		if varMap[varname] != "" {
-			cleanYaml = strings.Replace(cleanYaml, p, varMap[varname], -1)
-		}
-	}
+	cleanYaml, missing := ReplaceVars(cleanYaml, vars)
 
 	// Check whether variables still need to be defined
-	matches = re.FindAllString(cleanYaml, -1)
-	if len(matches) > 0 {
-		varnames := ""
-		examples := ""
-		alreadyDone := map[string]bool{}
-		for _, p := range matches {
-
-			varname = strings.Replace(strings.Replace(p, "${", "", 1), "}", "", -1)
-			if alreadyDone[varname] != true {
-				varnames = varnames + varname + " "
-				examples = examples + "--var " + varname + "=<value> "
-				alreadyDone[varname] = true
-			}
-		}
-		return cleanYaml, errors.New(fmt.Sprintf(response.ErrorMessages["var_missing"], strings.TrimSpace(varnames), strings.TrimSpace(examples)))
+	if len(missing) > 0 {
+		return cleanYaml, errors.New(MissingVarsMessage(missing))
 	}
 
-	return cleanYaml, err
+	// Variables may be floats too
+	return ResolveEnvironment(cleanYaml, filepath.Dir(sourceFile))
 }
 
 func ParseSwapperYaml(yamlStr string) (yamlConf YamlConf, err error) {
 	var val interface{}
 	err = yaml.Unmarshal([]byte(yamlStr), &val)
-	swapperYaml := &Yaml{val}
+	swapperYaml := &Yaml{data: val}
 	if err != nil {
 		return yamlConf, errors.New(response.ErrorMessages["yaml_invalid"])
 	}
 
 	yamlVersion, _ := swapperYaml.Get("version").String()
 	if yamlVersion == "1" {
-		yamlConf, err = InterpretV1(swapperYaml)
-		return yamlConf, err
+		yamlConf, err = interpretV1(swapperYaml, declaredServiceNames(yamlStr))
+		if err != nil {
+			return yamlConf, err
+		}
+		// reject what InterpretV1 would silently ignore (unknown fields, wrong types...)
+		return yamlConf, SchemaErrors(yamlStr)
+	}
+	if yamlVersion == "2" {
+		v2, err := DecodeV2(yamlStr)
+		if err != nil {
+			return yamlConf, err
+		}
+		yamlConf, err = InterpretV2(v2)
+		if err != nil {
+			return yamlConf, err
+		}
+		// formats that types cannot express (durations, ports...)
+		return yamlConf, SchemaErrors(yamlStr)
 	}
 	return yamlConf, errors.New(response.ErrorMessages["yaml_version"])
 }
 
+// InterpretV1 converts a v1 yaml configuration, its services are sorted by name
 func InterpretV1(swapperYaml *Yaml) (yamlConf YamlConf, err error) {
+	return interpretV1(swapperYaml, nil)
+}
+
+// interpretV1 converts a v1 yaml configuration, with the services in the declaration order of serviceNames. They
+// are sorted by name when serviceNames does not match the services of the yaml.
+func interpretV1(swapperYaml *Yaml, serviceNames []string) (yamlConf YamlConf, err error) {
+	swapperYaml = &Yaml{data: swapperYaml.data, field: V1Schema}
 	var services []Service
 	var frontends []Frontend
 
@@ -160,6 +241,8 @@ func InterpretV1(swapperYaml *Yaml) (yamlConf YamlConf, err error) {
 		yamlConf.Time = int64(time)
 	}
 
+	yamlConf.Deployer, _ = swapperYaml.Get("deployer").String()
+
 	mastersInterface, _ := swapperYaml.Get("masters").Array()
 	var masters []string
 	for _, master := range mastersInterface {
@@ -197,15 +280,26 @@ func InterpretV1(swapperYaml *Yaml) (yamlConf YamlConf, err error) {
 		yamlConf.Slack = slack
 	}
 
+	// Notifications
+	notifications, err := interpretNotifications(swapperYaml)
+	if err != nil {
+		return yamlConf, err
+	}
+	yamlConf.Notifications = notifications
+
 	// Services
-	serviceNames, _ := swapperYaml.GetPath("services").GetMapKeys()
-	checkFrontendPort := map[string]bool{}
+	names, _ := swapperYaml.GetPath("services").GetMapKeys()
+	if len(serviceNames) != len(names) {
+		serviceNames = names
+		sort.Strings(serviceNames)
+	}
 	for _, serviceName := range serviceNames {
 
 		// Service
 		serviceYml := swapperYaml.GetPath("services", serviceName)
 		var Service Service
 		Service.Name = serviceName
+		serviceEnvs, _ := serviceYml.Get("environment").Map()
 
 		containerLen, yamlErr := swapperYaml.GetPath("services", serviceName, "containers").GetArraySize()
 		if yamlErr == nil && containerLen > 0 {
@@ -235,7 +329,8 @@ func InterpretV1(swapperYaml *Yaml) (yamlConf YamlConf, err error) {
 				}
 
 				// more
-				Container.Envs, _ = containerYml.Get("environment").Map()
+				containerEnvs, _ := containerYml.Get("environment").Map()
+				Container.Envs = mergeEnvironment(serviceEnvs, containerEnvs)
 				Container.LoggingOptions, _ = containerYml.Get("logging").Get("options").Map()
 				Container.LoggingDriver, _ = containerYml.Get("logging").Get("driver").String()
 				Container.HealthCmd, _ = containerYml.Get("health-cmd").String()
@@ -249,69 +344,311 @@ func InterpretV1(swapperYaml *Yaml) (yamlConf YamlConf, err error) {
 					}
 				}
 				Container.ExtraHosts, _ = containerYml.Get("extra_hosts").Array()
+				Container.Files, err = interpretFiles(containerYml, serviceName)
+				if err != nil {
+					return yamlConf, err
+				}
 				// todo more options
 
 				Service.Containers = append(Service.Containers, Container)
 			}
 		}
-		services = append(services, Service)
 
 		// Binding
 		var servicePorts []string
 		portsLen, _ := serviceYml.Get("ports").GetArraySize()
-		if portsLen > 0 {
-			for o:=0; o<portsLen ;o++  {
-				portStr, _ := serviceYml.Get("ports").GetIndex(o).String()
-				if portStr != "" {
-					splittedPort := strings.Split(portStr, ":")
-					if checkFrontendPort[splittedPort[0]] != true {
-						frontendPort, _ := strconv.Atoi(splittedPort[0])
-						containerPort, _ := strconv.Atoi(splittedPort[1])
-						if frontendPort == 0 || containerPort == 0 {
-							return yamlConf, errors.New(fmt.Sprintf(response.ErrorMessages["ports_invalid"], portStr))
-						}
-						servicePorts = append(servicePorts, portStr)
-						checkFrontendPort[splittedPort[0]] = true
-						// set Frontend
-						var Front Frontend
-						Front.Listen = frontendPort
-						Front.Bind = containerPort
-						Front.Name = "frontend_"+splittedPort[0]
-						Front.BackendName = "backend_"+splittedPort[0]+"_"+splittedPort[1]
-						Front.ServiceName = serviceName
-						Front.Containers = Service.Containers
-						frontends = append(frontends, Front)
-					} else {
-						return yamlConf, errors.New(fmt.Sprintf(response.ErrorMessages["port_conflict"], splittedPort[0]))
-					}
-
-				} else {
-					return yamlConf, errors.New(response.ErrorMessages["ports_empty"])
-				}
-			}
-		} else {
-			return yamlConf, errors.New(fmt.Sprintf(response.ErrorMessages["service_field_needed"], "ports", serviceName))
+		for o := 0; o < portsLen; o++ {
+			portStr, _ := serviceYml.Get("ports").GetIndex(o).String()
+			servicePorts = append(servicePorts, portStr)
+		}
+		Service.Proxy = interpretProxy(serviceYml.Get("proxy"))
+		Service.Proxy.Health = interpretProxyHealth(serviceYml.Get("proxy-health"))
+		Service.Proxy.Sticky = interpretSticky(serviceYml.Get("sticky"))
+		Service.Proxy.RateLimit = interpretRateLimit(serviceYml.Get("rate-limit"))
+		Service.Proxy.Allow = interpretSources(serviceYml.Get("allow"))
+		Service.Proxy.Deny = interpretSources(serviceYml.Get("deny"))
+		if err := checkProxy(Service.Proxy, serviceName, servicePorts); err != nil {
+			return yamlConf, err
 		}
+		serviceFrontends, err := bindPorts(Service, servicePorts, frontends)
+		if err != nil {
+			return yamlConf, err
+		}
+		frontends = append(frontends, serviceFrontends...)
 		Service.Ports = servicePorts
+		services = append(services, Service)
 	}
 
 	yamlConf.Services = services
 	yamlConf.Frontends = frontends
+
+	yamlConf.ProxyLogs = interpretProxyLogs(swapperYaml.Get("proxy-logs"))
+	if err = checkProxyLogs(yamlConf.ProxyLogs); err != nil {
+		return
+	}
+	yamlConf.ProxyStats = interpretProxyStats(swapperYaml.Get("proxy-stats"))
+	err = checkProxyStats(yamlConf.ProxyStats, frontends)
 	return
 }
 
+// bindPorts creates the frontends of the ports of a service, bound keeps the frontends of the previous services to
+// reject ports already bound
+func bindPorts(service Service, ports []string, bound []Frontend) (frontends []Frontend, err error) {
+	if len(ports) == 0 {
+		return frontends, errors.New(fmt.Sprintf(response.ErrorMessages["service_field_needed"], "ports", service.Name))
+	}
+	for _, portStr := range ports {
+		if portStr == "" {
+			return frontends, errors.New(response.ErrorMessages["ports_empty"])
+		}
+		port, err := ParsePort(portStr)
+		if err != nil {
+			return frontends, errors.New(fmt.Sprintf(response.ErrorMessages["ports_invalid"], portStr))
+		}
+		for _, frontend := range append(bound, frontends...) {
+			if port.Overlaps(frontend.Port) {
+				return frontends, errors.New(fmt.Sprintf(response.ErrorMessages["port_conflict"], strings.TrimSuffix(port.Published(), "/"+ProtocolTcp)))
+			}
+		}
+		name, backendName := frontendNames(port)
+		frontends = append(frontends, Frontend{
+			Name:        name,
+			Port:        port,
+			BackendName: backendName,
+			ServiceName: service.Name,
+			Proxy:       service.Proxy,
+			Containers:  service.Containers,
+		})
+	}
+	return frontends, nil
+}
+
+func interpretFiles(containerYml *Yaml, serviceName string) (files []File, err error) {
+	filesLen, _ := containerYml.Get("files").GetArraySize()
+	for i := 0; i < filesLen; i++ {
+		fileYml := containerYml.Get("files").GetIndex(i)
+
+		var file File
+		file.Path, _ = fileYml.Get("path").String()
+		file.Content, _ = fileYml.Get("content").String()
+		file.Secret, _ = fileYml.Get("secret").String()
+		file.Source, _ = fileYml.Get("source").String()
+		sources := 0
+		for _, key := range []string{"content", "secret", "source"} {
+			if fileYml.Get(key).data != nil {
+				sources++
+			}
+		}
+		mode, _ := fileYml.Get("mode").String()
+		if err := checkFile(&file, sources, mode, i, serviceName); err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// checkFile checks the file #i of a service, which must have exactly one of content, secret and source, and sets
+// its mode
+func checkFile(file *File, sources int, mode string, i int, serviceName string) error {
+	if strings.HasPrefix(file.Path, "/") == false {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["file_field_needed"], "path", i, serviceName))
+	}
+	if sources != 1 {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["file_field_needed"], "content|secret|source", i, serviceName))
+	}
+	file.Mode = DefaultFileMode
+	if mode != "" {
+		parsed, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			return errors.New(fmt.Sprintf(response.ErrorMessages["file_field_needed"], "mode", i, serviceName))
+		}
+		file.Mode = os.FileMode(parsed)
+	}
+	return nil
+}
+
+func interpretNotifications(swapperYaml *Yaml) (notifications []Notification, err error) {
+	notificationsLen, _ := swapperYaml.Get("notifications").GetArraySize()
+	for i := 0; i < notificationsLen; i++ {
+		notificationYml := swapperYaml.Get("notifications").GetIndex(i)
+
+		var notification Notification
+		notification.Type, _ = notificationYml.Get("type").String()
+		if notification.Type == "smtp" {
+			notification.Smtp, err = interpretSmtp(notificationYml, i)
+			if err != nil {
+				return notifications, err
+			}
+		} else {
+			notification.Url, _ = notificationYml.Get("url").String()
+		}
+
+		notification.Channel, _ = notificationYml.Get("channel").String()
+
+		headers, _ := notificationYml.Get("headers").Map()
+		if len(headers) > 0 {
+			notification.Headers = map[string]string{}
+			for k, v := range headers {
+				key, okKey := k.(string)
+				value, okValue := v.(string)
+				if okKey == false || okValue == false {
+					return notifications, errors.New(fmt.Sprintf(response.ErrorMessages["notification_field_needed"], "headers", i))
+				}
+				notification.Headers[key] = value
+			}
+		}
+
+		events, _ := notificationYml.Get("events").Array()
+		for _, e := range events {
+			event, ok := e.(string)
+			if ok == false {
+				return notifications, errors.New(fmt.Sprintf(response.ErrorMessages["notification_field_needed"], "events", i))
+			}
+			notification.Events = append(notification.Events, event)
+		}
+
+		notification.Retries, _ = notificationYml.Get("retries").Int()
+		notification.Backoff, _ = notificationYml.Get("backoff").String()
+		notification.Template, _ = notificationYml.Get("template").String()
+
+		if err := checkNotification(notification, i); err != nil {
+			return notifications, err
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, nil
+}
+
+func interpretSmtp(notificationYml *Yaml, i int) (smtp Smtp, err error) {
+	smtp.Server, _ = notificationYml.Get("server").String()
+	smtp.Port, _ = notificationYml.Get("port").Int()
+	if smtp.Port == 0 {
+		smtp.Port = 25
+	}
+	smtp.StartTLS, _ = notificationYml.Get("starttls").Bool()
+	smtp.Username, _ = notificationYml.Get("username").String()
+	smtp.Password, _ = notificationYml.Get("password").String()
+	smtp.From, _ = notificationYml.Get("from").String()
+
+	recipients, _ := notificationYml.Get("recipients").Map()
+	smtp.Recipients = map[string][]string{}
+	for k, v := range recipients {
+		event, okEvent := k.(string)
+		addresses, okAddresses := v.([]interface{})
+		if okEvent == false || okAddresses == false {
+			return smtp, errors.New(fmt.Sprintf(response.ErrorMessages["notification_field_needed"], "recipients", i))
+		}
+		for _, a := range addresses {
+			address, ok := a.(string)
+			if ok == false {
+				return smtp, errors.New(fmt.Sprintf(response.ErrorMessages["notification_field_needed"], "recipients", i))
+			}
+			smtp.Recipients[event] = append(smtp.Recipients[event], address)
+		}
+	}
+	return smtp, nil
+}
+
+// checkNotification checks the values of the notification #i, whatever the version of the yaml
+func checkNotification(notification Notification, i int) error {
+	invalid := func(field string) error {
+		return errors.New(fmt.Sprintf(response.ErrorMessages["notification_field_needed"], field, i))
+	}
+	if NotificationTypes[notification.Type] != true {
+		return invalid("type")
+	}
+	if notification.Type == "smtp" {
+		if notification.Smtp.Server == "" {
+			return invalid("server")
+		}
+		if notification.Smtp.From == "" {
+			return invalid("from")
+		}
+		if len(notification.Smtp.Recipients) == 0 {
+			return invalid("recipients")
+		}
+		for event, addresses := range notification.Smtp.Recipients {
+			for _, address := range addresses {
+				if NotificationEvents[event] != true || address == "" {
+					return invalid("recipients")
+				}
+			}
+		}
+	} else if notification.Url == "" {
+		return invalid("url")
+	}
+	for _, event := range notification.Events {
+		if NotificationEvents[event] != true {
+			return invalid("events")
+		}
+	}
+	if notification.Retries < 0 {
+		return invalid("retries")
+	}
+	if notification.Backoff != "" {
+		if _, err := time.ParseDuration(notification.Backoff); err != nil {
+			return invalid("backoff")
+		}
+	}
+	if notification.Template != "" {
+		if _, err := template.New("notification").Parse(notification.Template); err != nil {
+			return invalid("template")
+		}
+	}
+	return nil
+}
+
+// ImageChanges lists the containers whose image or tag differs between two yaml configurations
+func ImageChanges(oldConf YamlConf, newConf YamlConf) (changes []ImageChange) {
+	oldContainers := map[string]Container{}
+	for _, service := range oldConf.Services {
+		for _, container := range service.Containers {
+			oldContainers[service.Name+"."+strconv.Itoa(container.Index)] = container
+		}
+	}
+
+	for _, service := range newConf.Services {
+		for _, container := range service.Containers {
+			key := service.Name + "." + strconv.Itoa(container.Index)
+			oldContainer, exists := oldContainers[key]
+			delete(oldContainers, key)
+			if exists && oldContainer.Image == container.Image && oldContainer.Tag == container.Tag {
+				continue
+			}
+			changes = append(changes, ImageChange{Service: service.Name, Index: container.Index, OldImage: oldContainer.Image, OldTag: oldContainer.Tag, NewImage: container.Image, NewTag: container.Tag})
+		}
+	}
+
+	for _, service := range oldConf.Services {
+		for _, container := range service.Containers {
+			if _, removed := oldContainers[service.Name+"."+strconv.Itoa(container.Index)]; removed {
+				changes = append(changes, ImageChange{Service: service.Name, Index: container.Index, OldImage: container.Image, OldTag: container.Tag})
+			}
+		}
+	}
+	return changes
+}
+
 // Get returns a pointer to a new `Yaml` object for `key` in its `map` representation
 //
 // Example:
 //      y.Get("xx").Get("yy").Int()
 func (y *Yaml) Get(key interface{}) *Yaml {
-	m, err := y.Map()
+	child := &Yaml{path: strings.TrimPrefix(y.path+"."+fmt.Sprint(key), ".")}
+	if y.field != nil {
+		child.field = y.field.child(fmt.Sprint(key))
+		if child.field == nil {
+			panic("yaml: " + child.path + " is read but not declared in the schema")
+		}
+	}
+	m, err := y.mapValue()
 	if err == nil {
 		if val, ok := m[key]; ok {
-			return &Yaml{val}
+			child.data = val
 		}
 	}
-	return &Yaml{nil}
+	return child
 }
 
 // GetPath searches for the item as specified by the branch
@@ -328,6 +665,11 @@ func (y *Yaml) GetPath(branch ...interface{}) *Yaml {
 
 // Array type asserts to an `array`
 func (y *Yaml) Array() ([]interface{}, error) {
+	y.readAs(TypeArray)
+	return y.arrayValue()
+}
+
+func (y *Yaml) arrayValue() ([]interface{}, error) {
 	if a, ok := (y.data).([]interface{}); ok {
 		return a, nil
 	}
@@ -355,17 +697,23 @@ func (y *Yaml) GetArraySize() (int, error) {
 // Example:
 //      y.Get("xx").GetIndex(1).String()
 func (y *Yaml) GetIndex(index int) *Yaml {
-	a, err := y.Array()
+	child := &Yaml{path: y.path + "[" + strconv.Itoa(index) + "]"}
+	if y.field != nil {
+		y.readAs(TypeArray)
+		child.field = y.field.Items
+	}
+	a, err := y.arrayValue()
 	if err == nil {
 		if len(a) > index {
-			return &Yaml{a[index]}
+			child.data = a[index]
 		}
 	}
-	return &Yaml{nil}
+	return child
 }
 
 // Int type asserts to `int`
 func (y *Yaml) Int() (int, error) {
+	y.readAs(TypeInteger)
 	if v, ok := (y.data).(int); ok {
 		return v, nil
 	}
@@ -374,6 +722,7 @@ func (y *Yaml) Int() (int, error) {
 
 // Bool type asserts to `bool`
 func (y *Yaml) Bool() (bool, error) {
+	y.readAs(TypeBoolean)
 	if v, ok := (y.data).(bool); ok {
 		return v, nil
 	}
@@ -382,6 +731,7 @@ func (y *Yaml) Bool() (bool, error) {
 
 // String type asserts to `string`
 func (y *Yaml) String() (string, error) {
+	y.readAs(TypeString)
 	if v, ok := (y.data).(string); ok {
 		return v, nil
 	}
@@ -389,6 +739,7 @@ func (y *Yaml) String() (string, error) {
 }
 
 func (y *Yaml) Float() (float64, error) {
+	y.readAs(TypeScalar)
 	if v, ok := (y.data).(float64); ok {
 		return v, nil
 	}
@@ -397,6 +748,11 @@ func (y *Yaml) Float() (float64, error) {
 
 // Map type asserts to `map`
 func (y *Yaml) Map() (map[interface{}]interface{}, error) {
+	y.readAs(TypeObject)
+	return y.mapValue()
+}
+
+func (y *Yaml) mapValue() (map[interface{}]interface{}, error) {
 	if m, ok := (y.data).(map[interface{}]interface{}); ok {
 		return m, nil
 	}
@@ -424,3 +780,10 @@ func (y *Yaml) GetMapKeys() ([]string, error) {
 	}
 	return keys, nil
 }
+
+// readAs panics when the schema of the data does not declare it with a type readable as typ
+func (y *Yaml) readAs(typ string) {
+	if y.field != nil && y.field.readableAs(typ) == false {
+		panic("yaml: " + y.path + " is read as " + typ + " but declared as " + y.field.Type + " in the schema")
+	}
+}
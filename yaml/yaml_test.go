@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/sachamorard/swapper/response"
 	"io/ioutil"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -99,6 +101,108 @@ func TestParseSwapperYaml(t *testing.T) {
 	}
 }
 
+func TestInterpretNotifications(t *testing.T) {
+	input, _ := ioutil.ReadFile("tests/v1/valid.3.yml")
+	yamlConf, err := ParseSwapperYaml(string(input))
+	if err != nil {
+		t.Fail()
+	}
+	if len(yamlConf.Notifications) != 5 {
+		t.Fail()
+	}
+	smtp := yamlConf.Notifications[4].Smtp
+	if smtp.Server != "smtp.example.com" || smtp.Port != 587 || smtp.StartTLS != true || smtp.From != "swapper@example.com" || smtp.Recipients["node-failed"][0] != "ops@example.com" {
+		t.Fail()
+	}
+
+	_, err = ParseSwapperYaml("version: '1'\nnotifications:\n  - type: smtp\n    server: smtp.example.com\n    from: swapper@example.com")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["notification_field_needed"], "recipients", 0) {
+		t.Fail()
+	}
+	if yamlConf.Notifications[3].Template == "" {
+		t.Fail()
+	}
+	webhook := yamlConf.Notifications[1]
+	if webhook.Type != "webhook" || webhook.Headers["Authorization"] != "Bearer mytoken" || len(webhook.Events) != 2 || webhook.Retries != 3 || webhook.Backoff != "2s" {
+		t.Fail()
+	}
+
+	input, _ = ioutil.ReadFile("tests/v1/invalid.11.yml")
+	_, err = ParseSwapperYaml(string(input))
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["notification_field_needed"], "type", 0) {
+		t.Fail()
+	}
+
+	_, err = ParseSwapperYaml("version: '1'\nnotifications:\n  - type: webhook\n    url: https://example.com\n    template: '{{.File'")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["notification_field_needed"], "template", 0) {
+		t.Fail()
+	}
+
+	input, _ = ioutil.ReadFile("tests/v1/invalid.12.yml")
+	_, err = ParseSwapperYaml(string(input))
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["notification_field_needed"], "events", 0) {
+		t.Fail()
+	}
+}
+
+func TestInterpretFiles(t *testing.T) {
+	input, _ := ioutil.ReadFile("../doc/yml-examples/10.with.files.yml")
+	cleanYaml, _ := ReplaceVars(string(input), []string{"TLS_KEY=enc:a2V5"})
+	yamlConf, err := ParseSwapperYaml(cleanYaml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := yamlConf.Services[0].Containers[0].Files
+	if len(files) != 3 {
+		t.Fatal(files)
+	}
+	if files[0].Path != "/etc/nginx/conf.d/default.conf" || strings.Contains(files[0].Content, "listen 443 ssl;") == false || files[0].Mode != DefaultFileMode {
+		t.Error(files[0])
+	}
+	if files[1].Secret != "enc:a2V5" || files[1].Mode != 0400 {
+		t.Error(files[1])
+	}
+	if files[2].Source != "/etc/ssl/certs/my-app.crt" || files[2].Content != "" {
+		t.Error(files[2])
+	}
+
+	input, _ = ioutil.ReadFile("tests/v1/invalid.14.yml")
+	_, err = ParseSwapperYaml(string(input))
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["file_field_needed"], "content|secret|source", 0, "my-app") {
+		t.Error(err)
+	}
+
+	_, err = ParseSwapperYaml("version: '1'\nservices:\n  api:\n    ports: ['80:80']\n    containers:\n      - image: nginx\n        tag: '1'\n        files:\n          - path: nginx.conf\n            content: x")
+	if err == nil || err.Error() != fmt.Sprintf(response.ErrorMessages["file_field_needed"], "path", 0, "api") {
+		t.Error(err)
+	}
+}
+
+func TestImageChanges(t *testing.T) {
+	oldConf := YamlConf{Services: []Service{
+		{Name: "api", Containers: []Container{{Index: 0, Image: "nginx", Tag: "1.16.0"}, {Index: 1, Image: "nginx", Tag: "1.16.0"}}},
+		{Name: "old", Containers: []Container{{Index: 0, Image: "redis", Tag: "5"}}},
+	}}
+	newConf := YamlConf{Services: []Service{
+		{Name: "api", Containers: []Container{{Index: 0, Image: "nginx", Tag: "1.17.0"}, {Index: 1, Image: "nginx", Tag: "1.16.0"}}},
+		{Name: "new", Containers: []Container{{Index: 0, Image: "mysql", Tag: "8"}}},
+	}}
+
+	changes := ImageChanges(oldConf, newConf)
+	expected := []ImageChange{
+		{Service: "api", Index: 0, OldImage: "nginx", OldTag: "1.16.0", NewImage: "nginx", NewTag: "1.17.0"},
+		{Service: "new", Index: 0, NewImage: "mysql", NewTag: "8"},
+		{Service: "old", Index: 0, OldImage: "redis", OldTag: "5"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fail()
+	}
+
+	if len(ImageChanges(newConf, newConf)) != 0 {
+		t.Fail()
+	}
+}
+
 func TestInterpretV1(t *testing.T) {
 	input, _ := ioutil.ReadFile("swapper.yml")
 	_, _ = ParseSwapperYaml(string(input))